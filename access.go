@@ -0,0 +1,416 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/shoce/tg"
+)
+
+const (
+	AccessRoleAdmin = "admin"
+	AccessRoleUser  = "user"
+
+	AccessQuotaJobsDefault  = 100
+	AccessQuotaBytesDefault = 4 << 30
+
+	AccessRefusalDefault = "sorry, this bot works only for the users and the chats allowed by its admins, send /id to an admin to ask for access"
+
+	TgCommandAllowDefault  = "/allow"
+	TgCommandDenyDefault   = "/deny"
+	TgCommandQuotaDefault  = "/quota"
+	TgCommandAccessDefault = "/access"
+)
+
+// AccessEntry is an allowed user or chat, users have positive ids and groups and channels negative ones,
+// zero quotas mean AccessQuotaJobs and AccessQuotaBytes and negative quotas mean no limit
+type AccessEntry struct {
+	Id int64 `yaml:"Id"`
+	// Role is AccessRoleAdmin or AccessRoleUser, chats are always users
+	Role string `yaml:"Role"`
+
+	QuotaJobs  int64 `yaml:"QuotaJobs"`
+	QuotaBytes int64 `yaml:"QuotaBytes"`
+}
+
+// AccessUsage is the number of jobs and the downloaded bytes of the user or the chat on the day
+type AccessUsage struct {
+	Id    int64  `yaml:"Id"`
+	Day   string `yaml:"Day"`
+	Jobs  int64  `yaml:"Jobs"`
+	Bytes int64  `yaml:"Bytes"`
+}
+
+// AccessUsageState is the usage kept in its own store next to the config
+type AccessUsageState struct {
+	AccessUsage []AccessUsage `yaml:"AccessUsage"`
+}
+
+var (
+	AccessUsageStore ConfigStore
+	accessUsageState AccessUsageState
+	accessUsageMu    sync.Mutex
+)
+
+type accessBytesKey struct{}
+
+func accessDay() string {
+	return time.Now().UTC().Format(time.DateOnly)
+}
+
+// accessIds returns the user and the chat the message is accounted to, private chats and channel posts have only one of them
+func accessIds(m tg.Message) (ids []int64) {
+	if m.From.Id != 0 {
+		ids = append(ids, m.From.Id)
+	}
+	if m.Chat.Id != 0 && m.Chat.Id != m.From.Id {
+		ids = append(ids, m.Chat.Id)
+	}
+	return ids
+}
+
+// accessEntry returns the entry of the id, ConfigMu must be held
+func accessEntry(id int64) (e AccessEntry, ok bool) {
+	if i := slices.IndexFunc(Config.AccessList, func(e AccessEntry) bool { return e.Id == id }); i >= 0 {
+		return Config.AccessList[i], true
+	}
+	return AccessEntry{Id: id, Role: AccessRoleUser}, false
+}
+
+// accessUsage returns the usage of the id today
+func accessUsage(id int64) AccessUsage {
+	accessUsageMu.Lock()
+	defer accessUsageMu.Unlock()
+
+	day := accessDay()
+	if i := slices.IndexFunc(accessUsageState.AccessUsage, func(u AccessUsage) bool { return u.Id == id && u.Day == day }); i >= 0 {
+		return accessUsageState.AccessUsage[i]
+	}
+	return AccessUsage{Id: id, Day: day}
+}
+
+// AccessUsageInit reads the usage from its store once,
+// the usage in the config written by older versions is moved there,
+// ConfigMu is locked before accessUsageMu as accessQuota does
+func AccessUsageInit() error {
+	ConfigMu.Lock()
+	defer ConfigMu.Unlock()
+	accessUsageMu.Lock()
+	defer accessUsageMu.Unlock()
+
+	AccessUsageStore = ConfigStoreCl.Sub("access")
+	accessUsageState = AccessUsageState{}
+	if err := StateGet(AccessUsageStore, &accessUsageState); err != nil {
+		return fmt.Errorf("StateGet access %w", err)
+	}
+
+	if len(Config.AccessUsage) == 0 {
+		return nil
+	}
+	Log.Info("moving access usage from config", "entries", len(Config.AccessUsage))
+	for _, u := range Config.AccessUsage {
+		if !slices.ContainsFunc(accessUsageState.AccessUsage, func(u2 AccessUsage) bool { return u2.Id == u.Id && u2.Day == u.Day }) {
+			accessUsageState.AccessUsage = append(accessUsageState.AccessUsage, u)
+		}
+	}
+	if err := StatePut(AccessUsageStore, accessUsageState); err != nil {
+		return fmt.Errorf("StatePut access %w", err)
+	}
+	Config.AccessUsage = nil
+	return Config.Put()
+}
+
+// AccessUsageMigrate moves the usage of the group upgraded to a supergroup to the supergroup id
+func AccessUsageMigrate(from, to int64) error {
+	accessUsageMu.Lock()
+	defer accessUsageMu.Unlock()
+
+	var migrated bool
+	for i := range accessUsageState.AccessUsage {
+		if accessUsageState.AccessUsage[i].Id == from {
+			accessUsageState.AccessUsage[i].Id = to
+			migrated = true
+		}
+	}
+	if !migrated {
+		return nil
+	}
+
+	return StatePut(AccessUsageStore, accessUsageState)
+}
+
+func (e AccessEntry) Quotas() (jobs, bytes int64) {
+	jobs, bytes = e.QuotaJobs, e.QuotaBytes
+	if jobs == 0 {
+		jobs = ConfigNow().AccessQuotaJobs
+	}
+	if bytes == 0 {
+		bytes = ConfigNow().AccessQuotaBytes
+	}
+	return jobs, bytes
+}
+
+// AccessIsAdmin reports if the user is the bot owner TgZeChatId or has AccessRoleAdmin
+func AccessIsAdmin(userid int64) bool {
+	if userid == 0 {
+		return false
+	}
+	if userid == ConfigNow().TgZeChatId {
+		return true
+	}
+	ConfigMu.Lock()
+	defer ConfigMu.Unlock()
+	e, ok := accessEntry(userid)
+	return ok && e.Role == AccessRoleAdmin
+}
+
+// AccessCheck returns the refusal text if the message is not allowed to put the jobs, empty if it is allowed.
+// Admins have no quotas, others need an allowed user or chat unless AccessOpen is set and then
+// the quotas of both the user and the chat are checked.
+func AccessCheck(m tg.Message, jobs int64) (refusal string) {
+	if AccessIsAdmin(m.From.Id) || m.Chat.Id == ConfigNow().TgZeChatId {
+		return ""
+	}
+
+	ConfigMu.Lock()
+	defer ConfigMu.Unlock()
+
+	ids := accessIds(m)
+	if !ConfigNow().AccessOpen && !slices.ContainsFunc(ids, func(id int64) bool { _, ok := accessEntry(id); return ok }) {
+		return ConfigNow().AccessRefusal
+	}
+
+	return accessQuota(m, ids, jobs)
+}
+
+// AccessQuota returns the refusal text if the jobs put for the chat of the message are over its quota, empty if they are not.
+// It is checked by the subscription and the watch pollers which were allowed when the chat subscribed.
+func AccessQuota(m tg.Message, jobs int64) (refusal string) {
+	if m.Chat.Id == ConfigNow().TgZeChatId {
+		return ""
+	}
+
+	ConfigMu.Lock()
+	defer ConfigMu.Unlock()
+
+	return accessQuota(m, accessIds(m), jobs)
+}
+
+// accessQuota checks the quotas of the ids, ConfigMu must be held
+func accessQuota(m tg.Message, ids []int64, jobs int64) (refusal string) {
+	for _, id := range ids {
+		e, _ := accessEntry(id)
+		quotajobs, quotabytes := e.Quotas()
+		u := accessUsage(id)
+		if (quotajobs >= 0 && u.Jobs+jobs > quotajobs) || (quotabytes >= 0 && u.Bytes >= quotabytes) {
+			what := "your"
+			if id == m.Chat.Id {
+				what = "this chat's"
+			}
+			return F("sorry, %s daily quota of <%d> jobs and <%d>mb is used up, <%d> jobs and <%d>mb today, try again tomorrow", what, quotajobs, quotabytes>>20, u.Jobs, u.Bytes>>20)
+		}
+	}
+
+	return ""
+}
+
+// AccessUse adds the jobs and the downloaded bytes to the usage of the user and the chat of the message
+func AccessUse(m tg.Message, jobs, bytes int64) error {
+	if jobs == 0 && bytes == 0 {
+		return nil
+	}
+
+	accessUsageMu.Lock()
+	defer accessUsageMu.Unlock()
+
+	day := accessDay()
+	accessUsageState.AccessUsage = slices.DeleteFunc(accessUsageState.AccessUsage, func(u AccessUsage) bool { return u.Day != day })
+	for _, id := range accessIds(m) {
+		u := AccessUsage{Id: id, Day: day}
+		if i := slices.IndexFunc(accessUsageState.AccessUsage, func(u2 AccessUsage) bool { return u2.Id == id }); i >= 0 {
+			u = accessUsageState.AccessUsage[i]
+			accessUsageState.AccessUsage = slices.Delete(accessUsageState.AccessUsage, i, i+1)
+		}
+		u.Jobs += jobs
+		u.Bytes += bytes
+		accessUsageState.AccessUsage = append(accessUsageState.AccessUsage, u)
+	}
+
+	return StatePut(AccessUsageStore, accessUsageState)
+}
+
+// AccessSeed adds the chats the bot knows to AccessList once, so closing the access with AccessOpen
+// does not refuse the channels, the chats with settings, the subscriptions, the watches
+// and the private chats and the groups in the posted history of before, TgPostedInit must be done
+func AccessSeed() error {
+	posted := TgPostedChatIds()
+
+	ConfigMu.Lock()
+	defer ConfigMu.Unlock()
+
+	if Config.AccessSeeded {
+		return nil
+	}
+
+	ids := append(slices.Clone(Config.TgAllChannelsChatIds), posted...)
+	for _, cs := range Config.ChatSettings {
+		ids = append(ids, cs.ChatId)
+	}
+	for _, s := range Config.YtSubscriptions {
+		ids = append(ids, s.ChatId)
+	}
+	for _, w := range Config.YtListWatches {
+		ids = append(ids, w.ChatId)
+	}
+	var seeded int
+	for _, id := range ids {
+		if _, ok := accessEntry(id); ok || id == 0 {
+			continue
+		}
+		Config.AccessList = append(Config.AccessList, AccessEntry{Id: id, Role: AccessRoleUser})
+		seeded++
+	}
+	Log.Info("access list seeded", "seeded", seeded)
+
+	Config.AccessSeeded = true
+	return Config.Put()
+}
+
+// AccessBytesContext returns the context counting the bytes downloaded through AccessReader into the counter
+func AccessBytesContext(ctx context.Context, counter *atomic.Int64) context.Context {
+	return context.WithValue(ctx, accessBytesKey{}, counter)
+}
+
+// AccessReader returns the reader adding the read bytes to the counter of the context
+func AccessReader(ctx context.Context, r io.Reader) io.Reader {
+	counter, _ := ctx.Value(accessBytesKey{}).(*atomic.Int64)
+	if counter == nil {
+		return r
+	}
+	return accessReader{r: r, counter: counter}
+}
+
+type accessReader struct {
+	r       io.Reader
+	counter *atomic.Int64
+}
+
+func (ar accessReader) Read(p []byte) (n int, err error) {
+	n, err = ar.r.Read(p)
+	ar.counter.Add(int64(n))
+	return n, err
+}
+
+// tgAccessRefuse replies the refusal if the message is not allowed to put the jobs
+func tgAccessRefuse(m tg.Message, jobs int64) (refused bool, err error) {
+	refusal := AccessCheck(m, jobs)
+	if refusal == "" {
+		return false, nil
+	}
+	Log.Info("access refused", "chat_id", m.Chat.Id, "user_id", m.From.Id, "jobs", jobs, "refusal", refusal)
+	return true, tgReply(m, refusal)
+}
+
+// processTgAccessCommand handles the admin commands:
+// /allow <id> [admin|user] adds the user or the chat, /deny <id> removes it,
+// /quota <id> <jobs> <mb> sets its daily quotas and /access lists the allowed users and chats with their usage today
+func processTgAccessCommand(m tg.Message, mtff []string) error {
+	if !AccessIsAdmin(m.From.Id) {
+		return tgReply(m, "only bot admins can manage the access")
+	}
+
+	command := strings.SplitN(mtff[0], "@", 2)[0]
+	if command == ConfigNow().TgCommandAccess {
+		return tgReply(m, AccessText())
+	}
+
+	if len(mtff) < 2 {
+		return tgReply(m, F("usage: %s <id> [admin|user], %s <id>, %s <id> <jobs> <mb>", ConfigNow().TgCommandAllow, ConfigNow().TgCommandDeny, ConfigNow().TgCommandQuota))
+	}
+	id, err := strconv.ParseInt(mtff[1], 10, 64)
+	if err != nil || id == 0 {
+		return tgReply(m, F("not a user or chat id [%s], get it with /id", mtff[1]))
+	}
+
+	var text string
+	ConfigMu.Lock()
+	e, ok := accessEntry(id)
+	switch command {
+	case ConfigNow().TgCommandAllow:
+		e.Role = AccessRoleUser
+		if len(mtff) == 3 {
+			e.Role = mtff[2]
+		}
+		if !slices.Contains([]string{AccessRoleAdmin, AccessRoleUser}, e.Role) || (e.Role == AccessRoleAdmin && id < 0) {
+			ConfigMu.Unlock()
+			return tgReply(m, F("no role [%s], users are admin or user and chats are user", e.Role))
+		}
+		text = F("allowed id <%d> role %s", id, e.Role)
+	case ConfigNow().TgCommandDeny:
+		if !ok {
+			ConfigMu.Unlock()
+			return tgReply(m, F("id <%d> is not allowed", id))
+		}
+		text = F("denied id <%d>", id)
+	case ConfigNow().TgCommandQuota:
+		if !ok {
+			ConfigMu.Unlock()
+			return tgReply(m, F("id <%d> is not allowed, %s it first", id, ConfigNow().TgCommandAllow))
+		}
+		var jobs, mb int64
+		var err1, err2 error
+		if len(mtff) == 4 {
+			jobs, err1 = strconv.ParseInt(mtff[2], 10, 64)
+			mb, err2 = strconv.ParseInt(mtff[3], 10, 64)
+		}
+		if len(mtff) != 4 || err1 != nil || err2 != nil || mb > math.MaxInt64>>20 || mb < math.MinInt64>>20 {
+			ConfigMu.Unlock()
+			return tgReply(m, F("usage: %s <id> <jobs> <mb>, zero is the default and negative is no limit", ConfigNow().TgCommandQuota))
+		}
+		e.QuotaJobs, e.QuotaBytes = jobs, mb<<20
+		quotajobs, quotabytes := e.Quotas()
+		text = F("id <%d> daily quota <%d> jobs <%d>mb", id, quotajobs, quotabytes>>20)
+	}
+
+	Config.AccessList = slices.DeleteFunc(Config.AccessList, func(e2 AccessEntry) bool { return e2.Id == id })
+	if command != ConfigNow().TgCommandDeny {
+		Config.AccessList = append(Config.AccessList, e)
+	}
+	err = Config.Put()
+	ConfigMu.Unlock()
+	if err != nil {
+		return fmt.Errorf("Config.Put %w", err)
+	}
+
+	Log.Info("access changed", "by_user_id", m.From.Id, "command", command, "id", id, "role", e.Role, "quota_jobs", e.QuotaJobs, "quota_bytes", e.QuotaBytes)
+	return tgReply(m, text)
+}
+
+// AccessText lists the allowed users and chats with their roles, quotas and usage today
+func AccessText() string {
+	ConfigMu.Lock()
+	defer ConfigMu.Unlock()
+
+	mode := "only allowed users and chats"
+	if ConfigNow().AccessOpen {
+		mode = "open to everyone"
+	}
+	tt := []string{
+		F("access %s, default daily quota <%d> jobs <%d>mb", mode, ConfigNow().AccessQuotaJobs, ConfigNow().AccessQuotaBytes>>20),
+		F("owner id <%d>", ConfigNow().TgZeChatId),
+	}
+	for _, e := range Config.AccessList {
+		quotajobs, quotabytes := e.Quotas()
+		u := accessUsage(e.Id)
+		tt = append(tt, F("id <%d> %s quota <%d> jobs <%d>mb used <%d> jobs <%d>mb", e.Id, e.Role, quotajobs, quotabytes>>20, u.Jobs, u.Bytes>>20))
+	}
+	return strings.Join(tt, NL)
+}
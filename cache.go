@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/shoce/tg"
+)
+
+const (
+	TgFileCacheMaxSizeDefault = 1000
+
+	TgCommandStatsDefault = "/stats"
+)
+
+// TgFileCacheEntry is a file sent to telegram before that can be sent again by file_id
+type TgFileCacheEntry struct {
+	// Key is the video id, the media and the quality from tgFileCacheKey
+	Key    string `yaml:"Key"`
+	FileId string `yaml:"FileId"`
+
+	// Caption is the caption without the list line
+	Caption  string        `yaml:"Caption"`
+	Duration time.Duration `yaml:"Duration"`
+	Width    int           `yaml:"Width,omitempty"`
+	Height   int           `yaml:"Height,omitempty"`
+
+	Time time.Time `yaml:"Time"`
+}
+
+// TgFileCacheState is the file cache kept in its own store next to the config
+type TgFileCacheState struct {
+	TgFileCache []TgFileCacheEntry `yaml:"TgFileCache"`
+}
+
+var (
+	TgFileCacheHits   atomic.Int64
+	TgFileCacheMisses atomic.Int64
+
+	TgFileCacheStore ConfigStore
+	tgFileCache      TgFileCacheState
+	tgFileCacheMu    sync.Mutex
+)
+
+// TgFileCacheInit reads the file cache from its store once,
+// the entries of the config written by older versions are moved there
+func TgFileCacheInit() error {
+	tgFileCacheMu.Lock()
+	defer tgFileCacheMu.Unlock()
+
+	TgFileCacheStore = ConfigStoreCl.Sub("filecache")
+	tgFileCache = TgFileCacheState{}
+	if err := StateGet(TgFileCacheStore, &tgFileCache); err != nil {
+		return fmt.Errorf("StateGet filecache %w", err)
+	}
+
+	ConfigMu.Lock()
+	defer ConfigMu.Unlock()
+
+	if len(Config.TgFileCache) == 0 {
+		return nil
+	}
+	LogCache.Info("moving file cache from config", "entries", len(Config.TgFileCache))
+	for _, e := range Config.TgFileCache {
+		if !slices.ContainsFunc(tgFileCache.TgFileCache, func(e2 TgFileCacheEntry) bool { return e2.Key == e.Key }) {
+			tgFileCache.TgFileCache = append(tgFileCache.TgFileCache, e)
+		}
+	}
+	if err := StatePut(TgFileCacheStore, tgFileCache); err != nil {
+		return fmt.Errorf("StatePut filecache %w", err)
+	}
+	Config.TgFileCache = nil
+	return Config.Put()
+}
+
+// tgFileCacheKey returns the cache key of the video posted as the media with the options,
+// clips and chapters are not cached
+func tgFileCacheKey(videoid string, media string, opts PostOptions) (key string, ok bool) {
+	if opts.ClipStart != 0 || opts.ClipEnd != 0 || opts.Chapters {
+		return "", false
+	}
+	switch media {
+	case ChatMediaAudio:
+		key = F("%s %s a%d", videoid, media, opts.AudioMaxKbps)
+	case ChatMediaVideo:
+		key = F("%s %s h%d", videoid, media, opts.MaxHeight)
+	default:
+		return "", false
+	}
+	if opts.Language != "" {
+		key += SP + opts.Language
+	}
+	return key, true
+}
+
+func TgFileCacheGet(key string) (e TgFileCacheEntry, ok bool) {
+	tgFileCacheMu.Lock()
+	defer tgFileCacheMu.Unlock()
+
+	i := slices.IndexFunc(tgFileCache.TgFileCache, func(e TgFileCacheEntry) bool { return e.Key == key })
+	if i < 0 {
+		TgFileCacheMisses.Add(1)
+		return e, false
+	}
+	TgFileCacheHits.Add(1)
+	return tgFileCache.TgFileCache[i], true
+}
+
+// TgFileCachePut saves the entry replacing the one with the same key and dropping the oldest ones over TgFileCacheMaxSize
+func TgFileCachePut(e TgFileCacheEntry) error {
+	tgFileCacheMu.Lock()
+	defer tgFileCacheMu.Unlock()
+
+	tgFileCache.TgFileCache = slices.DeleteFunc(tgFileCache.TgFileCache, func(e2 TgFileCacheEntry) bool { return e2.Key == e.Key })
+	tgFileCache.TgFileCache = append(tgFileCache.TgFileCache, e)
+	if len(tgFileCache.TgFileCache) > ConfigNow().TgFileCacheMaxSize {
+		tgFileCache.TgFileCache = tgFileCache.TgFileCache[len(tgFileCache.TgFileCache)-ConfigNow().TgFileCacheMaxSize:]
+	}
+
+	return StatePut(TgFileCacheStore, tgFileCache)
+}
+
+func TgFileCacheDelete(key string) error {
+	tgFileCacheMu.Lock()
+	defer tgFileCacheMu.Unlock()
+
+	tgFileCache.TgFileCache = slices.DeleteFunc(tgFileCache.TgFileCache, func(e TgFileCacheEntry) bool { return e.Key == key })
+
+	return StatePut(TgFileCacheStore, tgFileCache)
+}
+
+// tgFileCacheSave saves the file_id of the sent message if the video with the options is cacheable
+func tgFileCacheSave(v YtVideo, ytlist *YtList, media string, opts PostOptions, msg *tg.Message, caption string, duration time.Duration) {
+	key, ok := tgFileCacheKey(v.Id, media, opts)
+	if !ok || msg == nil {
+		return
+	}
+
+	e := TgFileCacheEntry{
+		Key:      key,
+		Caption:  strings.Replace(caption, ytListCaption(v, ytlist), "", 1),
+		Duration: duration,
+		Time:     time.Now(),
+	}
+	switch media {
+	case ChatMediaAudio:
+		e.FileId = msg.Audio.FileId
+	case ChatMediaVideo:
+		e.FileId = msg.Video.FileId
+		e.Width, e.Height = int(msg.Video.Width), int(msg.Video.Height)
+	}
+	if e.FileId == "" {
+		return
+	}
+
+	if err := TgFileCachePut(e); err != nil {
+		LogCache.Error("TgFileCachePut", "key", key, "err", err)
+	}
+}
+
+// postFileCache sends the video as the media by the cached file_id and reports if it was sent,
+// entries telegram does not accept any more are deleted so the video gets downloaded again
+func postFileCache(v YtVideo, ytlist *YtList, m tg.Message, media string, opts PostOptions) (posted bool) {
+	key, ok := tgFileCacheKey(v.Id, media, opts)
+	if !ok {
+		return false
+	}
+	e, ok := TgFileCacheGet(key)
+	if !ok {
+		return false
+	}
+
+	LogCache.Debug("file cache hit", "key", key, "file_id", e.FileId, "chat_id", m.Chat.Id, "video_id", v.Id)
+
+	caption := e.Caption + ytListCaption(v, ytlist)
+	var msg *tg.Message
+	var tgerr error
+	switch media {
+	case ChatMediaAudio:
+		msg, tgerr = TgSendAudio(TgSendAudioRequest{
+			ChatId:   fmt.Sprintf("%d", m.Chat.Id),
+			Audio:    e.FileId,
+			Caption:  caption,
+			Duration: int64(e.Duration.Seconds()),
+		})
+	case ChatMediaVideo:
+		msg, tgerr = TgSendVideo(TgSendVideoRequest{
+			ChatId:   fmt.Sprintf("%d", m.Chat.Id),
+			Video:    e.FileId,
+			Caption:  caption,
+			Width:    e.Width,
+			Height:   e.Height,
+			Duration: int64(e.Duration.Seconds()),
+		})
+	}
+	if tgerr != nil {
+		LogCache.Warn("file cache send failed, deleting", "key", key, "chat_id", m.Chat.Id, "err", tgerr)
+		if err := TgFileCacheDelete(key); err != nil {
+			LogCache.Error("TgFileCacheDelete", "key", key, "err", err)
+		}
+		return false
+	}
+	tgPostedSave(m.Chat.Id, v.Id, media, opts, msg)
+
+	return true
+}
+
+// ytListCaption returns the caption line with the position of the video in the list
+func ytListCaption(v YtVideo, ytlist *YtList) string {
+	if ytlist == nil || ytlist.Title == "" {
+		return ""
+	}
+	return NL + fmt.Sprintf(
+		"%d/%d %s",
+		v.PlaylistIndex+1, ytlist.Size, ytlist.Title,
+	)
+}
+
+func TgFileCacheStats() string {
+	tgFileCacheMu.Lock()
+	entries := len(tgFileCache.TgFileCache)
+	tgFileCacheMu.Unlock()
+
+	return F(
+		"file cache entries <%d> max <%d> hits <%d> misses <%d>",
+		entries, ConfigNow().TgFileCacheMaxSize, TgFileCacheHits.Load(), TgFileCacheMisses.Load(),
+	)
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shoce/tg"
+)
+
+const (
+	ChannelsPruneIntervalDefault = 24 * time.Hour
+
+	// TgChannelsPageSize is how many chats one page of the channels message lists
+	TgChannelsPageSize = 10
+	// TgChannelsCallbackPrefix starts the callback data of the channels message buttons
+	TgChannelsCallbackPrefix = "channels"
+)
+
+var (
+	// TgChatGoneErrors are the getChat errors of the chats the bot can not post to anymore
+	TgChatGoneErrors = []string{
+		"chat not found",
+		"bot was kicked",
+		"bot is not a member",
+		"group chat was deactivated",
+		"group chat was upgraded to a supergroup chat",
+	}
+)
+
+// ChannelsGet returns a copy of the registered chat ids
+func ChannelsGet() []int64 {
+	ConfigMu.Lock()
+	defer ConfigMu.Unlock()
+
+	return slices.Clone(Config.TgAllChannelsChatIds)
+}
+
+// ChannelsRegister adds the chat to TgAllChannelsChatIds
+func ChannelsRegister(chatid int64) error {
+	ConfigMu.Lock()
+	defer ConfigMu.Unlock()
+
+	if slices.Contains(Config.TgAllChannelsChatIds, chatid) {
+		return nil
+	}
+	Config.TgAllChannelsChatIds = append(Config.TgAllChannelsChatIds, chatid)
+	slices.Sort(Config.TgAllChannelsChatIds)
+	LogTg.Info("channel registered", "chat_id", chatid)
+
+	return Config.Put()
+}
+
+// ChannelsUnregister removes the chat from TgAllChannelsChatIds
+func ChannelsUnregister(chatid int64) error {
+	ConfigMu.Lock()
+	defer ConfigMu.Unlock()
+
+	if !slices.Contains(Config.TgAllChannelsChatIds, chatid) {
+		return nil
+	}
+	Config.TgAllChannelsChatIds = slices.DeleteFunc(Config.TgAllChannelsChatIds, func(id int64) bool { return id == chatid })
+	LogTg.Info("channel unregistered", "chat_id", chatid)
+
+	return Config.Put()
+}
+
+// ChannelsMigrate moves the group upgraded to a supergroup to the supergroup id in the channels,
+// the chat settings, the subscriptions, the watches, the list jobs, the unfinished jobs, the access list and usage,
+// the posted history and the pending jobs
+func ChannelsMigrate(from, to int64) error {
+	LogTg.Info("chat migrated", "chat_id", from, "to_chat_id", to)
+
+	if migrated := Jobs.Migrate(from, to); migrated > 0 {
+		LogJobs.Info("jobs migrated", "chat_id", from, "to_chat_id", to, "jobs", migrated)
+	}
+
+	if err := channelsMigrateConfig(from, to); err != nil {
+		return err
+	}
+	if err := TgPostedMigrate(from, to); err != nil {
+		return fmt.Errorf("TgPostedMigrate %w", err)
+	}
+	if err := AccessUsageMigrate(from, to); err != nil {
+		return fmt.Errorf("AccessUsageMigrate %w", err)
+	}
+	return nil
+}
+
+func channelsMigrateConfig(from, to int64) error {
+	ConfigMu.Lock()
+	defer ConfigMu.Unlock()
+
+	if i := slices.Index(Config.TgAllChannelsChatIds, from); i >= 0 {
+		Config.TgAllChannelsChatIds = slices.Delete(Config.TgAllChannelsChatIds, i, i+1)
+		if !slices.Contains(Config.TgAllChannelsChatIds, to) {
+			Config.TgAllChannelsChatIds = append(Config.TgAllChannelsChatIds, to)
+			slices.Sort(Config.TgAllChannelsChatIds)
+		}
+	}
+	for i := range Config.ChatSettings {
+		if Config.ChatSettings[i].ChatId == from {
+			Config.ChatSettings[i].ChatId = to
+		}
+	}
+	for i := range Config.YtSubscriptions {
+		if Config.YtSubscriptions[i].ChatId == from {
+			Config.YtSubscriptions[i].ChatId = to
+		}
+	}
+	for i := range Config.YtListWatches {
+		if Config.YtListWatches[i].ChatId == from {
+			Config.YtListWatches[i].ChatId = to
+		}
+	}
+	for i := range Config.YtListJobs {
+		if Config.YtListJobs[i].ChatId == from {
+			Config.YtListJobs[i].ChatId = to
+		}
+	}
+	for i := range Config.JobsUnfinished {
+		if Config.JobsUnfinished[i].ChatId == from {
+			Config.JobsUnfinished[i].ChatId = to
+		}
+	}
+	for i := range Config.AccessList {
+		if Config.AccessList[i].Id == from {
+			Config.AccessList[i].Id = to
+		}
+	}
+
+	return Config.Put()
+}
+
+// channelCheck gets the chat, unregisters it if it is gone and follows its migration,
+// gone is set if the chat was unregistered or migrated
+func channelCheck(chatid int64) (chat tg.ChatFullInfo, gone bool, err error) {
+	chat, migrateto, tgerr := TgGetChat(chatid)
+	if tgerr == nil {
+		return chat, false, nil
+	}
+	if migrateto != 0 {
+		if err := ChannelsMigrate(chatid, migrateto); err != nil {
+			return chat, false, fmt.Errorf("ChannelsMigrate %w", err)
+		}
+		return chat, true, nil
+	}
+	if slices.ContainsFunc(TgChatGoneErrors, func(e string) bool { return strings.Contains(tgerr.Error(), e) }) {
+		LogTg.Warn("channel gone", "chat_id", chatid, "err", tgerr)
+		if err := ChannelsUnregister(chatid); err != nil {
+			return chat, false, fmt.Errorf("ChannelsUnregister %w", err)
+		}
+		return chat, true, nil
+	}
+	return chat, false, fmt.Errorf("TgGetChat %w", tgerr)
+}
+
+// ChannelsPrune unregisters the chats the bot can not post to anymore
+func ChannelsPrune() (pruned int) {
+	for _, chatid := range ChannelsGet() {
+		if StopCtx.Err() != nil {
+			break
+		}
+		if _, gone, err := channelCheck(chatid); err != nil {
+			LogTg.Error("channelCheck", "chat_id", chatid, "err", err)
+		} else if gone {
+			pruned++
+		}
+	}
+	LogTg.Info("channels pruned", "pruned", pruned)
+	return pruned
+}
+
+// ChannelsPruner prunes the channels every ChannelsPruneInterval
+func ChannelsPruner() {
+	for {
+		select {
+		case <-time.After(ConfigNow().ChannelsPruneInterval):
+		case <-StopCtx.Done():
+			return
+		}
+		ChannelsPrune()
+	}
+}
+
+// processTgMyChatMember reports the change of the bot membership to TgZeChatId and registers or unregisters the chat
+func processTgMyChatMember(cmu tg.ChatMemberUpdated) error {
+	if cmu.Chat.Type != "private" {
+		var err error
+		switch cmu.NewChatMember.Status {
+		case "member", "administrator", "creator":
+			err = ChannelsRegister(cmu.Chat.Id)
+		case "left", "kicked":
+			err = ChannelsUnregister(cmu.Chat.Id)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	reporttext := tg.Bold("MyChatMember") + NL +
+		tg.Bold("from") + " " + tg.Italic(tg.F("%s %s", cmu.From.FirstName, cmu.From.LastName)) + " " + tg.Esc(tg.F("username @%s", cmu.From.Username)) + " " + tg.Esc("id ") + tg.Code(tg.F("%d", cmu.From.Id)) + " " + tg.Link("profile", fmt.Sprintf("tg://user?id=%d", cmu.From.Id)) + NL +
+		tg.Bold("chat") + " " + tg.Esc("id ") + tg.Code(tg.F("%d", cmu.Chat.Id)) + " " + tg.Esc(tg.F("username @%s", cmu.Chat.Username)) + " " + tg.Esc(tg.F("type %s", cmu.Chat.Type)) + " " + tg.Esc(tg.F("title %s", cmu.Chat.Title)) + NL +
+		tg.Bold("old member") + " " + tg.Esc(tg.F("username @%s", cmu.OldChatMember.User.Username)) + tg.Esc(" id ") + tg.Code(tg.F("%d", cmu.OldChatMember.User.Id)) + " " + tg.Esc(tg.F("status %s", cmu.OldChatMember.Status)) + NL +
+		tg.Bold("new member") + " " + tg.Esc(tg.F("username @%s", cmu.NewChatMember.User.Username)) + " " + tg.Esc(" id ") + tg.Code(tg.F("%d", cmu.NewChatMember.User.Id)) + " " + tg.Esc(tg.F("status %s", cmu.NewChatMember.Status))
+	if _, err := tg.SendMessage(tg.SendMessageRequest{
+		ChatId: fmt.Sprintf("%d", ConfigNow().TgZeChatId),
+		Text:   reporttext,
+	}); err != nil {
+		return fmt.Errorf("tg.SendMessage %w", err)
+	}
+
+	return nil
+}
+
+// ChannelsPage returns the text and the keyboard of the page of the channels message,
+// the gone chats on the page are unregistered and left out of it
+func ChannelsPage(page int) (text string, kb TgInlineKeyboardMarkup) {
+	chatids := ChannelsGet()
+	pages := max((len(chatids)+TgChannelsPageSize-1)/TgChannelsPageSize, 1)
+	page = min(max(page, 0), pages-1)
+
+	var lines []string
+	var gone int
+	for i := page * TgChannelsPageSize; i < min((page+1)*TgChannelsPageSize, len(chatids)); i++ {
+		n := i + 1 - gone
+		chat, gone1, err := channelCheck(chatids[i])
+		if err != nil {
+			lines = append(lines, tg.Esc(F("%d. id %d err %v", n, chatids[i], err)))
+			continue
+		}
+		if gone1 {
+			gone++
+			continue
+		}
+		line := tg.Esc(F("%d. %s", n, chat.Title))
+		if chat.Username != "" {
+			line += " " + tg.Link("@"+chat.Username, "https://t.me/"+chat.Username)
+		} else if chat.InviteLink != "" {
+			line += " " + tg.Link("invite", chat.InviteLink)
+		}
+		lines = append(lines, line+" "+tg.Code(F("%d", chatids[i])))
+	}
+	if gone > 0 {
+		chatids = ChannelsGet()
+		pages = max((len(chatids)+TgChannelsPageSize-1)/TgChannelsPageSize, 1)
+		page = min(page, pages-1)
+	}
+
+	text = tg.Bold(tg.Esc(F("channels <%d> page <%d> of <%d>", len(chatids), page+1, pages))) + NL + strings.Join(lines, NL)
+
+	var row []TgInlineKeyboardButton
+	if page > 0 {
+		row = append(row, TgInlineKeyboardButton{Text: "« prev", CallbackData: F("%s page %d", TgChannelsCallbackPrefix, page-1)})
+	}
+	row = append(row, TgInlineKeyboardButton{Text: "prune", CallbackData: F("%s prune %d", TgChannelsCallbackPrefix, page)})
+	if page < pages-1 {
+		row = append(row, TgInlineKeyboardButton{Text: "next »", CallbackData: F("%s page %d", TgChannelsCallbackPrefix, page+1)})
+	}
+	kb.InlineKeyboard = [][]TgInlineKeyboardButton{row}
+	return text, kb
+}
+
+func processTgChannelsCommand(m tg.Message) error {
+	if !AccessIsAdmin(m.From.Id) {
+		return tgReply(m, "only bot admins can list the channels")
+	}
+
+	text, kb := ChannelsPage(0)
+	if _, tgerr := TgSendMessageMarkup(TgSendMessageMarkupRequest{
+		ChatId:           fmt.Sprintf("%d", m.Chat.Id),
+		ReplyToMessageId: m.MessageId,
+		Text:             text,
+		ReplyMarkup:      kb,
+	}); tgerr != nil {
+		return fmt.Errorf("TgSendMessageMarkup %w", tgerr)
+	}
+
+	return nil
+}
+
+// processTgChannelsCallback turns the page of the channels message or prunes the channels and shows the page again
+func processTgChannelsCallback(cq TgCallbackQuery, dd []string) (answer string, err error) {
+	if !AccessIsAdmin(cq.From.Id) {
+		return "only bot admins can list the channels", nil
+	}
+
+	page, err := strconv.Atoi(dd[2])
+	if err != nil {
+		return "unknown button", fmt.Errorf("channels page [%s] %w", dd[2], err)
+	}
+	switch dd[1] {
+	case "page":
+	case "prune":
+		answer = F("pruned <%d> channels", ChannelsPrune())
+	default:
+		return "unknown button", fmt.Errorf("unsupported channels callback data [%s]", cq.Data)
+	}
+
+	text, kb := ChannelsPage(page)
+	if tgerr := TgEditMessageTextMarkup(TgEditMessageTextMarkupRequest{
+		ChatId:      fmt.Sprintf("%d", cq.Message.Chat.Id),
+		MessageId:   cq.Message.MessageId,
+		Text:        text,
+		ReplyMarkup: kb,
+	}); tgerr != nil {
+		return answer, fmt.Errorf("TgEditMessageTextMarkup %w", tgerr)
+	}
+
+	return answer, nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	// ChaptersMin is the minimum number of chapters youtube shows for a video
+	ChaptersMin = 3
+)
+
+var (
+	ChapterTimeRe   = regexp.MustCompile(`(?:^|[\s\[\(])((?:\d{1,2}:)?\d{1,2}:\d{2})(?:[\]\)]|\s|$)`)
+	ChapterNumberRe = regexp.MustCompile(`^\s*\d{1,3}[.)]\s*`)
+)
+
+// parseChapters returns the chapters listed in the description of the video of the duration,
+// every line with a timestamp is a chapter and the first one has to start at zero like youtube requires
+func parseChapters(description string, duration time.Duration) (chapters []MediaPart) {
+	for _, line := range strings.Split(description, NL) {
+		loc := ChapterTimeRe.FindStringSubmatchIndex(line)
+		if loc == nil {
+			continue
+		}
+		start, err := parseClipTime(line[loc[2]:loc[3]])
+		if err != nil || start >= duration {
+			continue
+		}
+		if len(chapters) > 0 && start <= chapters[len(chapters)-1].Start {
+			continue
+		}
+
+		title := ChapterNumberRe.ReplaceAllString(line[:loc[0]], "") + SP + line[loc[1]:]
+		title = strings.Trim(title, " -–—:|")
+
+		chapters = append(chapters, MediaPart{
+			Start: start,
+			Title: strings.TrimSpace(title),
+		})
+	}
+
+	if len(chapters) < ChaptersMin || chapters[0].Start != 0 {
+		return nil
+	}
+
+	for i := range chapters {
+		end := duration
+		if i+1 < len(chapters) {
+			end = chapters[i+1].Start
+		}
+		chapters[i].Duration = end - chapters[i].Start
+		chapters[i].Name = fmt.Sprintf("chapter %d/%d", i+1, len(chapters))
+		if performer, title, ok := strings.Cut(chapters[i].Title, " - "); ok {
+			chapters[i].Performer, chapters[i].Title = strings.TrimSpace(performer), strings.TrimSpace(title)
+		}
+		if chapters[i].Title == "" {
+			chapters[i].Title = chapters[i].Name
+		}
+	}
+
+	return chapters
+}
+
+// longestPart returns the duration of the longest part
+func longestPart(parts []MediaPart) (d time.Duration) {
+	for _, p := range parts {
+		d = max(d, p.Duration)
+	}
+	return d
+}
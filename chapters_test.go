@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseChapters(t *testing.T) {
+	description := `Recorded live
+
+Tracklist:
+0:00 Intro
+1. 02:30 - Artist One - First Song
+2) 5:00 Second Song
+[10:15] Outro
+see also youtu.be/abc at 3:00`
+
+	chapters := parseChapters(description, 12*time.Minute)
+	want := []MediaPart{
+		{Start: 0, Duration: 150 * time.Second, Name: "chapter 1/4", Title: "Intro"},
+		{Start: 150 * time.Second, Duration: 150 * time.Second, Name: "chapter 2/4", Title: "First Song", Performer: "Artist One"},
+		{Start: 300 * time.Second, Duration: 315 * time.Second, Name: "chapter 3/4", Title: "Second Song"},
+		{Start: 615 * time.Second, Duration: 105 * time.Second, Name: "chapter 4/4", Title: "Outro"},
+	}
+	if len(chapters) != len(want) {
+		t.Fatalf("parseChapters %+v", chapters)
+	}
+	for i := range want {
+		if chapters[i] != want[i] {
+			t.Errorf("parseChapters <%d> %+v want %+v", i, chapters[i], want[i])
+		}
+	}
+
+	if chapters := parseChapters("0:10 One\n1:00 Two\n2:00 Three", time.Hour); chapters != nil {
+		t.Errorf("parseChapters not from zero %+v", chapters)
+	}
+	if chapters := parseChapters("0:00 One\n1:00 Two", time.Hour); chapters != nil {
+		t.Errorf("parseChapters too few %+v", chapters)
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PostOptions are the per request options of posting a video
+type PostOptions struct {
+	// ClipStart and ClipEnd are the time range of the video to post, zero ClipEnd means the end of the video
+	ClipStart time.Duration
+	ClipEnd   time.Duration
+
+	// Chapters means the audio is sent as a separate track per chapter from the description
+	Chapters bool
+
+	// MaxHeight and AudioMaxKbps limit the picked formats, zero means no limit
+	MaxHeight    int
+	AudioMaxKbps int64
+
+	// Language is tried before YtDownloadLanguages to pick the audio track
+	Language string
+}
+
+// Clip returns the part of the video of the duration to post and false if the whole video should be posted
+func (o PostOptions) Clip(duration time.Duration) (clip MediaPart, ok bool, err error) {
+	if o.ClipStart == 0 && o.ClipEnd == 0 {
+		return clip, false, nil
+	}
+	if o.ClipStart >= duration {
+		return clip, false, fmt.Errorf("clip start <%v> is beyond duration <%v>", o.ClipStart, duration)
+	}
+	end := duration
+	if o.ClipEnd > 0 && o.ClipEnd < duration {
+		end = o.ClipEnd
+	}
+	clip = MediaPart{
+		Start:    o.ClipStart,
+		Duration: end - o.ClipStart,
+		Name:     fmt.Sprintf("clip %s-%s", fmtClipTime(o.ClipStart), fmtClipTime(end)),
+	}
+	return clip, true, nil
+}
+
+// parseClipTime parses `754`, `754s`, `12m34s`, `1h2m3s`, `12:34` or `1:02:03`
+func parseClipTime(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, fmt.Errorf("empty time")
+	}
+
+	if strings.Contains(s, ":") {
+		ss := strings.Split(s, ":")
+		if len(ss) > 3 {
+			return 0, fmt.Errorf("invalid time [%s]", s)
+		}
+		var d time.Duration
+		for i, p := range ss {
+			n, err := strconv.ParseUint(p, 10, 32)
+			if err != nil || (i > 0 && (len(p) != 2 || n >= 60)) {
+				return 0, fmt.Errorf("invalid time [%s]", s)
+			}
+			d = d*60 + time.Duration(n)
+		}
+		return d * time.Second, nil
+	}
+
+	if n, err := strconv.ParseUint(s, 10, 32); err == nil {
+		return time.Duration(n) * time.Second, nil
+	}
+
+	if d, err := time.ParseDuration(s); err == nil && d >= 0 && d == d.Truncate(time.Second) && !strings.ContainsAny(s, ".-+") {
+		return d, nil
+	}
+
+	return 0, fmt.Errorf("invalid time [%s]", s)
+}
+
+// parseClipRange parses `START`, `START-` or `START-END` times
+func parseClipRange(s string) (start, end time.Duration, err error) {
+	startstr, endstr, isrange := strings.Cut(s, "-")
+	start, err = parseClipTime(startstr)
+	if err != nil {
+		return 0, 0, err
+	}
+	if !isrange || endstr == "" {
+		return start, 0, nil
+	}
+	end, err = parseClipTime(endstr)
+	if err != nil {
+		return 0, 0, err
+	}
+	if end <= start {
+		return 0, 0, fmt.Errorf("invalid range [%s]", s)
+	}
+	return start, end, nil
+}
+
+// fmtClipTime formats the time as `m:ss` or `h:mm:ss`
+func fmtClipTime(d time.Duration) string {
+	secs := int64(d.Seconds())
+	if secs >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", secs/3600, secs/60%60, secs%60)
+	}
+	return fmt.Sprintf("%d:%02d", secs/60, secs%60)
+}
+
+// FfmpegClip cuts the clip from the file copying streams, removes the file and returns the clip file name
+func FfmpegClip(ctx context.Context, filename string, clip MediaPart) (filename2 string, err error) {
+	if ConfigFrom(ctx).FfmpegPath == "" {
+		return "", fmt.Errorf("clips need FfmpegPath")
+	}
+
+	ext := filename[strings.LastIndex(filename, ".")+1:]
+	filename2 = fmt.Sprintf("%s.clip.%s", strings.TrimSuffix(filename, "."+ext), ext)
+	if err := FfmpegCut(ctx, filename, filename2, clip.Start, clip.Duration, 0, 0); err != nil {
+		tempRemove(LogWith(ctx, LogFfmpeg), filename2)
+		return "", fmt.Errorf("FfmpegCut %s %w", clip.Name, err)
+	}
+	if err := os.Remove(filename); err != nil {
+		LogWith(ctx, LogFfmpeg).Error("os.Remove", "file", filename, "err", err)
+	}
+
+	return filename2, nil
+}
+
+// downloadClip writes the reader to the file and cuts the clip from it returning the clip file name
+func downloadClip(ctx context.Context, r io.Reader, filename string, clip MediaPart) (filename2 string, err error) {
+	if err := saveFile(r, filename); err != nil {
+		return "", err
+	}
+	filename2, err = FfmpegClip(ctx, filename, clip)
+	if err != nil {
+		tempRemove(LogWith(ctx, LogFfmpeg), filename)
+	}
+	return filename2, err
+}
+
+// saveFile writes the reader to the file removing the file on failure
+func saveFile(r io.Reader, filename string) error {
+	f, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("os.OpenFile %w", err)
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(filename)
+		return fmt.Errorf("io.Copy %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("os.File.Close %w", err)
+	}
+	return nil
+}
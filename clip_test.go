@@ -0,0 +1,71 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseClipTime(t *testing.T) {
+	for s, want := range map[string]time.Duration{
+		"754":     754 * time.Second,
+		"754s":    754 * time.Second,
+		"12m34s":  754 * time.Second,
+		"1h2m3s":  3723 * time.Second,
+		"12:34":   754 * time.Second,
+		"1:02:03": 3723 * time.Second,
+		"0:05":    5 * time.Second,
+	} {
+		if d, err := parseClipTime(s); err != nil || d != want {
+			t.Errorf("parseClipTime [%s] <%v> %v want <%v>", s, d, err, want)
+		}
+	}
+	for _, s := range []string{"", "abc", "1:2", "1:60", "1:00:00:00", "-5", "1.5s", "all"} {
+		if d, err := parseClipTime(s); err == nil {
+			t.Errorf("parseClipTime [%s] <%v> expected error", s, d)
+		}
+	}
+}
+
+func TestPostOptionsClip(t *testing.T) {
+	if _, ok, err := (PostOptions{}).Clip(time.Minute); ok || err != nil {
+		t.Errorf("Clip empty ok <%v> %v", ok, err)
+	}
+	if _, _, err := (PostOptions{ClipStart: 2 * time.Minute}).Clip(time.Minute); err == nil {
+		t.Errorf("Clip beyond duration expected error")
+	}
+	clip, ok, err := PostOptions{ClipStart: 90 * time.Second}.Clip(time.Hour + time.Second)
+	if !ok || err != nil || clip.Start != 90*time.Second || clip.Duration != time.Hour-89*time.Second || clip.Name != "clip 1:30-1:00:01" {
+		t.Errorf("Clip %+v ok <%v> %v", clip, ok, err)
+	}
+	clip, ok, err = PostOptions{ClipStart: 10 * time.Second, ClipEnd: 2 * time.Minute}.Clip(time.Minute)
+	if !ok || err != nil || clip.Duration != 50*time.Second || clip.Name != "clip 0:10-1:00" {
+		t.Errorf("Clip %+v ok <%v> %v", clip, ok, err)
+	}
+}
+
+func TestDownloadClipRemove(t *testing.T) {
+	dir := t.TempDir()
+	// ffmpeg stand-in writing a partial output file and failing
+	ffmpegpath := filepath.Join(dir, "ffmpeg")
+	if err := os.WriteFile(ffmpegpath, []byte("#!/bin/sh"+NL+`for a ; do out="$a" ; done ; echo partial > "$out" ; exit 1`+NL), 0700); err != nil {
+		t.Fatal(err)
+	}
+	c := *ConfigNow()
+	c.FfmpegPath = ffmpegpath
+	ctx := ConfigContext(Ctx, &c)
+
+	clip := MediaPart{Start: 10 * time.Second, Duration: 30 * time.Second, Name: "clip 0:10-0:40"}
+	if _, err := downloadClip(ctx, strings.NewReader("audio"), filepath.Join(dir, "a.m4a"), clip); err == nil {
+		t.Fatalf("downloadClip with failing ffmpeg no error")
+	}
+	ee, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ee) != 1 {
+		t.Errorf("dir entries %v expected only ffmpeg", ee)
+	}
+}
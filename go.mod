@@ -5,17 +5,27 @@ go 1.26
 require (
 	github.com/goccy/go-yaml v1.19.2
 	github.com/kkdai/youtube/v2 v2.10.7-0.20260602173030-08c2f7c74295
+	github.com/prometheus/client_golang v1.23.2
 	github.com/shoce/tg v0.260517.824
 	golang.org/x/image v0.41.0
 )
 
 require (
+	github.com/beorn7/perks v1.0.1 // indirect
 	github.com/bitly/go-simplejson v0.5.1 // indirect
+	github.com/cespare/xxhash/v2 v2.3.0 // indirect
 	github.com/dlclark/regexp2 v1.12.0 // indirect
 	github.com/dop251/goja v0.0.0-20260311135729-065cd970411c // indirect
 	github.com/go-sourcemap/sourcemap v2.1.4+incompatible // indirect
 	github.com/google/pprof v0.0.0-20260507013755-92041b743c96 // indirect
+	github.com/munnerz/goautoneg v0.0.0-20191010083416-a7dc8b61c822 // indirect
+	github.com/prometheus/client_model v0.6.2 // indirect
+	github.com/prometheus/common v0.66.1 // indirect
+	github.com/prometheus/procfs v0.16.1 // indirect
+	go.yaml.in/yaml/v2 v2.4.2 // indirect
+	golang.org/x/sys v0.42.0 // indirect
 	golang.org/x/text v0.37.0 // indirect
+	google.golang.org/protobuf v1.36.8 // indirect
 )
 
 // replace github.com/kkdai/youtube/v2 => github.com/shoce/youtube/v2 v2.0.0-20250504070453-4efe7e01b32d
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	HealthLoopMaxAgeDefault = 5 * time.Minute
+	HealthCheckTimeout      = 5 * time.Second
+)
+
+var (
+	// HealthLoopTime is the unix nano time of the last main loop tick
+	HealthLoopTime atomic.Int64
+
+	HealthHttpClient = &http.Client{Timeout: HealthCheckTimeout}
+)
+
+func init() {
+	HealthLoopTick()
+}
+
+// HealthLoopTick records that the main loop is alive
+func HealthLoopTick() {
+	HealthLoopTime.Store(time.Now().UnixNano())
+}
+
+// HealthLive returns an error if the main loop has not ticked for HealthLoopMaxAge
+func HealthLive() error {
+	age := time.Since(time.Unix(0, HealthLoopTime.Load()))
+	if age > ConfigNow().HealthLoopMaxAge {
+		return fmt.Errorf("main loop last tick <%v> ago", age.Truncate(time.Second))
+	}
+	return nil
+}
+
+// HealthReady returns the errors of the config store, ffmpeg and dss checks
+func HealthReady() (errs []error) {
+	var config TgZeConfig
+	if err := config.Get(); err != nil {
+		errs = append(errs, fmt.Errorf("config store %w", err))
+	}
+
+	if ConfigNow().FfmpegPath != "" {
+		if fi, err := os.Stat(ConfigNow().FfmpegPath); err != nil {
+			errs = append(errs, fmt.Errorf("FfmpegPath %w", err))
+		} else if fi.IsDir() || fi.Mode()&0111 == 0 {
+			errs = append(errs, fmt.Errorf("FfmpegPath [%s] not executable", ConfigNow().FfmpegPath))
+		}
+	}
+
+	if ConfigNow().DssUrl != "" {
+		if resp, err := HealthHttpClient.Get(ConfigNow().DssUrl); err != nil {
+			errs = append(errs, fmt.Errorf("DssUrl %w", err))
+		} else {
+			resp.Body.Close()
+			if resp.StatusCode >= http.StatusInternalServerError {
+				errs = append(errs, fmt.Errorf("DssUrl response status %s", resp.Status))
+			}
+		}
+	}
+
+	return errs
+}
+
+func HealthLiveHandler(w http.ResponseWriter, r *http.Request) {
+	if err := HealthLive(); err != nil {
+		Log.Warn("health live", "err", err)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+func HealthReadyHandler(w http.ResponseWriter, r *http.Request) {
+	if errs := HealthReady(); len(errs) > 0 {
+		var ee []string
+		for _, err := range errs {
+			ee = append(ee, err.Error())
+		}
+		Log.Warn("health ready", "errs", ee)
+		http.Error(w, strings.Join(ee, NL), http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHealthLive(t *testing.T) {
+	HealthLoopTick()
+	w := httptest.NewRecorder()
+	HealthLiveHandler(w, httptest.NewRequest("GET", "/healthz", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("healthz status <%d> %s", w.Code, w.Body)
+	}
+
+	HealthLoopTime.Store(time.Now().Add(-2 * Config.HealthLoopMaxAge).UnixNano())
+	defer HealthLoopTick()
+	w = httptest.NewRecorder()
+	HealthLiveHandler(w, httptest.NewRequest("GET", "/healthz", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("healthz status <%d> with stale loop", w.Code)
+	}
+}
+
+func TestHealthReady(t *testing.T) {
+	if errs := HealthReady(); len(errs) > 0 {
+		t.Errorf("HealthReady %v", errs)
+	}
+
+	ffmpegpath, tempdir := ConfigNow().FfmpegPath, t.TempDir()
+	defer ConfigSet(func(c *TgZeConfig) { c.FfmpegPath = ffmpegpath })
+	ConfigSet(func(c *TgZeConfig) { c.FfmpegPath = tempdir })
+	w := httptest.NewRecorder()
+	HealthReadyHandler(w, httptest.NewRequest("GET", "/readyz", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("readyz status <%d> with FfmpegPath a directory", w.Code)
+	}
+}
@@ -0,0 +1,571 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/shoce/tg"
+)
+
+type Job struct {
+	Id       int64
+	UpdateId int64
+
+	Message tg.Message
+	Video   YtVideo
+	List    *YtList
+
+	// Media is ChatMediaAudio, ChatMediaVideo or ChatMediaBoth
+	Media         string
+	DeleteMessage bool
+
+	Options PostOptions
+
+	// AudioCompress compresses the audio of the message the job message replies to instead of posting a video
+	AudioCompress bool
+
+	// started, cancel and cancelled are set by the scheduler while the job runs
+	started   time.Time
+	cancel    context.CancelFunc
+	cancelled bool
+}
+
+// YtListJob is the persisted state of a playlist being posted to a chat
+type YtListJob struct {
+	UpdateId  int64  `yaml:"UpdateId"`
+	ChatId    int64  `yaml:"ChatId"`
+	MessageId int64  `yaml:"MessageId"`
+	FromId    int64  `yaml:"FromId"` // the user of the message the resumed jobs are accounted to
+	ListId    string `yaml:"ListId"`
+
+	// NextIndex is the PlaylistIndex of the next video to post
+	NextIndex int64 `yaml:"NextIndex"`
+	// EndIndex is the PlaylistIndex after the last video to post, zero means the end of the list
+	EndIndex int64 `yaml:"EndIndex"`
+
+	Media    string `yaml:"Media"`
+	Chapters bool   `yaml:"Chapters"`
+	Language string `yaml:"Language"`
+	// DownloadVideo is read from list jobs saved before Media
+	DownloadVideo bool `yaml:"DownloadVideo,omitempty"`
+
+	Failures []YtListJobFailure `yaml:"Failures"`
+}
+
+type YtListJobFailure struct {
+	VideoId       string `yaml:"VideoId"`
+	PlaylistIndex int64  `yaml:"PlaylistIndex"`
+	Error         string `yaml:"Error"`
+}
+
+type JobScheduler struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	// queues holds pending jobs per chat id in the order they were put
+	queues map[int64][]*Job
+	// running holds the job being processed per chat id
+	running map[int64]*Job
+	// ready holds chat ids with pending jobs and no running job
+	ready []int64
+
+	lastid int64
+
+	// stopping makes the workers exit instead of taking the next job
+	stopping bool
+	workers  sync.WaitGroup
+	// interrupted holds the jobs failed because they were cancelled by Stop
+	interrupted []*Job
+
+	// duration is the moving average of the job durations
+	duration time.Duration
+}
+
+var (
+	Jobs = NewJobScheduler()
+)
+
+func NewJobScheduler() *JobScheduler {
+	js := &JobScheduler{
+		queues:  make(map[int64][]*Job),
+		running: make(map[int64]*Job),
+	}
+	js.cond = sync.NewCond(&js.mu)
+	return js
+}
+
+func (js *JobScheduler) Start(workers int) {
+	LogJobs.Info("JobScheduler starting", "workers", workers)
+	for i := 0; i < workers; i++ {
+		js.workers.Add(1)
+		go js.worker(i)
+	}
+}
+
+// Stop makes the workers exit after the running jobs, cancels the jobs still running after the grace period
+// and returns the jobs not finished ordered by id
+func (js *JobScheduler) Stop(grace time.Duration, cancel func()) (unfinished []*Job) {
+	js.mu.Lock()
+	js.stopping = true
+	js.cond.Broadcast()
+	js.mu.Unlock()
+
+	stopped := make(chan struct{})
+	go func() {
+		js.workers.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(grace):
+		LogJobs.Warn("JobScheduler stop grace period is over, cancelling running jobs", "grace", grace)
+		cancel()
+		select {
+		case <-stopped:
+		case <-time.After(JobsStopCancelWait):
+			LogJobs.Warn("JobScheduler workers did not return after cancel", "wait", JobsStopCancelWait)
+		}
+	}
+
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	unfinished = append(unfinished, js.interrupted...)
+	for _, j := range js.running {
+		unfinished = append(unfinished, j)
+	}
+	for _, q := range js.queues {
+		unfinished = append(unfinished, q...)
+	}
+	sort.Slice(unfinished, func(i, k int) bool { return unfinished[i].Id < unfinished[k].Id })
+
+	return unfinished
+}
+
+func (js *JobScheduler) Put(j *Job) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	js.lastid++
+	j.Id = js.lastid
+
+	chatid := j.Message.Chat.Id
+	js.queues[chatid] = append(js.queues[chatid], j)
+	if js.running[chatid] == nil && len(js.queues[chatid]) == 1 {
+		js.ready = append(js.ready, chatid)
+		js.cond.Signal()
+	}
+
+	LogJobs.Debug("JobScheduler put", "job_id", j.Id, "update_id", j.UpdateId, "chat_id", chatid, "video_id", j.Video.Id, "queue_size", len(js.queues[chatid]))
+}
+
+// Len returns the number of pending jobs and the number of running jobs
+func (js *JobScheduler) Len() (queued, running int) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	for _, q := range js.queues {
+		queued += len(q)
+	}
+	return queued, len(js.running)
+}
+
+// Drop removes pending jobs of the chat created by the update and returns the number of removed jobs
+func (js *JobScheduler) Drop(chatid int64, updateid int64) (dropped int) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	var jj []*Job
+	for _, j := range js.queues[chatid] {
+		if j.UpdateId == updateid {
+			dropped++
+			continue
+		}
+		jj = append(jj, j)
+	}
+	js.queues[chatid] = jj
+
+	return dropped
+}
+
+// Migrate moves the pending jobs of the chat to the chat id it migrated to and returns the number of moved jobs,
+// the running job finishes with the old chat id
+func (js *JobScheduler) Migrate(from, to int64) (migrated int) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	jj := js.queues[from]
+	if len(jj) == 0 {
+		return 0
+	}
+	delete(js.queues, from)
+	for _, j := range jj {
+		j.Message.Chat.Id = to
+	}
+	idle := js.running[to] == nil && len(js.queues[to]) == 0
+	js.queues[to] = append(js.queues[to], jj...)
+	if idle {
+		js.ready = append(js.ready, to)
+		js.cond.Signal()
+	}
+
+	return len(jj)
+}
+
+// Queue returns copies of the running job and of the pending jobs of the chat and the average job duration
+func (js *JobScheduler) Queue(chatid int64) (running *Job, queued []Job, duration time.Duration) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	if j := js.running[chatid]; j != nil {
+		j2 := *j
+		running = &j2
+	}
+	for _, j := range js.queues[chatid] {
+		queued = append(queued, *j)
+	}
+	return running, queued, js.duration
+}
+
+// Cancel cancels the running job and drops the pending jobs of the chat matching the filter, returns copies of the cancelled jobs
+func (js *JobScheduler) Cancel(chatid int64, match func(j *Job) bool) (cancelled []Job) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	if j := js.running[chatid]; j != nil && !j.cancelled && match(j) {
+		j.cancelled = true
+		j.cancel()
+		cancelled = append(cancelled, *j)
+	}
+
+	var jj []*Job
+	for _, j := range js.queues[chatid] {
+		if match(j) {
+			cancelled = append(cancelled, *j)
+			continue
+		}
+		jj = append(jj, j)
+	}
+	js.queues[chatid] = jj
+
+	return cancelled
+}
+
+func (js *JobScheduler) next() (j *Job, ctx context.Context) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	for {
+		for len(js.ready) == 0 && !js.stopping {
+			js.cond.Wait()
+		}
+		if js.stopping {
+			return nil, nil
+		}
+
+		chatid := js.ready[0]
+		js.ready = js.ready[1:]
+		if len(js.queues[chatid]) == 0 {
+			delete(js.queues, chatid)
+			continue
+		}
+
+		j = js.queues[chatid][0]
+		js.queues[chatid] = js.queues[chatid][1:]
+		js.running[chatid] = j
+		j.started = time.Now()
+		ctx, j.cancel = context.WithCancel(Ctx)
+		return j, ctx
+	}
+}
+
+func (js *JobScheduler) done(j *Job) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	j.cancel()
+	if d := time.Since(j.started); js.duration == 0 {
+		js.duration = d
+	} else {
+		js.duration = (js.duration*3 + d) / 4
+	}
+
+	chatid := j.Message.Chat.Id
+	delete(js.running, chatid)
+	if len(js.queues[chatid]) > 0 {
+		js.ready = append(js.ready, chatid)
+		js.cond.Signal()
+	} else {
+		delete(js.queues, chatid)
+	}
+}
+
+func (js *JobScheduler) worker(n int) {
+	defer js.workers.Done()
+
+	for {
+		j, ctx := js.next()
+		if j == nil {
+			LogJobs.Debug("JobScheduler worker stopped", "worker", n)
+			return
+		}
+
+		LogJobs.Debug("JobScheduler worker", "worker", n, "job_id", j.Id, "update_id", j.UpdateId, "chat_id", j.Message.Chat.Id, "video_id", j.Video.Id)
+
+		err := processJob(ctx, j)
+		if err != nil && Ctx.Err() != nil {
+			// cancelled by Stop, the job is not finished
+			LogJobs.Warn("processJob cancelled", "job_id", j.Id, "chat_id", j.Message.Chat.Id, "video_id", j.Video.Id, "err", err)
+			js.mu.Lock()
+			js.interrupted = append(js.interrupted, j)
+			js.mu.Unlock()
+			js.done(j)
+			continue
+		}
+		js.mu.Lock()
+		cancelled := j.cancelled
+		js.mu.Unlock()
+		if cancelled {
+			LogJobs.Info("job cancelled", "job_id", j.Id, "chat_id", j.Message.Chat.Id, "video_id", j.Video.Id, "err", err)
+			js.done(j)
+			continue
+		}
+		if err != nil {
+			LogJobs.Error("processJob", "job_id", j.Id, "chat_id", j.Message.Chat.Id, "video_id", j.Video.Id, "err", err)
+		}
+		// jobs of subscriptions have no message to react to
+		if err != nil && j.Message.MessageId != 0 {
+			if tgerr := tg.SetMessageReaction(tg.SetMessageReactionRequest{
+				ChatId:    fmt.Sprintf("%d", j.Message.Chat.Id),
+				MessageId: j.Message.MessageId,
+				Reaction:  []tg.ReactionTypeEmoji{tg.ReactionTypeEmoji{Emoji: "🤷‍♂"}},
+			}); tgerr != nil {
+				LogJobs.Error("tg.SetMessageReaction [🤷‍♂]", "job_id", j.Id, "chat_id", j.Message.Chat.Id, "err", tgerr)
+			}
+		}
+
+		if j.List != nil {
+			abort, puterr := YtListJobDone(j, err)
+			if puterr != nil {
+				LogJobs.Error("YtListJobDone", "job_id", j.Id, "err", puterr)
+			}
+			if abort {
+				if dropped := js.Drop(j.Message.Chat.Id, j.UpdateId); dropped > 0 {
+					LogJobs.Info("JobScheduler dropped jobs", "dropped", dropped, "update_id", j.UpdateId, "chat_id", j.Message.Chat.Id)
+				}
+			} else if err == nil && len(j.List.Videos) > 3 {
+				LogJobs.Debug("sleeping", "duration", ConfigNow().YtListSleep)
+				select {
+				case <-time.After(ConfigNow().YtListSleep):
+				case <-ctx.Done():
+				case <-StopCtx.Done():
+				}
+			}
+		}
+
+		js.done(j)
+	}
+}
+
+func processJob(ctx context.Context, j *Job) (err error) {
+	// the job runs with the settings of its start even if the config is reloaded meanwhile
+	ctx = ConfigContext(ctx, ConfigNow())
+	ctx = LogContext(ctx, "job_id", j.Id, "chat_id", j.Message.Chat.Id)
+
+	// the job is charged when it starts so the canceled jobs and the ones dropped from the queue are not charged
+	if err := AccessUse(j.Message, 1, 0); err != nil {
+		LogJobs.Error("AccessUse", "job_id", j.Id, "chat_id", j.Message.Chat.Id, "err", err)
+	}
+
+	if j.AudioCompress {
+		return postAudioCompress(ctx, j.Message)
+	}
+
+	progress := progressNew(ctx, j.Message, j.Video)
+	defer func() { progress.Finish(err) }()
+	ctx = ProgressContext(ctx, progress)
+
+	var downloaded atomic.Int64
+	ctx = AccessBytesContext(ctx, &downloaded)
+	defer func() {
+		if err := AccessUse(j.Message, 0, downloaded.Load()); err != nil {
+			LogJobs.Error("AccessUse", "job_id", j.Id, "chat_id", j.Message.Chat.Id, "err", err)
+		}
+	}()
+
+	if j.Media == ChatMediaAudio || j.Media == ChatMediaBoth {
+		if postFileCache(j.Video, j.List, j.Message, ChatMediaAudio, j.Options) {
+			LogJobs.Debug("audio sent", "job_id", j.Id, "video_id", j.Video.Id, "backend", "cache")
+		} else if ConfigFrom(ctx).DssUrl != "" {
+			err = postAudioDss(ctx, j.Video, j.List, j.Message, j.Options)
+		} else {
+			err = postAudio(ctx, j.Video, j.List, j.Message, j.Options)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if j.Media == ChatMediaVideo || j.Media == ChatMediaBoth {
+		if postFileCache(j.Video, j.List, j.Message, ChatMediaVideo, j.Options) {
+			LogJobs.Debug("video sent", "job_id", j.Id, "video_id", j.Video.Id, "backend", "cache")
+		} else if ConfigFrom(ctx).DssUrl != "" {
+			err = postVideoDss(ctx, j.Video, j.List, j.Message, j.Options)
+		} else {
+			err = postVideo(ctx, j.Video, j.List, j.Message, j.Options)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if j.DeleteMessage {
+		if err := tg.DeleteMessage(tg.DeleteMessageRequest{
+			ChatId:    fmt.Sprintf("%d", j.Message.Chat.Id),
+			MessageId: j.Message.MessageId,
+		}); err != nil {
+			LogJobs.Error("tg.DeleteMessage", "job_id", j.Id, "chat_id", j.Message.Chat.Id, "err", err)
+		}
+	}
+
+	return nil
+}
+
+// YtListJobPut records the playlist posting state so it can be resumed after a restart
+func YtListJobPut(updateid int64, m tg.Message, ytlist *YtList, to int64, media string, opts PostOptions) error {
+	ConfigMu.Lock()
+	defer ConfigMu.Unlock()
+
+	lj := YtListJob{
+		UpdateId:  updateid,
+		ChatId:    m.Chat.Id,
+		MessageId: m.MessageId,
+		FromId:    m.From.Id,
+		ListId:    ytlist.Id,
+		Media:     media,
+		Chapters:  opts.Chapters,
+		Language:  opts.Language,
+	}
+	if len(ytlist.Videos) > 0 {
+		lj.NextIndex = ytlist.Videos[0].PlaylistIndex
+	}
+	if to >= 0 {
+		lj.EndIndex = to + 1
+	}
+	Config.YtListJobs = append(Config.YtListJobs, lj)
+
+	return Config.Put()
+}
+
+// YtListJobDone advances the playlist posting state after the job and reports if the rest of the playlist should be dropped
+func YtListJobDone(j *Job, joberr error) (abort bool, err error) {
+	ConfigMu.Lock()
+	defer ConfigMu.Unlock()
+
+	i := slices.IndexFunc(Config.YtListJobs, func(lj YtListJob) bool {
+		return lj.UpdateId == j.UpdateId && lj.ChatId == j.Message.Chat.Id
+	})
+	if i < 0 {
+		return false, fmt.Errorf("no list job for update id <%d> chat id <%d>", j.UpdateId, j.Message.Chat.Id)
+	}
+	lj := &Config.YtListJobs[i]
+
+	lj.NextIndex = j.Video.PlaylistIndex + 1
+	if joberr != nil {
+		lj.Failures = append(lj.Failures, YtListJobFailure{
+			VideoId:       j.Video.Id,
+			PlaylistIndex: j.Video.PlaylistIndex,
+			Error:         joberr.Error(),
+		})
+		if len(lj.Failures) >= ConfigNow().YtListJobMaxFailures {
+			LogJobs.Warn("list job aborting", "update_id", lj.UpdateId, "chat_id", lj.ChatId, "list_id", lj.ListId, "failures", len(lj.Failures))
+			abort = true
+		}
+	}
+
+	last := j.List.Videos[len(j.List.Videos)-1]
+	if abort || j.Video.PlaylistIndex >= last.PlaylistIndex {
+		if len(lj.Failures) > 0 {
+			LogJobs.Info("list job finished with failures", "update_id", lj.UpdateId, "chat_id", lj.ChatId, "list_id", lj.ListId, "failures", F("%+v", lj.Failures))
+		}
+		Config.YtListJobs = slices.Delete(Config.YtListJobs, i, i+1)
+	}
+
+	return abort, Config.Put()
+}
+
+// YtListJobDelete removes the playlist posting state so the playlist is not resumed
+func YtListJobDelete(updateid, chatid int64) error {
+	ConfigMu.Lock()
+	defer ConfigMu.Unlock()
+
+	Config.YtListJobs = slices.DeleteFunc(Config.YtListJobs, func(lj YtListJob) bool {
+		return lj.UpdateId == updateid && lj.ChatId == chatid
+	})
+
+	return Config.Put()
+}
+
+// YtListJobsResume puts jobs for the rest of every playlist that was being posted
+func YtListJobsResume() error {
+	ConfigMu.Lock()
+	ljj := slices.Clone(Config.YtListJobs)
+	ConfigMu.Unlock()
+
+	for _, lj := range ljj {
+		LogJobs.Info("resuming list job", "update_id", lj.UpdateId, "chat_id", lj.ChatId, "list_id", lj.ListId, "next_index", lj.NextIndex)
+
+		ytlist, err := getList(StopCtx, lj.ListId, lj.NextIndex, lj.EndIndex-1)
+		if err != nil {
+			LogJobs.Error("getList", "update_id", lj.UpdateId, "chat_id", lj.ChatId, "list_id", lj.ListId, "err", err)
+			continue
+		}
+
+		m := tg.Message{
+			MessageId: lj.MessageId,
+			From:      tg.User{Id: lj.FromId},
+			Chat:      tg.Chat{Id: lj.ChatId},
+		}
+		media := lj.Media
+		if media == "" && lj.DownloadVideo {
+			media = ChatMediaVideo
+		} else if media == "" {
+			media = ChatMediaAudio
+		}
+		opts := ChatSettingsGet(lj.ChatId).PostOptions()
+		opts.Chapters = lj.Chapters
+		if lj.Language != "" {
+			opts.Language = lj.Language
+		}
+		ytlist.Videos = slices.DeleteFunc(ytlist.Videos, func(v YtVideo) bool { return v.Unavailable })
+		var resumed int
+		for _, v := range ytlist.Videos {
+			if v.PlaylistIndex < lj.NextIndex {
+				continue
+			}
+			Jobs.Put(&Job{
+				UpdateId: lj.UpdateId,
+				Message:  m,
+				Video:    v,
+				List:     ytlist,
+				Media:    media,
+				Options:  opts,
+			})
+			resumed++
+		}
+
+		if resumed == 0 {
+			LogJobs.Warn("list job has no videos from next index, removing", "update_id", lj.UpdateId, "chat_id", lj.ChatId, "list_id", lj.ListId, "next_index", lj.NextIndex)
+			if err := YtListJobDelete(lj.UpdateId, lj.ChatId); err != nil {
+				return fmt.Errorf("YtListJobDelete %w", err)
+			}
+		}
+	}
+
+	return nil
+}
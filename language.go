@@ -0,0 +1,101 @@
+package main
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+
+	ytdl "github.com/kkdai/youtube/v2"
+)
+
+var (
+	// YtLanguageCodeRe matches language codes like `de` or `pt-BR` of the audio track ids
+	YtLanguageCodeRe = regexp.MustCompile(`^[a-z]{2}(?:-[A-Za-z]{2,4})?$`)
+
+	// YtLanguageCodes are the ISO 639-1 codes of the languages youtube has audio tracks in
+	YtLanguageCodes = []string{
+		"af", "am", "ar", "az", "be", "bg", "bn", "bs", "ca", "cs", "cy", "da", "de", "el", "en", "es", "et", "eu",
+		"fa", "fi", "fr", "ga", "gl", "gu", "he", "hi", "hr", "hu", "hy", "id", "is", "it", "ja", "ka", "kk",
+		"km", "kn", "ko", "ky", "lo", "lt", "lv", "mk", "ml", "mn", "mr", "ms", "my", "ne", "nl", "no", "pa", "pl",
+		"pt", "ro", "ru", "si", "sk", "sl", "sq", "sr", "sv", "sw", "ta", "te", "th", "tr", "uk", "ur", "uz", "vi",
+		"zh", "zu",
+	}
+)
+
+// isYtLanguage reports if the message word is one of YtLanguageCodes with an optional region or one of YtDownloadLanguages
+func isYtLanguage(s string) bool {
+	if YtLanguageCodeRe.MatchString(s) {
+		code, _, _ := strings.Cut(s, "-")
+		return slices.Contains(YtLanguageCodes, code)
+	}
+	return slices.Contains(ConfigNow().YtDownloadLanguages, strings.ToLower(s))
+}
+
+// ytAudioTrackMatches reports if the audio track is in the language given as a code like `de` or as a name like `german`,
+// codes are compared to the track id only as they are prefixes of other language names like `es` of `estonian`
+func ytAudioTrackMatches(displayname, id, lang string) bool {
+	lang = strings.ToLower(lang)
+	if lang == "" {
+		return false
+	}
+	if !YtLanguageCodeRe.MatchString(lang) {
+		return strings.HasPrefix(strings.ToLower(displayname), lang)
+	}
+	code, _, _ := strings.Cut(strings.ToLower(id), ".")
+	return code == lang || strings.Split(code, "-")[0] == lang
+}
+
+// ytAudioTrackPick returns the id of the audio track in the first of the languages the video has,
+// then the original track, then the default one, and empty for videos with a single audio track
+func ytAudioTrackPick(formats ytdl.FormatList, languages []string) (trackid string) {
+	type track struct {
+		DisplayName, ID string
+		Default         bool
+	}
+	var tracks []track
+	for _, f := range formats {
+		if f.AudioTrack == nil || slices.ContainsFunc(tracks, func(t track) bool { return t.ID == f.AudioTrack.ID }) {
+			continue
+		}
+		tracks = append(tracks, track{f.AudioTrack.DisplayName, f.AudioTrack.ID, f.AudioTrack.AudioIsDefault})
+	}
+	if len(tracks) == 0 {
+		return ""
+	}
+	LogYt.Debug("audio tracks", "tracks", F("%+v", tracks), "languages", languages)
+
+	for _, lang := range languages {
+		for _, t := range tracks {
+			if ytAudioTrackMatches(t.DisplayName, t.ID, lang) {
+				return t.ID
+			}
+		}
+	}
+	for _, t := range tracks {
+		if strings.HasSuffix(t.DisplayName, " original") {
+			return t.ID
+		}
+	}
+	for _, t := range tracks {
+		if t.Default {
+			return t.ID
+		}
+	}
+	return tracks[0].ID
+}
+
+// ytLanguages returns the languages to try for the audio track, the language of the message or the chat goes first
+func ytLanguages(opts PostOptions) []string {
+	if opts.Language == "" {
+		return ConfigNow().YtDownloadLanguages
+	}
+	return append([]string{opts.Language}, ConfigNow().YtDownloadLanguages...)
+}
+
+// ytLanguageCaption returns the audio track name to add to the caption for videos with multiple audio tracks
+func ytLanguageCaption(f ytdl.Format) string {
+	if f.AudioTrack == nil {
+		return ""
+	}
+	return SP + f.LanguageDisplayName()
+}
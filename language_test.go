@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	ytdl "github.com/kkdai/youtube/v2"
+)
+
+func TestYtAudioTrackPick(t *testing.T) {
+	var formats ytdl.FormatList
+	if err := json.Unmarshal([]byte(`[
+		{"itag":1,"audioTrack":{"displayName":"English (United States) original","id":"en-US.4","audioIsDefault":true}},
+		{"itag":2,"audioTrack":{"displayName":"German","id":"de-DE.3","audioIsDefault":false}},
+		{"itag":3,"audioTrack":{"displayName":"Ukrainian","id":"uk.3","audioIsDefault":false}}
+	]`), &formats); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		languages []string
+		want      string
+	}{
+		{nil, "en-US.4"},
+		{[]string{"german"}, "de-DE.3"},
+		{[]string{"de"}, "de-DE.3"},
+		{[]string{"russian", "uk"}, "uk.3"},
+		{[]string{"russian"}, "en-US.4"},
+	} {
+		if trackid := ytAudioTrackPick(formats, c.languages); trackid != c.want {
+			t.Errorf("ytAudioTrackPick %v [%s] want [%s]", c.languages, trackid, c.want)
+		}
+	}
+
+	if trackid := ytAudioTrackPick(ytdl.FormatList{{ItagNo: 4}}, []string{"de"}); trackid != "" {
+		t.Errorf("ytAudioTrackPick single track [%s]", trackid)
+	}
+}
+
+func TestYtAudioTrackMatches(t *testing.T) {
+	for _, c := range []struct {
+		displayname, id, lang string
+		want                  bool
+	}{
+		{"Spanish", "es-US.3", "es", true},
+		{"Estonian", "et.3", "es", false},
+		{"Arabic", "ar.3", "ar", true},
+		{"Armenian", "hy.3", "ar", false},
+		{"Belarusian", "be.3", "be", true},
+		{"Bengali", "bn.3", "be", false},
+		{"Slovenian", "sl.3", "sl", true},
+		{"Slovak", "sk.3", "sl", false},
+		{"Georgian", "ka.3", "ka", true},
+		{"Kannada", "kn.3", "ka", false},
+		{"Portuguese (Brazil)", "pt-BR.3", "pt-BR", true},
+		{"Portuguese (Portugal)", "pt-PT.3", "pt-BR", false},
+		{"German (Germany)", "de-DE.3", "german", true},
+	} {
+		if ok := ytAudioTrackMatches(c.displayname, c.id, c.lang); ok != c.want {
+			t.Errorf("ytAudioTrackMatches [%s] [%s] [%s] <%v> want <%v>", c.displayname, c.id, c.lang, ok, c.want)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// YtRequest is a youtube link with options from a message text
+type YtRequest struct {
+	VideoId string
+	ListId  string
+	// ListIndex is the one based playlist index from the link
+	ListIndex int64
+
+	// ListFrom and ListTo are zero based playlist positions, ListTo below zero means the end of the list
+	ListFrom int64
+	ListTo   int64
+
+	// ClipStart and ClipEnd are the time range of a single video, zero ClipEnd means the end of the video
+	ClipStart time.Duration
+	ClipEnd   time.Duration
+
+	// Chapters means audio is split by the chapters of the video
+	Chapters bool
+
+	// Language is the audio track language code or name
+	Language string
+
+	// Force means posting the video even if it was posted to the chat before
+	Force bool
+
+	// Options are the words after the link kept in the message text
+	Options []string
+}
+
+// parseYtRequest parses the message words into the request, ok is false if the words are not a youtube request
+//
+//	youtu.be/ID?t=START [START | START- | START-END] [chapters] [LANGUAGE] [force]
+//	youtube.com/playlist?list=LIST [N | N- | N-M] [chapters] [LANGUAGE]
+//	youtube.com/watch?v=ID&list=LIST&index=N [all | one | N | N- | N-M] [chapters] [LANGUAGE]
+//
+// A watch link with a list but without index or list options is just the video,
+// such links come from videos opened in a list or a mix.
+func parseYtRequest(mtff []string) (r YtRequest, ok bool) {
+	if len(mtff) == 0 {
+		return r, false
+	}
+
+	link := mtff[0]
+	if ssm := YtListRe.FindStringSubmatch(link); len(ssm) > 1 {
+		r.ListId = ssm[1]
+	} else if ssm := YtRe.FindStringSubmatch(link); len(ssm) > 1 {
+		r.VideoId = ssm[1]
+	}
+
+	if strings.Contains(link, "youtube.com/") || strings.Contains(link, "youtu.be/") {
+		if !strings.Contains(link, "://") {
+			link = "https://" + link
+		}
+		if u, err := url.Parse(link); err == nil {
+			q := u.Query()
+			if r.ListId == "" {
+				r.ListId = q.Get("list")
+			}
+			if r.VideoId == "" && strings.HasSuffix(u.Path, "/watch") {
+				r.VideoId = q.Get("v")
+			}
+			if index, err := strconv.ParseInt(q.Get("index"), 10, 64); err == nil && index > 0 {
+				r.ListIndex = index
+			}
+			if start, err := parseClipTime(q.Get("t")); err == nil {
+				r.ClipStart = start
+			}
+		}
+	}
+
+	if r.VideoId == "" && r.ListId == "" {
+		return r, false
+	}
+
+	r.ListTo = -1
+	if r.VideoId != "" && r.ListId != "" && r.ListIndex > 0 {
+		r.ListFrom = r.ListIndex - 1
+	}
+
+	var clipoption, listoption bool
+	for _, o := range mtff[1:] {
+		if o == "force" && !r.Force && r.ListId == "" {
+			r.Force = true
+			r.Options = append(r.Options, o)
+			continue
+		}
+		if o == "chapters" && !r.Chapters {
+			r.Chapters = true
+			r.Options = append(r.Options, o)
+			continue
+		}
+		if r.Language == "" && isYtLanguage(o) {
+			r.Language = o
+			r.Options = append(r.Options, o)
+			continue
+		}
+		if r.ListId == "" {
+			start, end, err := parseClipRange(o)
+			if err != nil || clipoption {
+				return r, false
+			}
+			r.ClipStart, r.ClipEnd = start, end
+			r.Options = append(r.Options, o)
+			clipoption = true
+			continue
+		}
+		switch {
+		case o == "all" && r.VideoId != "":
+			r.ListFrom, r.ListTo = 0, -1
+			r.Options = append(r.Options, o)
+			listoption = true
+		case o == "one" && r.VideoId != "":
+			r.ListId, r.ListIndex, r.ListFrom = "", 0, 0
+		default:
+			from, to, err := parseListRange(o)
+			if err != nil {
+				return r, false
+			}
+			r.ListFrom, r.ListTo = from, to
+			r.Options = append(r.Options, o)
+			listoption = true
+		}
+	}
+
+	if r.VideoId != "" && r.ListId != "" && r.ListIndex == 0 && !listoption {
+		r.ListId = ""
+	}
+
+	if r.Chapters && clipoption {
+		return r, false
+	}
+	if r.ListId != "" || r.Chapters {
+		r.ClipStart, r.ClipEnd = 0, 0
+	}
+
+	return r, true
+}
+
+// parseListRange parses one based `N`, `N-` or `N-M` into zero based positions
+func parseListRange(s string) (from, to int64, err error) {
+	fromstr, tostr, isrange := strings.Cut(s, "-")
+	from, err = strconv.ParseInt(fromstr, 10, 64)
+	if err != nil || from < 1 {
+		return 0, 0, fmt.Errorf("invalid range start [%s]", s)
+	}
+	switch {
+	case !isrange:
+		to = from
+	case tostr == "":
+		to = 0
+	default:
+		to, err = strconv.ParseInt(tostr, 10, 64)
+		if err != nil || to < from {
+			return 0, 0, fmt.Errorf("invalid range end [%s]", s)
+		}
+	}
+	return from - 1, to - 1, nil
+}
+
+// Url returns the canonical link
+func (r YtRequest) Url() string {
+	switch {
+	case r.VideoId != "" && r.ListId != "":
+		yturl := F("youtube.com/watch?v=%s&list=%s", r.VideoId, r.ListId)
+		if r.ListIndex > 0 {
+			yturl += F("&index=%d", r.ListIndex)
+		}
+		return yturl
+	case r.ListId != "":
+		return F("youtube.com/playlist?list=%s", r.ListId)
+	case r.ClipStart > 0 && !slices.ContainsFunc(r.Options, func(o string) bool { _, _, err := parseClipRange(o); return err == nil }):
+		return F("youtu.be/%s?t=%d", r.VideoId, int64(r.ClipStart.Seconds()))
+	default:
+		return F("youtu.be/%s", r.VideoId)
+	}
+}
+
+// Text returns the canonical link with the options
+func (r YtRequest) Text() string {
+	return strings.Join(append([]string{r.Url()}, r.Options...), SP)
+}
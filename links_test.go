@@ -0,0 +1,64 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseYtRequest(t *testing.T) {
+	for _, c := range []struct {
+		text string
+		ok   bool
+		want YtRequest
+		url  string
+	}{
+		{"https://youtu.be/abc", true, YtRequest{VideoId: "abc", ListTo: -1}, "youtu.be/abc"},
+		{"https://www.youtube.com/playlist?list=PLx", true, YtRequest{ListId: "PLx", ListTo: -1}, "youtube.com/playlist?list=PLx"},
+		{"https://www.youtube.com/playlist?list=PLx 3-5", true, YtRequest{ListId: "PLx", ListFrom: 2, ListTo: 4, Options: []string{"3-5"}}, "youtube.com/playlist?list=PLx"},
+		{"https://www.youtube.com/playlist?list=PLx 3-", true, YtRequest{ListId: "PLx", ListFrom: 2, ListTo: -1, Options: []string{"3-"}}, "youtube.com/playlist?list=PLx"},
+		{"https://www.youtube.com/watch?v=abc&list=PLx&index=4", true, YtRequest{VideoId: "abc", ListId: "PLx", ListIndex: 4, ListFrom: 3, ListTo: -1}, "youtube.com/watch?v=abc&list=PLx&index=4"},
+		{"https://www.youtube.com/watch?list=PLx&v=abc", true, YtRequest{VideoId: "abc", ListTo: -1}, "youtu.be/abc"},
+		{"https://www.youtube.com/watch?v=abc&list=RDabc", true, YtRequest{VideoId: "abc", ListTo: -1}, "youtu.be/abc"},
+		{"https://www.youtube.com/watch?v=abc&list=RDabc&index=2", true, YtRequest{VideoId: "abc", ListId: "RDabc", ListIndex: 2, ListFrom: 1, ListTo: -1}, "youtube.com/watch?v=abc&list=RDabc&index=2"},
+		{"https://www.youtube.com/watch?v=abc&list=PLx 2-3", true, YtRequest{VideoId: "abc", ListId: "PLx", ListFrom: 1, ListTo: 2, Options: []string{"2-3"}}, "youtube.com/watch?v=abc&list=PLx"},
+		{"https://www.youtube.com/watch?v=abc&list=PLx all", true, YtRequest{VideoId: "abc", ListId: "PLx", ListTo: -1, Options: []string{"all"}}, "youtube.com/watch?v=abc&list=PLx"},
+		{"https://www.youtube.com/watch?v=abc&list=PLx&index=4 all", true, YtRequest{VideoId: "abc", ListId: "PLx", ListIndex: 4, ListTo: -1, Options: []string{"all"}}, "youtube.com/watch?v=abc&list=PLx&index=4"},
+		{"https://www.youtube.com/watch?v=abc&list=PLx&index=4 one", true, YtRequest{VideoId: "abc", ListTo: -1}, "youtu.be/abc"},
+		{"https://youtu.be/abc?t=754", true, YtRequest{VideoId: "abc", ListTo: -1, ClipStart: 754 * time.Second}, "youtu.be/abc?t=754"},
+		{"https://youtu.be/abc?t=12m34s 1:00-2:30", true, YtRequest{VideoId: "abc", ListTo: -1, ClipStart: time.Minute, ClipEnd: 150 * time.Second, Options: []string{"1:00-2:30"}}, "youtu.be/abc"},
+		{"https://www.youtube.com/watch?v=abc&list=PLx&t=30 one 1:00-", true, YtRequest{VideoId: "abc", ListTo: -1, ClipStart: time.Minute, Options: []string{"1:00-"}}, "youtu.be/abc"},
+		{"https://www.youtube.com/watch?v=abc&list=PLx&t=30", true, YtRequest{VideoId: "abc", ListTo: -1, ClipStart: 30 * time.Second}, "youtu.be/abc?t=30"},
+		{"https://youtu.be/abc?t=30 chapters", true, YtRequest{VideoId: "abc", ListTo: -1, Chapters: true, Options: []string{"chapters"}}, "youtu.be/abc"},
+		{"https://www.youtube.com/playlist?list=PLx chapters 2-", true, YtRequest{ListId: "PLx", ListFrom: 1, ListTo: -1, Chapters: true, Options: []string{"chapters", "2-"}}, "youtube.com/playlist?list=PLx"},
+		{"https://youtu.be/abc 1:00 chapters", false, YtRequest{}, ""},
+		{"https://youtu.be/abc de 1:00-", true, YtRequest{VideoId: "abc", ListTo: -1, ClipStart: time.Minute, Language: "de", Options: []string{"de", "1:00-"}}, "youtu.be/abc"},
+		{"https://youtu.be/abc?t=30 force", true, YtRequest{VideoId: "abc", ListTo: -1, ClipStart: 30 * time.Second, Force: true, Options: []string{"force"}}, "youtu.be/abc?t=30"},
+		{"https://www.youtube.com/playlist?list=PLx force", false, YtRequest{}, ""},
+		{"https://youtu.be/abc de fr", false, YtRequest{}, ""},
+		{"https://youtu.be/abc pt-BR", true, YtRequest{VideoId: "abc", ListTo: -1, Language: "pt-BR", Options: []string{"pt-BR"}}, "youtu.be/abc"},
+		{"https://youtu.be/abc xx", false, YtRequest{}, ""},
+		{"https://youtu.be/abc 3 4", false, YtRequest{}, ""},
+		{"https://youtu.be/abc 2:00-1:00", false, YtRequest{}, ""},
+		{"https://www.youtube.com/playlist?list=PLx 5-3", false, YtRequest{}, ""},
+		{"hello", false, YtRequest{}, ""},
+	} {
+		r, ok := parseYtRequest(strings.Fields(c.text))
+		if ok != c.ok {
+			t.Errorf("parseYtRequest [%s] ok <%v>", c.text, ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if r.VideoId != c.want.VideoId || r.ListId != c.want.ListId || r.ListIndex != c.want.ListIndex ||
+			r.ListFrom != c.want.ListFrom || r.ListTo != c.want.ListTo ||
+			r.ClipStart != c.want.ClipStart || r.ClipEnd != c.want.ClipEnd || r.Chapters != c.want.Chapters || r.Language != c.want.Language ||
+			strings.Join(r.Options, SP) != strings.Join(c.want.Options, SP) {
+			t.Errorf("parseYtRequest [%s] %+v want %+v", c.text, r, c.want)
+		}
+		if r.Url() != c.url {
+			t.Errorf("parseYtRequest [%s] url [%s] want [%s]", c.text, r.Url(), c.url)
+		}
+	}
+}
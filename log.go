@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	LogFormatText = "text"
+	LogFormatJson = "json"
+)
+
+// loggers of the subsystems, debug records of a subsystem are logged if DEBUG is set or the subsystem is in DebugSubsystems
+var (
+	Log              = logger("main")
+	LogJobs          = logger("jobs")
+	LogTg            = logger("tg")
+	LogYt            = logger("yt")
+	LogDss           = logger("dss")
+	LogFfmpeg        = logger("ffmpeg")
+	LogWebhook       = logger("webhook")
+	LogSubscriptions = logger("subscriptions")
+	LogCache         = logger("cache")
+)
+
+type logState struct {
+	handler  slog.Handler
+	debugall bool
+	debug    []string
+}
+
+var (
+	// LogOutput is where LogConfigure sends the records
+	LogOutput io.Writer = os.Stderr
+
+	logCurrent atomic.Pointer[logState]
+)
+
+func init() {
+	if err := LogConfigure(LogFormatText, false, nil); err != nil {
+		panic(err)
+	}
+}
+
+// LogConfigure sets the output format and the debug subsystems of all the loggers
+func LogConfigure(format string, debugall bool, debug []string) error {
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug}
+	var h slog.Handler
+	switch format {
+	case LogFormatText, "":
+		opts.ReplaceAttr = func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey && len(groups) == 0 {
+				return slog.String(slog.TimeKey, fmttime(a.Value.Time()))
+			}
+			return a
+		}
+		h = slog.NewTextHandler(LogOutput, opts)
+	case LogFormatJson:
+		h = slog.NewJSONHandler(LogOutput, opts)
+	default:
+		return fmt.Errorf("LogFormat [%s] unsupported", format)
+	}
+	logCurrent.Store(&logState{handler: h, debugall: debugall, debug: slices.Clone(debug)})
+	return nil
+}
+
+// LogDebug reports if debug records of the subsystem are logged
+func LogDebug(subsystem string) bool {
+	s := logCurrent.Load()
+	return s.debugall || slices.Contains(s.debug, subsystem)
+}
+
+func logger(subsystem string) *slog.Logger {
+	return slog.New(&LogHandler{subsystem: subsystem})
+}
+
+// LogHandler adds the subsystem to the records, filters debug records by the subsystem
+// and redacts the secrets before passing the records to the current output handler
+type LogHandler struct {
+	subsystem string
+	wrap      []func(slog.Handler) slog.Handler
+}
+
+func (h *LogHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= slog.LevelInfo || LogDebug(h.subsystem)
+}
+
+func (h *LogHandler) Handle(ctx context.Context, r slog.Record) error {
+	out := logCurrent.Load().handler.WithAttrs([]slog.Attr{slog.String("subsystem", h.subsystem)})
+	for _, wrap := range h.wrap {
+		out = wrap(out)
+	}
+
+	r2 := slog.NewRecord(r.Time, r.Level, logRedact(r.Message), r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		r2.AddAttrs(logRedactAttr(a))
+		return true
+	})
+	return out.Handle(ctx, r2)
+}
+
+func (h *LogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	var redacted []slog.Attr
+	for _, a := range attrs {
+		redacted = append(redacted, logRedactAttr(a))
+	}
+	return &LogHandler{
+		subsystem: h.subsystem,
+		wrap:      append(slices.Clone(h.wrap), func(out slog.Handler) slog.Handler { return out.WithAttrs(redacted) }),
+	}
+}
+
+func (h *LogHandler) WithGroup(name string) slog.Handler {
+	return &LogHandler{
+		subsystem: h.subsystem,
+		wrap:      append(slices.Clone(h.wrap), func(out slog.Handler) slog.Handler { return out.WithGroup(name) }),
+	}
+}
+
+// logRedact replaces the telegram token, the webhook secret token and the youtube key in the text
+func logRedact(s string) string {
+	if ConfigNow().TgToken != "" {
+		s = strings.ReplaceAll(s, ConfigNow().TgToken, "[Config.TgToken]")
+	}
+	if ConfigNow().TgWebhookSecretToken != "" {
+		s = strings.ReplaceAll(s, ConfigNow().TgWebhookSecretToken, "[Config.TgWebhookSecretToken]")
+	}
+	if ConfigNow().YtKey != "" {
+		s = strings.ReplaceAll(s, ConfigNow().YtKey, "[Config.YtKey]")
+	}
+	return s
+}
+
+func logRedactAttr(a slog.Attr) slog.Attr {
+	a.Value = a.Value.Resolve()
+	switch a.Value.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, logRedact(a.Value.String()))
+	case slog.KindAny:
+		if err, ok := a.Value.Any().(error); ok {
+			return slog.String(a.Key, logRedact(err.Error()))
+		}
+	case slog.KindGroup:
+		var attrs []any
+		for _, ga := range a.Value.Group() {
+			attrs = append(attrs, logRedactAttr(ga))
+		}
+		return slog.Group(a.Key, attrs...)
+	}
+	return a
+}
+
+type logArgsKey struct{}
+
+// LogContext returns the context carrying the log attributes of the job like its job_id
+func LogContext(ctx context.Context, args ...any) context.Context {
+	return context.WithValue(ctx, logArgsKey{}, args)
+}
+
+// LogWith returns the subsystem logger with the log attributes of the context
+func LogWith(ctx context.Context, l *slog.Logger) *slog.Logger {
+	if args, ok := ctx.Value(logArgsKey{}).([]any); ok {
+		return l.With(args...)
+	}
+	return l
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testLogBuffer collects the log output, the job workers may log concurrently
+type testLogBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *testLogBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *testLogBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func testLogConfigure(t *testing.T, format string, debug []string) *testLogBuffer {
+	t.Helper()
+	b := &testLogBuffer{}
+	LogOutput = b
+	if err := LogConfigure(format, false, debug); err != nil {
+		t.Fatalf("LogConfigure %v", err)
+	}
+	t.Cleanup(func() {
+		LogOutput = os.Stderr
+		LogConfigure(Config.LogFormat, Config.DEBUG, Config.DebugSubsystems)
+	})
+	return b
+}
+
+func TestLogJsonRedact(t *testing.T) {
+	b := testLogConfigure(t, LogFormatJson, nil)
+
+	LogYt.With("key", Config.YtKey).Info("get "+Config.TgToken, "video_id", "log1", "err", errors.New("url ?key="+Config.YtKey))
+	LogTg.Info("tgPostJson", "method", "setWebhook", "request", `{"secret_token":"`+Config.TgWebhookSecretToken+`"}`)
+
+	var line string
+	for _, l := range strings.Split(b.String(), NL) {
+		if strings.Contains(l, `"log1"`) {
+			line = l
+		}
+	}
+	if strings.Contains(line, Config.TgToken) || strings.Contains(line, Config.YtKey) {
+		t.Errorf("secret in log line %s", line)
+	}
+	var record map[string]any
+	if err := json.Unmarshal([]byte(line), &record); err != nil {
+		t.Fatalf("json.Unmarshal [%s] %v", line, err)
+	}
+	if record["subsystem"] != "yt" || record["level"] != "INFO" || record["video_id"] != "log1" {
+		t.Errorf("record %v", record)
+	}
+	if record["msg"] != "get [Config.TgToken]" || record["err"] != "url ?key=[Config.YtKey]" || record["key"] != "[Config.YtKey]" {
+		t.Errorf("record not redacted %v", record)
+	}
+	if s := b.String(); !strings.Contains(s, `[Config.TgWebhookSecretToken]`) || strings.Contains(s, Config.TgWebhookSecretToken) {
+		t.Errorf("webhook secret token not redacted %s", s)
+	}
+}
+
+func TestLogDebugSubsystems(t *testing.T) {
+	b := testLogConfigure(t, LogFormatText, []string{"ffmpeg"})
+
+	if !LogDebug("ffmpeg") || LogDebug("yt") {
+		t.Errorf("LogDebug ffmpeg <%v> yt <%v>", LogDebug("ffmpeg"), LogDebug("yt"))
+	}
+	LogFfmpeg.Debug("debug-log-ffmpeg")
+	LogYt.Debug("debug-log-yt")
+	LogYt.Warn("warn-log-yt")
+
+	out := b.String()
+	if !strings.Contains(out, "debug-log-ffmpeg") || !strings.Contains(out, "subsystem=ffmpeg") {
+		t.Errorf("no ffmpeg debug record in %s", out)
+	}
+	if strings.Contains(out, "debug-log-yt") {
+		t.Errorf("yt debug record in %s", out)
+	}
+	if !strings.Contains(out, "level=WARN") || !strings.Contains(out, "warn-log-yt") {
+		t.Errorf("no yt warn record in %s", out)
+	}
+
+	if err := LogConfigure("xml", false, nil); err == nil {
+		t.Errorf("LogConfigure xml no error")
+	}
+}
+
+func TestLogWith(t *testing.T) {
+	b := testLogConfigure(t, LogFormatJson, nil)
+
+	ctx := LogContext(context.Background(), "job_id", 7, "chat_id", 8)
+	LogWith(ctx, LogYt).Info("downloaded", "video_id", "log3")
+	LogWith(context.Background(), LogYt).Info("downloaded", "video_id", "log4")
+
+	var records []map[string]any
+	for _, l := range strings.Split(b.String(), NL) {
+		if !strings.Contains(l, `"log3"`) && !strings.Contains(l, `"log4"`) {
+			continue
+		}
+		var record map[string]any
+		if err := json.Unmarshal([]byte(l), &record); err != nil {
+			t.Fatalf("json.Unmarshal [%s] %v", l, err)
+		}
+		records = append(records, record)
+	}
+	if len(records) != 2 || records[0]["job_id"] != 7.0 || records[0]["chat_id"] != 8.0 || records[0]["subsystem"] != "yt" {
+		t.Errorf("records %v", records)
+	}
+	if _, ok := records[1]["job_id"]; ok {
+		t.Errorf("record without context attributes %v", records[1])
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	MetricsListenDefault = ":9090"
+
+	MetricsBackendYtdl = "ytdl"
+	MetricsBackendDss  = "dss"
+)
+
+var (
+	MetricsUpdates = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "tgze_updates_total",
+		Help: "Telegram updates processed by result.",
+	}, []string{"result"})
+
+	MetricsDownloads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "tgze_downloads_total",
+		Help: "Downloads started by backend and media.",
+	}, []string{"backend", "media"})
+
+	MetricsDownloadBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "tgze_download_bytes_total",
+		Help: "Bytes downloaded by backend.",
+	}, []string{"backend"})
+
+	MetricsTranscodeDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "tgze_transcode_duration_seconds",
+		Help:    "Duration of ffmpeg transcodes and cuts.",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 12),
+	}, []string{"op"})
+
+	MetricsTgApiErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "tgze_tg_api_errors_total",
+		Help: "Telegram Bot API errors by method.",
+	}, []string{"method"})
+
+	MetricsTgApiRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "tgze_tg_api_retries_total",
+		Help: "Telegram Bot API requests repeated by method.",
+	}, []string{"method"})
+
+	MetricsYtApiCalls = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "tgze_yt_api_calls_total",
+		Help: "YouTube Data API calls by endpoint and result.",
+	}, []string{"endpoint", "result"})
+
+	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "tgze_jobs_queued",
+		Help: "Jobs waiting in the chat queues.",
+	}, func() float64 {
+		queued, _ := Jobs.Len()
+		return float64(queued)
+	})
+
+	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "tgze_jobs_running",
+		Help: "Jobs being processed by the workers.",
+	}, func() float64 {
+		_, running := Jobs.Len()
+		return float64(running)
+	})
+)
+
+// MetricsStart starts the http server serving the prometheus metrics and the health probes
+func MetricsStart() {
+	Log.Info("metrics listen", "addr", ConfigNow().MetricsListen)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/healthz", HealthLiveHandler)
+	mux.HandleFunc("/readyz", HealthReadyHandler)
+
+	go func() {
+		if err := http.ListenAndServe(ConfigNow().MetricsListen, mux); err != nil {
+			Log.Error("metrics http.ListenAndServe", "err", err)
+		}
+	}()
+}
+
+// TgMetricsTransport counts failed Bot API requests by the method from the request url
+type TgMetricsTransport struct {
+	Transport http.RoundTripper
+}
+
+func (t *TgMetricsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.Transport.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		MetricsTgApiErrors.WithLabelValues(req.URL.Path[strings.LastIndex(req.URL.Path, "/")+1:]).Inc()
+	}
+	return resp, err
+}
+
+// metricsYtApiCall counts the request if the url is a Data API one
+func metricsYtApiCall(url string, err error) {
+	if !strings.HasPrefix(url, ConfigNow().YtApiUrl+"/") {
+		return
+	}
+	endpoint, _, _ := strings.Cut(strings.TrimPrefix(url, ConfigNow().YtApiUrl+"/"), "?")
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	MetricsYtApiCalls.WithLabelValues(endpoint, result).Inc()
+}
+
+// metricsTranscode observes the duration of the ffmpeg run started at the time
+func metricsTranscode(op string, start time.Time) {
+	MetricsTranscodeDuration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+}
+
+type metricsReader struct {
+	r       io.Reader
+	counter prometheus.Counter
+}
+
+func (mr metricsReader) Read(p []byte) (n int, err error) {
+	n, err = mr.r.Read(p)
+	mr.counter.Add(float64(n))
+	return n, err
+}
+
+// metricsDownload counts the download and returns the reader counting the downloaded bytes
+func metricsDownload(r io.Reader, backend, media string) io.Reader {
+	MetricsDownloads.WithLabelValues(backend, media).Inc()
+	return metricsReader{r: r, counter: MetricsDownloadBytes.WithLabelValues(backend)}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+func TestMetrics(t *testing.T) {
+	if _, err := getList(Ctx, "PLmetrics", 0, -1); err != nil {
+		t.Fatalf("getList %v", err)
+	}
+	u, uj := testMessageUpdate(t, 117, "", "https://youtu.be/metrics1")
+	if err := TgHandleUpdate(u, uj); err != nil {
+		t.Fatalf("TgHandleUpdate %v", err)
+	}
+	FakeTgServer.WaitFor(t, "sendAudio", 117, 1)
+
+	w := httptest.NewRecorder()
+	promhttp.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(w.Result().Body)
+	for _, metric := range []string{
+		`tgze_updates_total{result="ok"}`,
+		`tgze_yt_api_calls_total{endpoint="playlistItems",result="ok"}`,
+		`tgze_downloads_total{backend="dss",media="audio"}`,
+		`tgze_download_bytes_total{backend="dss"}`,
+		`tgze_jobs_queued`,
+	} {
+		if !strings.Contains(string(body), metric) {
+			t.Errorf("no metric %s", metric)
+		}
+	}
+}
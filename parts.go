@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"time"
+
+	ytdl "github.com/kkdai/youtube/v2"
+
+	"github.com/shoce/tg"
+)
+
+const (
+	// TgPartFitAttempts is how many times a part bigger than TgMaxFileSizeBytes is cut again at a lower bitrate
+	TgPartFitAttempts = 3
+)
+
+// MediaPart is a time range of a media file to be sent as a separate message
+type MediaPart struct {
+	Start    time.Duration
+	Duration time.Duration
+
+	// Name is added to the caption and to the title
+	Name string
+
+	// Title and Performer replace the audio tags when set
+	Title     string
+	Performer string
+}
+
+// splitParts returns equal consecutive parts of the duration so that every part of the media of the size is not bigger than maxsize
+func splitParts(duration time.Duration, size int64, maxsize int64) (parts []MediaPart) {
+	if size <= maxsize || maxsize <= 0 {
+		return nil
+	}
+
+	n := (size + maxsize - 1) / maxsize
+	partdur := (duration/time.Duration(n) + time.Second - 1).Truncate(time.Second)
+
+	for start := time.Duration(0); start < duration; start += partdur {
+		parts = append(parts, MediaPart{
+			Start:    start,
+			Duration: min(partdur, duration-start),
+		})
+	}
+	for i := range parts {
+		parts[i].Name = fmt.Sprintf("part %d/%d", i+1, len(parts))
+	}
+
+	return parts
+}
+
+// splitFormat returns the smallest of the formats at or above the bitrate floor minkbps to be posted in parts,
+// the largest format when none is so the parts are not transcoded below the floor
+func splitFormat(formats []ytdl.Format, minkbps int64) (format ytdl.Format) {
+	var largest ytdl.Format
+	for _, f := range formats {
+		if int64(f.Bitrate/1024) >= minkbps && (format.ItagNo == 0 || f.Bitrate < format.Bitrate) {
+			format = f
+		}
+		if largest.ItagNo == 0 || f.Bitrate > largest.Bitrate {
+			largest = f
+		}
+	}
+	if format.ItagNo == 0 {
+		return largest
+	}
+	return format
+}
+
+// partFitKbps returns the total bitrate making the part of the duration fit into maxsize,
+// every attempt takes a tenth less to leave room for the container overhead
+func partFitKbps(maxsize int64, duration time.Duration, attempt int) int64 {
+	kbps := float64(maxsize*8/1024) / max(duration.Seconds(), 1)
+	for range attempt + 1 {
+		kbps *= 0.9
+	}
+	return int64(kbps)
+}
+
+// FfmpegCutFit cuts the part like FfmpegCut and, as equal durations of variable bitrate media differ in size,
+// cuts it again transcoded to the bitrate fitting maxsize while the part file is still bigger than maxsize
+func FfmpegCutFit(ctx context.Context, filename, filename2 string, p MediaPart, video bool, videoBitrateKbps, audioBitrateKbps int64, maxsize int64) (err error) {
+	if err := FfmpegCut(ctx, filename, filename2, p.Start, p.Duration, videoBitrateKbps, audioBitrateKbps); err != nil {
+		return err
+	}
+
+	for attempt := 0; ; attempt++ {
+		stat, err := os.Stat(filename2)
+		if err != nil {
+			return fmt.Errorf("os.Stat %w", err)
+		}
+		if maxsize <= 0 || stat.Size() <= maxsize {
+			return nil
+		}
+		if attempt == TgPartFitAttempts {
+			return fmt.Errorf("%s size <%d> is still bigger than <%d>", p.Name, stat.Size(), maxsize)
+		}
+
+		kbps := partFitKbps(maxsize, p.Duration, attempt)
+		if video {
+			audioBitrateKbps = ConfigFrom(ctx).TgVideoAudioBitrateKbps
+			videoBitrateKbps = kbps - audioBitrateKbps
+			if videoBitrateKbps <= 0 {
+				return fmt.Errorf("%s size <%d> does not fit <%d> at audio bitrate <%dkbps>", p.Name, stat.Size(), maxsize, audioBitrateKbps)
+			}
+		} else {
+			audioBitrateKbps = kbps
+		}
+		LogFfmpeg.Warn("part too big, transcoding", "file", filename2, "part", p.Name, "size", stat.Size(), "max_size", maxsize, "video_kbps", videoBitrateKbps, "audio_kbps", audioBitrateKbps)
+
+		if err := FfmpegCut(ctx, filename, filename2, p.Start, p.Duration, videoBitrateKbps, audioBitrateKbps); err != nil {
+			return err
+		}
+	}
+}
+
+// FfmpegCut writes the part of the file starting at start with duration dur to filename2,
+// transcoding when bitrates are specified and copying streams otherwise
+func FfmpegCut(ctx context.Context, filename, filename2 string, start, dur time.Duration, videoBitrateKbps, audioBitrateKbps int64) (err error) {
+	LogFfmpeg.Debug("cutting", "file", filename, "start", start, "duration", dur, "video_kbps", videoBitrateKbps, "audio_kbps", audioBitrateKbps)
+	defer metricsTranscode("cut", time.Now())
+
+	ffmpegArgs := append(slices.Clone(ConfigFrom(ctx).FfmpegGlobalOptions),
+		"-ss", fmt.Sprintf("%.3f", start.Seconds()),
+		"-i", filename,
+		"-t", fmt.Sprintf("%.3f", dur.Seconds()),
+		"-f", "mp4",
+	)
+	if videoBitrateKbps > 0 {
+		ffmpegArgs = append(ffmpegArgs,
+			"-c:v", "h264",
+			"-b:v", fmt.Sprintf("%dk", videoBitrateKbps),
+		)
+	} else {
+		ffmpegArgs = append(ffmpegArgs,
+			"-c:v", "copy",
+		)
+	}
+	if audioBitrateKbps > 0 {
+		ffmpegArgs = append(ffmpegArgs,
+			"-c:a", "aac",
+			"-b:a", fmt.Sprintf("%dk", audioBitrateKbps),
+		)
+	} else {
+		ffmpegArgs = append(ffmpegArgs,
+			"-c:a", "copy",
+		)
+	}
+	ffmpegArgs = append(ffmpegArgs,
+		filename2,
+	)
+
+	return ffmpegRun(ctx, ffmpegArgs, dur)
+}
+
+// postAudioParts cuts the parts from the file and sends each one with the part name added to the caption and to the title
+// or with the part title and performer tags when the part has them, msg is the message of the first part
+func postAudioParts(ctx context.Context, filename string, parts []MediaPart, audioBitrateKbps int64, thumbBytes []byte, req tg.SendAudioFileRequest) (msg *tg.Message, err error) {
+	caption, title, performer := req.Caption, req.Title, req.Performer
+
+	for i, p := range parts {
+		partFilename := fmt.Sprintf("%s.part%d.m4a", filename, i+1)
+		defer tempRemove(LogWith(ctx, LogFfmpeg), partFilename)
+		if err := FfmpegCutFit(ctx, filename, partFilename, p, false, 0, audioBitrateKbps, ConfigFrom(ctx).TgMaxFileSizeBytes); err != nil {
+			return msg, fmt.Errorf("FfmpegCutFit %s %w", p.Name, err)
+		}
+
+		req.Caption = caption + NL + p.Name
+		req.Title = title + SP + p.Name
+		req.Performer = performer
+		if p.Performer != "" {
+			req.Caption += SP + p.Performer + " -"
+			req.Performer = p.Performer
+		}
+		if p.Title != "" {
+			req.Caption += SP + p.Title
+			req.Title = p.Title
+		}
+		req.Duration = p.Duration
+
+		ProgressFrom(ctx).Stage(ProgressStageUploading)
+		partMsg, tgerr := tgUploadRetry(ctx, "sendAudio", partFilename, func(f io.Reader) (*tg.Message, error) {
+			req.Audio = f
+			req.Thumb = bytes.NewReader(thumbBytes)
+			return TgSendAudioFile(ctx, req)
+		})
+
+		if err := os.Remove(partFilename); err != nil {
+			LogWith(ctx, LogTg).Error("os.Remove", "file", partFilename, "err", err)
+		}
+
+		if tgerr != nil {
+			return msg, fmt.Errorf("TgSendAudioFile %s %w", p.Name, tgerr)
+		}
+		if msg == nil {
+			msg = partMsg
+		}
+	}
+
+	return msg, nil
+}
+
+// postVideoParts cuts the parts from the file and sends each one with the part name added to the caption,
+// msg is the message of the first part
+func postVideoParts(ctx context.Context, filename string, parts []MediaPart, videoBitrateKbps int64, req tg.SendVideoFileRequest) (msg *tg.Message, err error) {
+	caption := req.Caption
+
+	for i, p := range parts {
+		partFilename := fmt.Sprintf("%s.part%d.mp4", filename, i+1)
+		defer tempRemove(LogWith(ctx, LogFfmpeg), partFilename)
+		if err := FfmpegCutFit(ctx, filename, partFilename, p, true, videoBitrateKbps, 0, ConfigFrom(ctx).TgMaxFileSizeBytes); err != nil {
+			return msg, fmt.Errorf("FfmpegCutFit %s %w", p.Name, err)
+		}
+
+		req.Caption = caption + NL + p.Name
+		req.Duration = p.Duration
+
+		ProgressFrom(ctx).Stage(ProgressStageUploading)
+		partMsg, tgerr := tgUploadRetry(ctx, "sendVideo", partFilename, func(f io.Reader) (*tg.Message, error) {
+			req.Video = f
+			return TgSendVideoFile(ctx, req)
+		})
+
+		if err := os.Remove(partFilename); err != nil {
+			LogWith(ctx, LogTg).Error("os.Remove", "file", partFilename, "err", err)
+		}
+
+		if tgerr != nil {
+			return msg, fmt.Errorf("TgSendVideoFile %s %w", p.Name, tgerr)
+		}
+		if msg == nil {
+			msg = partMsg
+		}
+	}
+
+	return msg, nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	ytdl "github.com/kkdai/youtube/v2"
+)
+
+func TestSplitParts(t *testing.T) {
+	if parts := splitParts(time.Hour, 40<<20, 45<<20); parts != nil {
+		t.Errorf("splitParts of fitting size %+v", parts)
+	}
+
+	duration := 5*time.Hour + 7*time.Second
+	parts := splitParts(duration, 140<<20, 45<<20)
+	if len(parts) != 4 {
+		t.Fatalf("splitParts count <%d> expected <4>", len(parts))
+	}
+
+	var total time.Duration
+	for i, p := range parts {
+		if p.Start != total {
+			t.Errorf("part <%d> start <%v> expected <%v>", i, p.Start, total)
+		}
+		if p.Duration <= 0 || p.Duration > duration/4+time.Second {
+			t.Errorf("part <%d> duration <%v>", i, p.Duration)
+		}
+		total += p.Duration
+	}
+	if total != duration {
+		t.Errorf("parts total duration <%v> expected <%v>", total, duration)
+	}
+	if parts[0].Name != "part 1/4" || parts[3].Name != "part 4/4" {
+		t.Errorf("parts names [%s] [%s]", parts[0].Name, parts[3].Name)
+	}
+}
+
+func TestSplitFormat(t *testing.T) {
+	formats := []ytdl.Format{{ItagNo: 139, Bitrate: 48 << 10}, {ItagNo: 140, Bitrate: 128 << 10}, {ItagNo: 141, Bitrate: 256 << 10}}
+	if f := splitFormat(formats, 64); f.ItagNo != 140 {
+		t.Errorf("splitFormat min <64>kbps itag <%d> expected <140>", f.ItagNo)
+	}
+	if f := splitFormat(formats, 48); f.ItagNo != 139 {
+		t.Errorf("splitFormat min <48>kbps itag <%d> expected <139>", f.ItagNo)
+	}
+	if f := splitFormat(formats, 300); f.ItagNo != 141 {
+		t.Errorf("splitFormat min <300>kbps itag <%d> expected <141>", f.ItagNo)
+	}
+}
+
+func TestPartFitKbps(t *testing.T) {
+	// 45mb in 10 minutes is 614kbps, a tenth less on the first attempt
+	if kbps := partFitKbps(45<<20, 10*time.Minute, 0); kbps != 552 {
+		t.Errorf("partFitKbps attempt <0> <%d> expected <552>", kbps)
+	}
+	if kbps := partFitKbps(45<<20, 10*time.Minute, 1); kbps != 497 {
+		t.Errorf("partFitKbps attempt <1> <%d> expected <497>", kbps)
+	}
+	if kbps := partFitKbps(45<<20, 0, 0); kbps <= 0 {
+		t.Errorf("partFitKbps of zero duration <%d>", kbps)
+	}
+}
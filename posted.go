@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shoce/tg"
+)
+
+const (
+	TgPostedMaxSizeDefault = 10000
+)
+
+// TgPostedEntry is the message of a video posted to a chat as the media in the language,
+// entries written by older versions have no Media and are not matched
+type TgPostedEntry struct {
+	ChatId   int64  `yaml:"ChatId"`
+	VideoId  string `yaml:"VideoId"`
+	Media    string `yaml:"Media"`
+	Language string `yaml:"Language"`
+	Chapters bool   `yaml:"Chapters"`
+
+	MessageId int64     `yaml:"MessageId"`
+	Time      time.Time `yaml:"Time"`
+}
+
+// Same reports if the entries are of the same chat, video, media, language and chapters
+func (e TgPostedEntry) Same(e2 TgPostedEntry) bool {
+	return e.ChatId == e2.ChatId && e.VideoId == e2.VideoId && e.Media == e2.Media && e.Language == e2.Language && e.Chapters == e2.Chapters
+}
+
+// TgPostedState is the posted history kept in its own store next to the config
+type TgPostedState struct {
+	TgPosted []TgPostedEntry `yaml:"TgPosted"`
+}
+
+var (
+	TgPostedStore ConfigStore
+	tgPosted      TgPostedState
+	tgPostedMu    sync.Mutex
+)
+
+// TgPostedInit reads the posted history from its store once,
+// the entries of the config written by older versions are moved there
+func TgPostedInit() error {
+	tgPostedMu.Lock()
+	defer tgPostedMu.Unlock()
+
+	TgPostedStore = ConfigStoreCl.Sub("posted")
+	tgPosted = TgPostedState{}
+	if err := StateGet(TgPostedStore, &tgPosted); err != nil {
+		return fmt.Errorf("StateGet posted %w", err)
+	}
+
+	ConfigMu.Lock()
+	defer ConfigMu.Unlock()
+
+	if len(Config.TgPosted) == 0 {
+		return nil
+	}
+	LogCache.Info("moving posted history from config", "entries", len(Config.TgPosted))
+	for _, e := range Config.TgPosted {
+		if !slices.ContainsFunc(tgPosted.TgPosted, e.Same) {
+			tgPosted.TgPosted = append(tgPosted.TgPosted, e)
+		}
+	}
+	if err := StatePut(TgPostedStore, tgPosted); err != nil {
+		return fmt.Errorf("StatePut posted %w", err)
+	}
+	Config.TgPosted = nil
+	return Config.Put()
+}
+
+// TgPostedGet returns the entry the same as the key
+func TgPostedGet(key TgPostedEntry) (e TgPostedEntry, ok bool) {
+	tgPostedMu.Lock()
+	defer tgPostedMu.Unlock()
+
+	i := slices.IndexFunc(tgPosted.TgPosted, key.Same)
+	if i < 0 {
+		return e, false
+	}
+	return tgPosted.TgPosted[i], true
+}
+
+// TgPostedChatIds returns the ids of the chats in the posted history
+func TgPostedChatIds() (ids []int64) {
+	tgPostedMu.Lock()
+	defer tgPostedMu.Unlock()
+
+	for _, e := range tgPosted.TgPosted {
+		if !slices.Contains(ids, e.ChatId) {
+			ids = append(ids, e.ChatId)
+		}
+	}
+	return ids
+}
+
+// TgPostedPut saves the entry replacing the same one and dropping the oldest ones over TgPostedMaxSize
+func TgPostedPut(e TgPostedEntry) error {
+	tgPostedMu.Lock()
+	defer tgPostedMu.Unlock()
+
+	tgPosted.TgPosted = slices.DeleteFunc(tgPosted.TgPosted, e.Same)
+	tgPosted.TgPosted = append(tgPosted.TgPosted, e)
+	if len(tgPosted.TgPosted) > ConfigNow().TgPostedMaxSize {
+		tgPosted.TgPosted = tgPosted.TgPosted[len(tgPosted.TgPosted)-ConfigNow().TgPostedMaxSize:]
+	}
+
+	return StatePut(TgPostedStore, tgPosted)
+}
+
+// TgPostedMigrate moves the posted history of the group upgraded to a supergroup to the supergroup id
+func TgPostedMigrate(from, to int64) error {
+	tgPostedMu.Lock()
+	defer tgPostedMu.Unlock()
+
+	var migrated bool
+	for i := range tgPosted.TgPosted {
+		if tgPosted.TgPosted[i].ChatId == from {
+			tgPosted.TgPosted[i].ChatId = to
+			migrated = true
+		}
+	}
+	if !migrated {
+		return nil
+	}
+
+	return StatePut(TgPostedStore, tgPosted)
+}
+
+// tgPostedSave records the message of the video posted to the chat as the media, clips are not recorded
+func tgPostedSave(chatid int64, videoid string, media string, opts PostOptions, msg *tg.Message) {
+	if opts.ClipStart != 0 || opts.ClipEnd != 0 || msg == nil || msg.MessageId == 0 {
+		return
+	}
+	if err := TgPostedPut(TgPostedEntry{
+		ChatId:    chatid,
+		VideoId:   videoid,
+		Media:     media,
+		Language:  opts.Language,
+		Chapters:  opts.Chapters,
+		MessageId: msg.MessageId,
+		Time:      time.Now(),
+	}); err != nil {
+		LogCache.Error("TgPostedPut", "chat_id", chatid, "video_id", videoid, "err", err)
+	}
+}
+
+// tgMessageLink returns the link to the message in a supergroup or a channel, other chats have no message links
+func tgMessageLink(chat tg.Chat, messageid int64) string {
+	if chat.Username != "" {
+		return F("https://t.me/%s/%d", chat.Username, messageid)
+	}
+	if chatid := fmt.Sprintf("%d", chat.Id); strings.HasPrefix(chatid, "-100") {
+		return F("https://t.me/c/%s/%d", strings.TrimPrefix(chatid, "-100"), messageid)
+	}
+	return ""
+}
+
+// replyPosted replies to the message with the video posted to the chat before as the media in the language, if there was one,
+// both media need both the video and the audio posted before and the reply links the video
+func replyPosted(m tg.Message, ytreq YtRequest, media string, language string) (replied bool, err error) {
+	if ytreq.Force || ytreq.VideoId == "" || ytreq.ListId != "" || ytreq.ClipStart != 0 || ytreq.ClipEnd != 0 {
+		return false, nil
+	}
+	mm := []string{media}
+	if media == ChatMediaBoth {
+		mm = []string{ChatMediaVideo, ChatMediaAudio}
+	}
+	var e TgPostedEntry
+	for i, media := range mm {
+		e1, ok := TgPostedGet(TgPostedEntry{ChatId: m.Chat.Id, VideoId: ytreq.VideoId, Media: media, Language: language, Chapters: ytreq.Chapters})
+		if !ok {
+			return false, nil
+		}
+		if i == 0 {
+			e = e1
+		}
+	}
+
+	LogCache.Debug("video posted before", "chat_id", e.ChatId, "video_id", e.VideoId, "message_id", e.MessageId)
+
+	posted := F("already posted %s", e.Time.UTC().Format("2006-01-02 15:04"))
+	text := tg.Esc(posted)
+	if link := tgMessageLink(m.Chat, e.MessageId); link != "" {
+		text = tg.Link(posted, link)
+	}
+	text += NL + tg.Esc("add force after the link to post it again")
+
+	if _, tgerr := tg.SendMessage(tg.SendMessageRequest{
+		ChatId:           fmt.Sprintf("%d", m.Chat.Id),
+		ReplyToMessageId: m.MessageId,
+		Text:             text,
+	}); tgerr != nil {
+		return true, fmt.Errorf("tg.SendMessage %w", tgerr)
+	}
+
+	return true, nil
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shoce/tg"
+)
+
+const (
+	TgProgressIntervalDefault = 10 * time.Second
+
+	ProgressStageDownloading = "downloading"
+	ProgressStageTranscoding = "transcoding"
+	ProgressStageUploading   = "uploading"
+)
+
+var (
+	// FfmpegDurationRe matches the input duration in the ffmpeg log
+	FfmpegDurationRe = regexp.MustCompile(`Duration: (\d+):(\d\d):(\d\d(?:\.\d+)?)`)
+)
+
+// Progress is the status message of a job replying to the job message, it is sent when the job runs
+// longer than TgProgressInterval and edited at most every TgProgressInterval, a nil Progress does nothing.
+// The readers only record the state under mu and the messages are sent by the ticker goroutine of the job.
+type Progress struct {
+	mu sync.Mutex
+
+	message tg.Message
+	videoid string
+
+	stage      string
+	stagestart time.Time
+	// done and total are the downloaded and the expected bytes, total is zero if unknown
+	done, total int64
+	// fraction is the transcoded part of the duration
+	fraction float64
+
+	// messageid and sent are used by the ticker goroutine and by Finish after it is stopped
+	messageid int64
+	sent      string
+
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+type progressContextKey struct{}
+
+// progressNew returns the progress of the job posting the video and starts its ticker,
+// jobs without a message have no progress
+func progressNew(ctx context.Context, m tg.Message, v YtVideo) *Progress {
+	if m.MessageId == 0 {
+		return nil
+	}
+	p := &Progress{message: m, videoid: v.Id, stop: make(chan struct{}), stopped: make(chan struct{})}
+	go p.ticker(ConfigFrom(ctx).TgProgressInterval)
+	return p
+}
+
+func ProgressContext(ctx context.Context, p *Progress) context.Context {
+	return context.WithValue(ctx, progressContextKey{}, p)
+}
+
+func ProgressFrom(ctx context.Context) *Progress {
+	p, _ := ctx.Value(progressContextKey{}).(*Progress)
+	return p
+}
+
+func (p *Progress) Text() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	text := F("youtu.be/%s %s", p.videoid, p.stage)
+	switch p.stage {
+	case ProgressStageDownloading:
+		if p.total > 0 {
+			text += F(" %d%% %d/%dmb", p.done*100/p.total, p.done>>20, p.total>>20)
+		} else {
+			text += F(" %dmb", p.done>>20)
+		}
+	case ProgressStageTranscoding:
+		if p.fraction > 0 {
+			elapsed := time.Since(p.stagestart)
+			eta := time.Duration(float64(elapsed) * (1 - p.fraction) / p.fraction)
+			text += F(" %d%% eta %v", int(p.fraction*100), eta.Truncate(time.Second))
+		}
+	}
+	return text
+}
+
+// Stage starts the stage of the job
+func (p *Progress) Stage(stage string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.stage, p.stagestart = stage, time.Now()
+	p.done, p.total, p.fraction = 0, 0, 0
+}
+
+// Reader starts the download stage and returns the reader counting the downloaded bytes of the total
+func (p *Progress) Reader(r io.Reader, total int64) io.Reader {
+	if p == nil {
+		return r
+	}
+	p.Stage(ProgressStageDownloading)
+	p.mu.Lock()
+	p.total = max(total, 0)
+	p.mu.Unlock()
+	return progressReader{r: r, p: p}
+}
+
+type progressReader struct {
+	r io.Reader
+	p *Progress
+}
+
+func (pr progressReader) Read(b []byte) (n int, err error) {
+	n, err = pr.r.Read(b)
+	pr.p.mu.Lock()
+	pr.p.done += int64(n)
+	pr.p.mu.Unlock()
+	return n, err
+}
+
+// Ffmpeg reads the ffmpeg -progress output updating the transcoded part of the duration
+func (p *Progress) Ffmpeg(r io.Reader, duration func() time.Duration) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		k, v, _ := strings.Cut(scanner.Text(), "=")
+		if k != "out_time_us" || p == nil {
+			continue
+		}
+		us, err := strconv.ParseInt(v, 10, 64)
+		d := duration()
+		if err != nil || us <= 0 || d <= 0 {
+			continue
+		}
+		p.mu.Lock()
+		p.fraction = min(float64(us)*float64(time.Microsecond)/float64(d), 1)
+		p.mu.Unlock()
+	}
+}
+
+// ticker sends or edits the status message every interval until Finish stops it
+func (p *Progress) ticker(interval time.Duration) {
+	defer close(p.stopped)
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			p.update()
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// update sends or edits the status message if its text changed
+func (p *Progress) update() {
+	p.mu.Lock()
+	started := p.stage != ""
+	p.mu.Unlock()
+	if !started {
+		return
+	}
+	text := p.Text()
+	if text == p.sent {
+		return
+	}
+
+	if p.messageid == 0 {
+		msg, tgerr := tg.SendMessage(tg.SendMessageRequest{
+			ChatId:           fmt.Sprintf("%d", p.message.Chat.Id),
+			ReplyToMessageId: p.message.MessageId,
+			Text:             tg.Esc(text),
+		})
+		if tgerr != nil {
+			LogJobs.Error("progress tg.SendMessage", "chat_id", p.message.Chat.Id, "video_id", p.videoid, "err", tgerr)
+			return
+		}
+		p.messageid = msg.MessageId
+		p.sent = text
+		return
+	}
+
+	if _, tgerr := tg.EditMessageText(tg.EditMessageTextRequest{
+		ChatId:    fmt.Sprintf("%d", p.message.Chat.Id),
+		MessageId: p.messageid,
+		Text:      tg.Esc(text),
+	}); tgerr != nil {
+		LogJobs.Error("progress tg.EditMessageText", "chat_id", p.message.Chat.Id, "video_id", p.videoid, "err", tgerr)
+		return
+	}
+	p.sent = text
+}
+
+// Finish stops the ticker and deletes the status message after the job or leaves it saying the job failed or was cancelled
+func (p *Progress) Finish(joberr error) {
+	if p == nil {
+		return
+	}
+	close(p.stop)
+	<-p.stopped
+
+	if p.messageid == 0 {
+		return
+	}
+
+	if joberr != nil {
+		p.mu.Lock()
+		state := p.stage + " failed"
+		p.mu.Unlock()
+		if errors.Is(joberr, context.Canceled) {
+			state = "cancelled"
+		}
+		if _, tgerr := tg.EditMessageText(tg.EditMessageTextRequest{
+			ChatId:    fmt.Sprintf("%d", p.message.Chat.Id),
+			MessageId: p.messageid,
+			Text:      tg.Esc(F("youtu.be/%s %s", p.videoid, state)),
+		}); tgerr != nil {
+			LogJobs.Error("progress tg.EditMessageText", "chat_id", p.message.Chat.Id, "video_id", p.videoid, "err", tgerr)
+		}
+		return
+	}
+
+	if err := tg.DeleteMessage(tg.DeleteMessageRequest{
+		ChatId:    fmt.Sprintf("%d", p.message.Chat.Id),
+		MessageId: p.messageid,
+	}); err != nil {
+		LogJobs.Error("progress tg.DeleteMessage", "chat_id", p.message.Chat.Id, "video_id", p.videoid, "err", err)
+	}
+}
+
+// ffmpegDuration returns the input duration from the ffmpeg log line
+func ffmpegDuration(line string) (d time.Duration, ok bool) {
+	mm := FfmpegDurationRe.FindStringSubmatch(line)
+	if mm == nil {
+		return 0, false
+	}
+	h, _ := strconv.ParseInt(mm[1], 10, 64)
+	m, _ := strconv.ParseInt(mm[2], 10, 64)
+	s, _ := strconv.ParseFloat(mm[3], 64)
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(s*float64(time.Second)), true
+}
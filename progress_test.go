@@ -0,0 +1,87 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shoce/tg"
+)
+
+func TestFfmpegDuration(t *testing.T) {
+	for line, want := range map[string]time.Duration{
+		"  Duration: 00:01:00.00, start: 0.000000, bitrate: 128 kb/s": time.Minute,
+		"  Duration: 01:02:03.50, start: 0.000000":                    time.Hour + 2*time.Minute + 3500*time.Millisecond,
+	} {
+		if d, ok := ffmpegDuration(line); !ok || d != want {
+			t.Errorf("ffmpegDuration [%s] <%v> <%v> expected <%v>", line, d, ok, want)
+		}
+	}
+	if _, ok := ffmpegDuration("Stream #0:0: Audio: aac"); ok {
+		t.Errorf("ffmpegDuration of a line without duration")
+	}
+}
+
+func TestProgress(t *testing.T) {
+	const chatid = 120
+	if p := progressNew(Ctx, tg.Message{Chat: tg.Chat{Id: chatid}}, YtVideo{Id: "progress0"}); p != nil {
+		t.Errorf("progress of a job without message")
+	}
+
+	c := *ConfigNow()
+	c.TgProgressInterval = time.Millisecond
+
+	p := progressNew(ConfigContext(Ctx, &c), tg.Message{MessageId: 1201, Chat: tg.Chat{Id: chatid}}, YtVideo{Id: "progress1"})
+	r := p.Reader(strings.NewReader(strings.Repeat("x", 3<<20)), 3<<20)
+	buf := make([]byte, 1<<20)
+	for {
+		time.Sleep(2 * time.Millisecond)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			break
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	cc := FakeTgServer.CallsFor("sendMessage", chatid)
+	if len(cc) != 1 || cc[0].Params["reply_to_message_id"] != "1201" || !strings.Contains(cc[0].Params["text"], "downloading") {
+		t.Fatalf("sendMessage calls %+v", cc)
+	}
+	if cc := FakeTgServer.CallsFor("editMessageText", chatid); len(cc) == 0 || !strings.Contains(cc[len(cc)-1].Params["text"], "100") {
+		t.Errorf("editMessageText calls %+v", cc)
+	}
+
+	p.Stage(ProgressStageTranscoding)
+	p.Ffmpeg(strings.NewReader("frame=10"+NL+"out_time_us=30000000"+NL+"progress=continue"+NL), func() time.Duration { return time.Minute })
+	if text := p.Text(); !strings.HasPrefix(text, "youtu.be/progress1 transcoding 50% eta") {
+		t.Errorf("Text [%s]", text)
+	}
+
+	p.Finish(nil)
+	if cc := FakeTgServer.CallsFor("deleteMessage", chatid); len(cc) != 1 {
+		t.Errorf("deleteMessage calls %+v", cc)
+	}
+
+	// reading does not send anything before the ticker runs
+	p = progressNew(Ctx, tg.Message{MessageId: 1202, Chat: tg.Chat{Id: chatid}}, YtVideo{Id: "progress2"})
+	if _, err := io.Copy(io.Discard, p.Reader(strings.NewReader(strings.Repeat("x", 3<<20)), 3<<20)); err != nil {
+		t.Fatal(err)
+	}
+	p.Finish(nil)
+	if cc := FakeTgServer.CallsFor("sendMessage", chatid); len(cc) != 1 {
+		t.Errorf("sendMessage calls %+v", cc)
+	}
+
+	// the failed status message is sent again by the next tick
+	const chatid2 = 131
+	FakeTgServer.mu.Lock()
+	FakeTgServer.Failures = append(FakeTgServer.Failures, FakeTgFailure{Method: "sendMessage", ChatId: "131", Status: http.StatusBadRequest, Body: `{"ok":false,"error_code":400,"description":"Bad Request"}`})
+	FakeTgServer.mu.Unlock()
+	p = progressNew(ConfigContext(Ctx, &c), tg.Message{MessageId: 1311, Chat: tg.Chat{Id: chatid2}}, YtVideo{Id: "progress3"})
+	p.Stage(ProgressStageTranscoding)
+	FakeTgServer.WaitFor(t, "sendMessage", chatid2, 2)
+	p.Finish(nil)
+	if cc := FakeTgServer.CallsFor("deleteMessage", chatid2); len(cc) != 1 {
+		t.Errorf("deleteMessage calls %+v", cc)
+	}
+}
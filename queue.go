@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shoce/tg"
+)
+
+const (
+	TgCommandQueueDefault  = "/queue"
+	TgCommandCancelDefault = "/cancel"
+
+	// TgQueueListMax is how many pending jobs /queue lists
+	TgQueueListMax = 20
+)
+
+func (j Job) Text() string {
+	if j.AudioCompress {
+		return F("#%d %s", j.Id, ConfigNow().TgCommandAudioCompress)
+	}
+	return F("#%d youtu.be/%s %s", j.Id, j.Video.Id, j.Media)
+}
+
+// JobsQueueText lists the running and the pending jobs of the chat with the time they are expected to start in
+func JobsQueueText(chatid int64) string {
+	running, queued, duration := Jobs.Queue(chatid)
+	if running == nil && len(queued) == 0 {
+		return "no jobs"
+	}
+
+	var tt []string
+	var wait time.Duration
+	if running != nil {
+		elapsed := time.Since(running.started)
+		tt = append(tt, F("running %s for %v", running.Text(), elapsed.Truncate(time.Second)))
+		wait = max(duration-elapsed, 0)
+	}
+	for i, j := range queued {
+		if i == TgQueueListMax {
+			tt = append(tt, F("and <%d> more", len(queued)-i))
+			break
+		}
+		eta := "unknown"
+		if duration > 0 {
+			eta = F("~%v", (wait + time.Duration(i)*duration).Truncate(time.Second))
+		}
+		tt = append(tt, F("%d. %s starts in %s", i+1, j.Text(), eta))
+	}
+
+	return F("<%d> jobs pending", len(queued)) + NL + strings.Join(tt, NL)
+}
+
+func processTgQueueCommand(m tg.Message) error {
+	return tgReply(m, JobsQueueText(m.Chat.Id))
+}
+
+// processTgCancelCommand cancels the jobs of the replied message or the job of the id,
+// cancelling a job of a playlist drops the rest of the playlist
+func processTgCancelCommand(m tg.Message, mtff []string) error {
+	running, queued, _ := Jobs.Queue(m.Chat.Id)
+	jj := queued
+	if running != nil {
+		jj = append([]Job{*running}, queued...)
+	}
+
+	var targets []Job
+	switch {
+	case len(mtff) == 2:
+		jobid, err := strconv.ParseInt(strings.TrimPrefix(mtff[1], "#"), 10, 64)
+		if err != nil {
+			return tgReply(m, F("not a job id [%s]", mtff[1]))
+		}
+		for _, j := range jj {
+			if j.Id == jobid {
+				targets = append(targets, j)
+			}
+		}
+	case m.ReplyToMessage != nil:
+		for _, j := range jj {
+			if j.Message.MessageId == m.ReplyToMessage.MessageId {
+				targets = append(targets, j)
+			}
+		}
+	default:
+		return tgReply(m, F("reply %s to the message with the link or add the job id from %s", ConfigNow().TgCommandCancel, ConfigNow().TgCommandQueue))
+	}
+	if len(targets) == 0 {
+		return tgReply(m, "no jobs to cancel")
+	}
+
+	// others' jobs are cancelled by chat admins only
+	if slices.ContainsFunc(targets, func(j Job) bool { return j.Message.From.Id != m.From.Id }) {
+		if isadmin, err := tgCheckChatAdmin(m, "jobs of others"); err != nil || !isadmin {
+			return err
+		}
+	}
+
+	var listupdateids []int64
+	for _, j := range targets {
+		if j.List != nil {
+			listupdateids = append(listupdateids, j.UpdateId)
+		}
+	}
+	cancelled := Jobs.Cancel(m.Chat.Id, func(j *Job) bool {
+		return slices.ContainsFunc(targets, func(j2 Job) bool { return j2.Id == j.Id }) || (j.List != nil && slices.Contains(listupdateids, j.UpdateId))
+	})
+	for _, updateid := range listupdateids {
+		if err := YtListJobDelete(updateid, m.Chat.Id); err != nil {
+			return fmt.Errorf("YtListJobDelete %w", err)
+		}
+	}
+	LogJobs.Info("jobs cancelled", "chat_id", m.Chat.Id, "jobs", len(cancelled))
+
+	if len(cancelled) == 0 {
+		return tgReply(m, "no jobs to cancel")
+	}
+	var tt []string
+	for i, j := range cancelled {
+		if i == TgQueueListMax {
+			tt = append(tt, F("and <%d> more", len(cancelled)-i))
+			break
+		}
+		tt = append(tt, j.Text())
+	}
+	return tgReply(m, F("<%d> jobs cancelled", len(cancelled))+NL+strings.Join(tt, NL))
+}
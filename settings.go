@@ -0,0 +1,307 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/shoce/tg"
+)
+
+const (
+	ChatMediaAudio = "audio"
+	ChatMediaVideo = "video"
+	ChatMediaBoth  = "both"
+
+	TgCommandSettingsDefault = "/settings"
+
+	// TgSettingsCallbackPrefix starts the callback data of the settings keyboard buttons
+	TgSettingsCallbackPrefix = "settings"
+)
+
+var (
+	ChatSettingsMaxHeights    = []int{360, 480, 720, 1080}
+	ChatSettingsAudioMaxKbpss = []int64{64, 128, 160}
+)
+
+// ChatSettings are the persisted settings of a chat, zero values mean the defaults,
+// MaxHeight and AudioMaxKbps pick youtube formats so they do not apply to downloads through DssUrl
+type ChatSettings struct {
+	ChatId int64 `yaml:"ChatId"`
+
+	// MediaMode is ChatMediaAudio, ChatMediaVideo or ChatMediaBoth, empty means deciding by the chat title
+	MediaMode string `yaml:"MediaMode"`
+	// MaxHeight is the max video resolution height, zero means no limit
+	MaxHeight int `yaml:"MaxHeight"`
+	// AudioMaxKbps is the max audio bitrate, zero means no limit
+	AudioMaxKbps int64 `yaml:"AudioMaxKbps"`
+	// Language is one of YtDownloadLanguages tried first, empty means the configured order,
+	// videos downloaded by DssUrl get the track of dss whatever the language
+	Language string `yaml:"Language"`
+}
+
+func ChatSettingsGet(chatid int64) ChatSettings {
+	ConfigMu.Lock()
+	defer ConfigMu.Unlock()
+
+	if i := slices.IndexFunc(Config.ChatSettings, func(cs ChatSettings) bool { return cs.ChatId == chatid }); i >= 0 {
+		return Config.ChatSettings[i]
+	}
+	return ChatSettings{ChatId: chatid}
+}
+
+// ChatSettingsPut saves the chat settings, settings with all defaults are removed
+func ChatSettingsPut(cs ChatSettings) error {
+	ConfigMu.Lock()
+	defer ConfigMu.Unlock()
+
+	Config.ChatSettings = slices.DeleteFunc(Config.ChatSettings, func(cs2 ChatSettings) bool { return cs2.ChatId == cs.ChatId })
+	if cs != (ChatSettings{ChatId: cs.ChatId}) {
+		Config.ChatSettings = append(Config.ChatSettings, cs)
+	}
+
+	return Config.Put()
+}
+
+// Media returns the media mode of the chat, chats without the setting get video if the title starts with v
+func (cs ChatSettings) Media(chat tg.Chat) string {
+	if cs.MediaMode != "" {
+		return cs.MediaMode
+	}
+	if strings.HasPrefix(strings.ToLower(chat.Title), "v") {
+		return ChatMediaVideo
+	}
+	return ChatMediaAudio
+}
+
+func (cs ChatSettings) PostOptions() PostOptions {
+	return PostOptions{
+		MaxHeight:    cs.MaxHeight,
+		AudioMaxKbps: cs.AudioMaxKbps,
+		Language:     cs.Language,
+	}
+}
+
+func (cs ChatSettings) Text(chat tg.Chat) string {
+	media := cs.Media(chat)
+	if cs.MediaMode == "" {
+		media += " (by chat title)"
+	}
+	maxheight, audiomax, language := "any", "any", "default"
+	if cs.MaxHeight > 0 {
+		maxheight = F("%dp", cs.MaxHeight)
+	}
+	if cs.AudioMaxKbps > 0 {
+		audiomax = F("%dkbps", cs.AudioMaxKbps)
+	}
+	if cs.Language != "" {
+		language = cs.Language
+	}
+	return tg.Bold(tg.Esc(F("settings of chat id %d %s", chat.Id, chat.Title))) + NL +
+		tg.Esc(F("media: %s", media)) + NL +
+		tg.Esc(F("max resolution: %s", maxheight)) + NL +
+		tg.Esc(F("max audio bitrate: %s", audiomax)) + NL +
+		tg.Esc(F("language: %s", language))
+}
+
+func (cs ChatSettings) Keyboard() TgInlineKeyboardMarkup {
+	button := func(text, key, value string, selected bool) TgInlineKeyboardButton {
+		if selected {
+			text = "✓ " + text
+		}
+		return TgInlineKeyboardButton{
+			Text:         text,
+			CallbackData: TgSettingsCallbackPrefix + SP + key + SP + value,
+		}
+	}
+
+	var media, maxheight, audiomax []TgInlineKeyboardButton
+	for _, mm := range []string{"", ChatMediaAudio, ChatMediaVideo, ChatMediaBoth} {
+		text := mm
+		if mm == "" {
+			text = "by title"
+		}
+		media = append(media, button(text, "media", mm, cs.MediaMode == mm))
+	}
+	for _, h := range append([]int{0}, ChatSettingsMaxHeights...) {
+		text := F("%dp", h)
+		if h == 0 {
+			text = "any"
+		}
+		maxheight = append(maxheight, button(text, "maxheight", F("%d", h), cs.MaxHeight == h))
+	}
+	for _, kbps := range append([]int64{0}, ChatSettingsAudioMaxKbpss...) {
+		text := F("%dk", kbps)
+		if kbps == 0 {
+			text = "any"
+		}
+		audiomax = append(audiomax, button(text, "audiomax", F("%d", kbps), cs.AudioMaxKbps == kbps))
+	}
+
+	keyboard := [][]TgInlineKeyboardButton{media, maxheight, audiomax}
+	if len(ConfigNow().YtDownloadLanguages) > 0 && ConfigNow().DssUrl == "" {
+		language := []TgInlineKeyboardButton{button("default", "language", "", cs.Language == "")}
+		for _, lang := range ConfigNow().YtDownloadLanguages {
+			language = append(language, button(lang, "language", lang, cs.Language == lang))
+		}
+		keyboard = append(keyboard, language)
+	}
+
+	return TgInlineKeyboardMarkup{
+		InlineKeyboard: keyboard,
+	}
+}
+
+// Set changes the setting by the key and value from the callback data
+func (cs *ChatSettings) Set(key, value string) error {
+	switch key {
+	case "media":
+		if value != "" && value != ChatMediaAudio && value != ChatMediaVideo && value != ChatMediaBoth {
+			return fmt.Errorf("invalid media [%s]", value)
+		}
+		cs.MediaMode = value
+	case "maxheight":
+		h, err := strconv.Atoi(value)
+		if err != nil || (h != 0 && !slices.Contains(ChatSettingsMaxHeights, h)) {
+			return fmt.Errorf("invalid max height [%s]", value)
+		}
+		cs.MaxHeight = h
+	case "audiomax":
+		kbps, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || (kbps != 0 && !slices.Contains(ChatSettingsAudioMaxKbpss, kbps)) {
+			return fmt.Errorf("invalid audio max [%s]", value)
+		}
+		cs.AudioMaxKbps = kbps
+	case "language":
+		if value != "" && !slices.Contains(ConfigNow().YtDownloadLanguages, value) {
+			return fmt.Errorf("invalid language [%s]", value)
+		}
+		cs.Language = value
+	default:
+		return fmt.Errorf("invalid setting [%s]", key)
+	}
+	return nil
+}
+
+// tgIsChatAdmin reports if the user can change the chat settings
+func tgIsChatAdmin(chat tg.Chat, userid int64) (bool, error) {
+	if chat.Type == "private" {
+		return chat.Id == userid, nil
+	}
+	if userid == ConfigNow().TgZeChatId {
+		return true, nil
+	}
+	aa, err := tg.GetChatAdministrators(chat.Id)
+	if err != nil {
+		return false, fmt.Errorf("tg.GetChatAdministrators %w", err)
+	}
+	for _, a := range aa {
+		if a.User.Id == userid {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// tgReply replies to the message with the plain text
+func tgReply(m tg.Message, text string) error {
+	if _, tgerr := tg.SendMessage(tg.SendMessageRequest{
+		ChatId:           fmt.Sprintf("%d", m.Chat.Id),
+		ReplyToMessageId: m.MessageId,
+		Text:             tg.Esc(text),
+	}); tgerr != nil {
+		return fmt.Errorf("tg.SendMessage %w", tgerr)
+	}
+	return nil
+}
+
+// tgCheckChatAdmin reports if the author of the command message is a chat admin, others get a reply they cannot change the chat what
+func tgCheckChatAdmin(m tg.Message, what string) (bool, error) {
+	// channel posts come from the channel itself and only admins can post there
+	if m.Chat.Type == "channel" {
+		return true, nil
+	}
+	if isadmin, err := tgIsChatAdmin(m.Chat, m.From.Id); err != nil {
+		return false, err
+	} else if !isadmin {
+		return false, tgReply(m, "only chat admins can change "+what)
+	}
+	return true, nil
+}
+
+func processTgSettingsCommand(m tg.Message) error {
+	if isadmin, err := tgCheckChatAdmin(m, "settings"); err != nil || !isadmin {
+		return err
+	}
+
+	cs := ChatSettingsGet(m.Chat.Id)
+	if _, tgerr := TgSendMessageMarkup(TgSendMessageMarkupRequest{
+		ChatId:           fmt.Sprintf("%d", m.Chat.Id),
+		ReplyToMessageId: m.MessageId,
+		Text:             cs.Text(m.Chat),
+		ReplyMarkup:      cs.Keyboard(),
+	}); tgerr != nil {
+		return fmt.Errorf("TgSendMessageMarkup %w", tgerr)
+	}
+
+	return nil
+}
+
+func processTgCallbackQuery(cq TgCallbackQuery) error {
+	LogTg.Info("callback query", "from", cq.From.Username, "user_id", cq.From.Id, "chat_id", cq.Message.Chat.Id, "data", cq.Data)
+
+	answer := ""
+	defer func() {
+		if tgerr := TgAnswerCallbackQuery(TgAnswerCallbackQueryRequest{
+			CallbackQueryId: cq.Id,
+			Text:            answer,
+		}); tgerr != nil {
+			LogTg.Error("TgAnswerCallbackQuery", "chat_id", cq.Message.Chat.Id, "err", tgerr)
+		}
+	}()
+
+	dd := strings.Split(cq.Data, SP)
+	if len(dd) == 3 && dd[0] == TgChannelsCallbackPrefix {
+		var err error
+		answer, err = processTgChannelsCallback(cq, dd)
+		return err
+	}
+	if len(dd) != 3 || dd[0] != TgSettingsCallbackPrefix {
+		answer = "unknown button"
+		return fmt.Errorf("unsupported callback data [%s]", cq.Data)
+	}
+
+	chat := cq.Message.Chat
+	if isadmin, err := tgIsChatAdmin(chat, cq.From.Id); err != nil {
+		return err
+	} else if !isadmin {
+		answer = "only chat admins can change settings"
+		return nil
+	}
+
+	cs := ChatSettingsGet(chat.Id)
+	cs0 := cs
+	if err := cs.Set(dd[1], dd[2]); err != nil {
+		answer = err.Error()
+		return err
+	}
+	if cs == cs0 {
+		return nil
+	}
+	if err := ChatSettingsPut(cs); err != nil {
+		return fmt.Errorf("ChatSettingsPut %w", err)
+	}
+	answer = "saved"
+
+	if tgerr := TgEditMessageTextMarkup(TgEditMessageTextMarkupRequest{
+		ChatId:      fmt.Sprintf("%d", chat.Id),
+		MessageId:   cq.Message.MessageId,
+		Text:        cs.Text(chat),
+		ReplyMarkup: cs.Keyboard(),
+	}); tgerr != nil {
+		return fmt.Errorf("TgEditMessageTextMarkup %w", tgerr)
+	}
+
+	return nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/shoce/tg"
+)
+
+const (
+	ShutdownGraceDefault = 2 * time.Minute
+
+	// JobsStopCancelWait is how long the jobs cancelled after the shutdown grace period have to return
+	JobsStopCancelWait = 10 * time.Second
+)
+
+var (
+	// Ctx is the root context, it is cancelled when the shutdown grace period is over
+	Ctx, CtxCancel = context.WithCancel(context.Background())
+	// StopCtx is cancelled on SIGTERM or SIGINT to stop taking new updates
+	StopCtx, StopCtxCancel = context.WithCancel(Ctx)
+)
+
+// JobUnfinished is a job not finished before the shutdown, it is put again on the next start
+type JobUnfinished struct {
+	UpdateId      int64  `yaml:"UpdateId"`
+	ChatId        int64  `yaml:"ChatId"`
+	ChatTitle     string `yaml:"ChatTitle"`
+	MessageId     int64  `yaml:"MessageId"`
+	VideoId       string `yaml:"VideoId"`
+	Media         string `yaml:"Media"`
+	DeleteMessage bool   `yaml:"DeleteMessage"`
+
+	ClipStart time.Duration `yaml:"ClipStart"`
+	ClipEnd   time.Duration `yaml:"ClipEnd"`
+	Chapters  bool          `yaml:"Chapters"`
+	Language  string        `yaml:"Language"`
+}
+
+// ShutdownNotify cancels StopCtx on the first SIGTERM or SIGINT and exits on the second one
+func ShutdownNotify() {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		sig := <-signals
+		Log.Info("stopping", "signal", sig.String(), "grace", ConfigNow().ShutdownGrace)
+		if _, tgerr := tg.SendMessage(tg.SendMessageRequest{
+			ChatId: fmt.Sprintf("%d", ConfigNow().TgZeChatId),
+			Text: tg.Esc(F(
+				"%s %s, stopping", os.Args[0], sig,
+			)),
+		}); tgerr != nil {
+			Log.Error("tg.SendMessage", "err", tgerr)
+		}
+		StopCtxCancel()
+
+		sig = <-signals
+		Log.Warn("exiting", "signal", sig.String())
+		os.Exit(1)
+	}()
+}
+
+// Shutdown stops the webhook server, lets the running jobs finish within ShutdownGrace and records the unfinished jobs
+func Shutdown() {
+	if err := TgWebhookStop(); err != nil {
+		Log.Error("TgWebhookStop", "err", err)
+	}
+
+	unfinished := Jobs.Stop(ConfigNow().ShutdownGrace, CtxCancel)
+	CtxCancel()
+
+	if err := JobsUnfinishedPut(unfinished); err != nil {
+		Log.Error("JobsUnfinishedPut", "err", err)
+	}
+	Log.Info("stopped", "unfinished_jobs", len(unfinished))
+}
+
+// JobsUnfinishedPut records the jobs to put again on the next start, list jobs are resumed from YtListJobs
+// and audio compress jobs are not recorded
+func JobsUnfinishedPut(jj []*Job) error {
+	ConfigMu.Lock()
+	defer ConfigMu.Unlock()
+
+	for _, j := range jj {
+		if j.List != nil || j.AudioCompress {
+			continue
+		}
+		Config.JobsUnfinished = append(Config.JobsUnfinished, JobUnfinished{
+			UpdateId:      j.UpdateId,
+			ChatId:        j.Message.Chat.Id,
+			ChatTitle:     j.Message.Chat.Title,
+			MessageId:     j.Message.MessageId,
+			VideoId:       j.Video.Id,
+			Media:         j.Media,
+			DeleteMessage: j.DeleteMessage,
+			ClipStart:     j.Options.ClipStart,
+			ClipEnd:       j.Options.ClipEnd,
+			Chapters:      j.Options.Chapters,
+			Language:      j.Options.Language,
+		})
+	}
+
+	return Config.Put()
+}
+
+// JobsUnfinishedResume puts the jobs recorded on the last shutdown
+func JobsUnfinishedResume() error {
+	ConfigMu.Lock()
+	jj := Config.JobsUnfinished
+	Config.JobsUnfinished = nil
+	err := Config.Put()
+	ConfigMu.Unlock()
+	if err != nil {
+		return fmt.Errorf("Config.Put %w", err)
+	}
+
+	for _, ju := range jj {
+		LogJobs.Info("resuming unfinished job", "update_id", ju.UpdateId, "chat_id", ju.ChatId, "video_id", ju.VideoId)
+
+		opts := ChatSettingsGet(ju.ChatId).PostOptions()
+		opts.ClipStart, opts.ClipEnd, opts.Chapters = ju.ClipStart, ju.ClipEnd, ju.Chapters
+		if ju.Language != "" {
+			opts.Language = ju.Language
+		}
+		Jobs.Put(&Job{
+			UpdateId: ju.UpdateId,
+			Message: tg.Message{
+				MessageId: ju.MessageId,
+				Chat:      tg.Chat{Id: ju.ChatId, Title: ju.ChatTitle},
+			},
+			Video:         YtVideo{Id: ju.VideoId},
+			Media:         ju.Media,
+			DeleteMessage: ju.DeleteMessage,
+			Options:       opts,
+		})
+	}
+
+	return nil
+}
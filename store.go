@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	yaml "github.com/goccy/go-yaml"
+)
+
+// ConfigStore keeps the serialized config
+type ConfigStore interface {
+	Get() ([]byte, error)
+	Put([]byte) error
+	// Sub returns the store of the named state kept apart from the config
+	Sub(name string) ConfigStore
+}
+
+const (
+	// YssTimeout limits the requests to yss so a hung request does not block the holders of ConfigMu
+	YssTimeout = 30 * time.Second
+)
+
+var (
+	ConfigStoreCl ConfigStore
+
+	YssHttpClient = &http.Client{Timeout: YssTimeout}
+)
+
+// ConfigStoreInit picks the config store from the environment: YssUrl or ConfigFile
+func ConfigStoreInit() error {
+	if v := os.Getenv("YssUrl"); v != "" {
+		Log.Info("config store", "YssUrl", v)
+		ConfigStoreCl = &YssStore{Url: v}
+		return nil
+	}
+
+	if v := os.Getenv("ConfigFile"); v != "" {
+		Log.Info("config store", "ConfigFile", v)
+		ConfigStoreCl = &FileStore{Path: v}
+		return nil
+	}
+
+	return fmt.Errorf("both YssUrl and ConfigFile empty")
+}
+
+// YssStore keeps the config in yss with http GET and PUT
+type YssStore struct {
+	Url string
+}
+
+func (s *YssStore) Get() ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, s.Url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := YssHttpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("yss response status %s", resp.Status)
+	}
+
+	return io.ReadAll(resp.Body)
+}
+
+// Sub returns the yss key of the config key with the name suffix
+func (s *YssStore) Sub(name string) ConfigStore {
+	return &YssStore{Url: s.Url + "." + name}
+}
+
+func (s *YssStore) Put(data []byte) error {
+	req, err := http.NewRequest(http.MethodPut, s.Url, bytes.NewBuffer(data))
+	if err != nil {
+		return err
+	}
+
+	resp, err := YssHttpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("yss response status %s", resp.Status)
+	}
+
+	return nil
+}
+
+// FileStore keeps the config in a local yaml file
+type FileStore struct {
+	Path string
+}
+
+func (s *FileStore) Get() ([]byte, error) {
+	return os.ReadFile(s.Path)
+}
+
+// Sub returns the file next to the config file with the name suffix
+func (s *FileStore) Sub(name string) ConfigStore {
+	return &FileStore{Path: s.Path + "." + name}
+}
+
+// Put writes to a temporary file in the same directory and renames it over the config file,
+// the directory is synced so the rename is not lost on a crash
+func (s *FileStore) Put(data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(s.Path), filepath.Base(s.Path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("os.CreateTemp %w", err)
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("os.File.Write %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("os.File.Sync %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("os.File.Close %w", err)
+	}
+
+	if err := os.Rename(f.Name(), s.Path); err != nil {
+		return fmt.Errorf("os.Rename %w", err)
+	}
+
+	dir, err := os.Open(filepath.Dir(s.Path))
+	if err != nil {
+		return fmt.Errorf("os.Open %w", err)
+	}
+	defer dir.Close()
+	if err := dir.Sync(); err != nil {
+		return fmt.Errorf("os.File.Sync %w", err)
+	}
+
+	return nil
+}
+
+// MemStore keeps the config in memory
+type MemStore struct {
+	mu   sync.Mutex
+	Data []byte
+
+	subs map[string]*MemStore
+}
+
+func (s *MemStore) Get() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return bytes.Clone(s.Data), nil
+}
+
+func (s *MemStore) Put(data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Data = bytes.Clone(data)
+	return nil
+}
+
+func (s *MemStore) Sub(name string) ConfigStore {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.subs == nil {
+		s.subs = make(map[string]*MemStore)
+	}
+	if s.subs[name] == nil {
+		s.subs[name] = &MemStore{}
+	}
+	return s.subs[name]
+}
+
+// StateGet reads the state from the store, a missing state is empty
+func StateGet(s ConfigStore, state any) error {
+	rbb, err := s.Get()
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	return yaml.Unmarshal(rbb, state)
+}
+
+// StatePut writes the state to the store
+func StatePut(s ConfigStore, state any) error {
+	rbb, err := yaml.MarshalWithOptions(state, yaml.JSON(), yaml.Flow(false))
+	if err != nil {
+		return err
+	}
+
+	return s.Put(rbb)
+}
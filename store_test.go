@@ -0,0 +1,97 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func testConfigStore(t *testing.T, s ConfigStore) {
+	t.Helper()
+	for _, data := range []string{"A: 1\n", "A: 2\nB: [1, 2]\n"} {
+		if err := s.Put([]byte(data)); err != nil {
+			t.Fatalf("Put %v", err)
+		}
+		bb, err := s.Get()
+		if err != nil {
+			t.Fatalf("Get %v", err)
+		}
+		if string(bb) != data {
+			t.Errorf("Get [%s] expected [%s]", bb, data)
+		}
+	}
+}
+
+func TestMemStore(t *testing.T) {
+	testConfigStore(t, &MemStore{})
+}
+
+func TestFileStore(t *testing.T) {
+	dir := t.TempDir()
+	s := &FileStore{Path: filepath.Join(dir, "tgze.yaml")}
+
+	if _, err := s.Get(); err == nil {
+		t.Errorf("Get of missing file expected error")
+	}
+
+	testConfigStore(t, s)
+
+	ee, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ee) != 1 {
+		t.Errorf("dir entries %v expected only the config file", ee)
+	}
+}
+
+func TestYssStore(t *testing.T) {
+	var mu sync.Mutex
+	var data []byte
+	yss := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method {
+		case http.MethodGet:
+			w.Write(data)
+		case http.MethodPut:
+			data, _ = io.ReadAll(r.Body)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+	defer yss.Close()
+
+	testConfigStore(t, &YssStore{Url: yss.URL})
+
+	if _, err := (&YssStore{Url: yss.URL + "/missing"}).Get(); err != nil {
+		t.Errorf("Get %v", err)
+	}
+}
+
+func TestStateSub(t *testing.T) {
+	dir := t.TempDir()
+	s := (&FileStore{Path: filepath.Join(dir, "tgze.yaml")}).Sub("filecache")
+
+	var state struct {
+		A []int `yaml:"A"`
+	}
+	if err := StateGet(s, &state); err != nil || state.A != nil {
+		t.Fatalf("StateGet of missing file %v %+v", err, state)
+	}
+	state.A = []int{1, 2}
+	if err := StatePut(s, state); err != nil {
+		t.Fatalf("StatePut %v", err)
+	}
+	state.A = nil
+	if err := StateGet(s, &state); err != nil || len(state.A) != 2 {
+		t.Errorf("StateGet %v %+v", err, state)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "tgze.yaml.filecache")); err != nil {
+		t.Errorf("state file %v", err)
+	}
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/shoce/tg"
+)
+
+const (
+	TgCommandSubscribeDefault   = "/subscribe"
+	TgCommandUnsubscribeDefault = "/unsubscribe"
+
+	YtSubscriptionsIntervalDefault = 15 * time.Minute
+
+	// YtUploadsMaxPages limits the pages of the uploads list read back to the last-seen marker
+	YtUploadsMaxPages = 10
+)
+
+var (
+	YtChannelIdRe     = regexp.MustCompile(`^(?:(?:https?://)?(?:www\.|m\.)?youtube\.com/channel/)?(UC[0-9A-Za-z_-]{22})(?:[/?].*)?$`)
+	YtChannelHandleRe = regexp.MustCompile(`^(?:(?:https?://)?(?:www\.|m\.)?youtube\.com/)?(@[0-9A-Za-z_.-]+)(?:[/?].*)?$`)
+)
+
+// YtSubscription is a youtube channel which new uploads are posted to the chat
+type YtSubscription struct {
+	ChatId    int64  `yaml:"ChatId"`
+	ChatTitle string `yaml:"ChatTitle"`
+
+	ChannelId     string `yaml:"ChannelId"`
+	ChannelHandle string `yaml:"ChannelHandle"`
+	ChannelTitle  string `yaml:"ChannelTitle"`
+	UploadsListId string `yaml:"UploadsListId"`
+
+	// LastPublishedAt and LastVideoId mark the newest upload already posted
+	LastPublishedAt time.Time `yaml:"LastPublishedAt"`
+	LastVideoId     string    `yaml:"LastVideoId"`
+}
+
+// parseYtChannel returns the channel id or the handle from a channel url, a handle or a channel id
+func parseYtChannel(s string) (channelid, handle string, ok bool) {
+	if mm := YtChannelIdRe.FindStringSubmatch(s); mm != nil {
+		return mm[1], "", true
+	}
+	if mm := YtChannelHandleRe.FindStringSubmatch(s); mm != nil {
+		return "", strings.ToLower(mm[1]), true
+	}
+	return "", "", false
+}
+
+func getYtChannel(ctx context.Context, channelid, handle string) (c YtChannel, err error) {
+	ctx, cancel := context.WithTimeout(ctx, ConfigFrom(ctx).MetadataTimeout)
+	defer cancel()
+
+	// https://developers.google.com/youtube/v3/docs/channels/list
+	filter := F("id=%s", channelid)
+	if channelid == "" {
+		filter = F("forHandle=%s", handle)
+	}
+	var ChannelsUrl = fmt.Sprintf("%s/channels?part=snippet,contentDetails&%s&key=%s", ConfigFrom(ctx).YtApiUrl, filter, ConfigFrom(ctx).YtKey)
+
+	var channels YtChannelListResponse
+	if err := getJson(ctx, ChannelsUrl, &channels, nil); err != nil {
+		return c, err
+	}
+	if len(channels.Items) == 0 {
+		return c, fmt.Errorf("no youtube channel %s", filter)
+	}
+	c = channels.Items[0]
+	if c.ContentDetails.RelatedPlaylists.Uploads == "" {
+		return c, fmt.Errorf("no uploads list of youtube channel id [%s]", c.Id)
+	}
+	return c, nil
+}
+
+// getYtUploads returns the uploads list sorted from the oldest to the newest upload, the pages are read
+// from the newest upload back to the first one published not after the time or up to YtUploadsMaxPages,
+// only the first page is read for the zero time
+func getYtUploads(ctx context.Context, uploadslistid string, after time.Time) (uploads []YtPlaylistItemSnippet, err error) {
+	ctx, cancel := context.WithTimeout(ctx, ConfigFrom(ctx).MetadataTimeout)
+	defer cancel()
+
+	nextPageToken := ""
+	for page := 1; ; page++ {
+		var PlaylistItemsUrl = fmt.Sprintf("%s/playlistItems?maxResults=%d&part=snippet&playlistId=%s&key=%s&pageToken=%s", ConfigFrom(ctx).YtApiUrl, ConfigFrom(ctx).YtMaxResults, uploadslistid, ConfigFrom(ctx).YtKey, nextPageToken)
+
+		var playlistItems YtPlaylistItems
+		if err := getJson(ctx, PlaylistItemsUrl, &playlistItems, nil); err != nil {
+			return nil, err
+		}
+
+		var reached bool
+		for _, i := range playlistItems.Items {
+			if i.Snippet.ResourceId.VideoId == "" {
+				continue
+			}
+			published, err := time.Parse(time.RFC3339, i.Snippet.PublishedAt)
+			if err != nil {
+				LogSubscriptions.Warn("upload publishedAt", "list_id", uploadslistid, "video_id", i.Snippet.ResourceId.VideoId, "published_at", i.Snippet.PublishedAt, "err", err)
+				continue
+			}
+			if !published.After(after) {
+				reached = true
+			}
+			uploads = append(uploads, i.Snippet)
+		}
+
+		if reached || after.IsZero() || playlistItems.NextPageToken == "" {
+			break
+		}
+		if page >= YtUploadsMaxPages {
+			LogSubscriptions.Warn("uploads pages limit reached", "list_id", uploadslistid, "pages", page, "after", after)
+			break
+		}
+		nextPageToken = playlistItems.NextPageToken
+	}
+
+	slices.SortStableFunc(uploads, func(a, b YtPlaylistItemSnippet) int {
+		ta, _ := time.Parse(time.RFC3339, a.PublishedAt)
+		tb, _ := time.Parse(time.RFC3339, b.PublishedAt)
+		return ta.Compare(tb)
+	})
+
+	return uploads, nil
+}
+
+// ytSubscriptionNew returns the uploads newer than the last-seen marker of the subscription
+func ytSubscriptionNew(s YtSubscription, uploads []YtPlaylistItemSnippet) (newuploads []YtPlaylistItemSnippet) {
+	for _, u := range uploads {
+		published, _ := time.Parse(time.RFC3339, u.PublishedAt)
+		if !published.After(s.LastPublishedAt) || u.ResourceId.VideoId == s.LastVideoId {
+			continue
+		}
+		newuploads = append(newuploads, u)
+	}
+	return newuploads
+}
+
+func YtSubscriptionsGet(chatid int64) (ss []YtSubscription) {
+	ConfigMu.Lock()
+	defer ConfigMu.Unlock()
+
+	for _, s := range Config.YtSubscriptions {
+		if chatid == 0 || s.ChatId == chatid {
+			ss = append(ss, s)
+		}
+	}
+	return ss
+}
+
+// YtSubscriptionPut saves the subscription replacing the one of the same chat and channel
+func YtSubscriptionPut(s YtSubscription) error {
+	ConfigMu.Lock()
+	defer ConfigMu.Unlock()
+
+	if i := slices.IndexFunc(Config.YtSubscriptions, func(s2 YtSubscription) bool {
+		return s2.ChatId == s.ChatId && s2.ChannelId == s.ChannelId
+	}); i >= 0 {
+		Config.YtSubscriptions[i] = s
+	} else {
+		Config.YtSubscriptions = append(Config.YtSubscriptions, s)
+	}
+
+	return Config.Put()
+}
+
+func YtSubscriptionDelete(chatid int64, channelid string) error {
+	ConfigMu.Lock()
+	defer ConfigMu.Unlock()
+
+	Config.YtSubscriptions = slices.DeleteFunc(Config.YtSubscriptions, func(s YtSubscription) bool {
+		return s.ChatId == chatid && s.ChannelId == channelid
+	})
+
+	return Config.Put()
+}
+
+// YtSubscriptionsPoller checks the subscriptions every YtSubscriptionsInterval
+func YtSubscriptionsPoller() {
+	for {
+		select {
+		case <-time.After(ConfigNow().YtSubscriptionsInterval):
+		case <-StopCtx.Done():
+			return
+		}
+		YtSubscriptionsCheck(StopCtx)
+	}
+}
+
+func YtSubscriptionsCheck(ctx context.Context) {
+	for _, s := range YtSubscriptionsGet(0) {
+		if err := ytSubscriptionCheck(ctx, s); err != nil {
+			LogSubscriptions.Error("subscription check", "chat_id", s.ChatId, "channel_id", s.ChannelId, "err", err)
+		}
+	}
+}
+
+// ytSubscriptionCheck puts jobs for the new uploads of the subscription channel and moves the last-seen marker,
+// the marker is saved before the uploads are posted so a failed post is not retried
+func ytSubscriptionCheck(ctx context.Context, s YtSubscription) error {
+	uploads, err := getYtUploads(ctx, s.UploadsListId, s.LastPublishedAt)
+	if err != nil {
+		return fmt.Errorf("getYtUploads %w", err)
+	}
+
+	newuploads := ytSubscriptionNew(s, uploads)
+	if len(newuploads) == 0 {
+		return nil
+	}
+	LogSubscriptions.Info("subscription new uploads", "chat_id", s.ChatId, "channel_id", s.ChannelId, "uploads", len(newuploads))
+
+	chat := tg.Chat{Id: s.ChatId, Title: s.ChatTitle}
+	if refusal := AccessQuota(tg.Message{Chat: chat}, int64(len(newuploads))); refusal != "" {
+		// the marker stays so the uploads are checked again when the quota allows
+		LogSubscriptions.Warn("subscription refused", "chat_id", s.ChatId, "channel_id", s.ChannelId, "uploads", len(newuploads), "refusal", refusal)
+		return nil
+	}
+
+	last := newuploads[len(newuploads)-1]
+	s.LastPublishedAt, _ = time.Parse(time.RFC3339, last.PublishedAt)
+	s.LastVideoId = last.ResourceId.VideoId
+	if !slices.ContainsFunc(YtSubscriptionsGet(s.ChatId), func(s2 YtSubscription) bool { return s2.ChannelId == s.ChannelId }) {
+		// unsubscribed while checking
+		return nil
+	}
+	if err := YtSubscriptionPut(s); err != nil {
+		return fmt.Errorf("YtSubscriptionPut %w", err)
+	}
+
+	chatsettings := ChatSettingsGet(s.ChatId)
+	for _, u := range newuploads {
+		Jobs.Put(&Job{
+			Message: tg.Message{Chat: chat},
+			Video:   YtVideo{Id: u.ResourceId.VideoId},
+			Media:   chatsettings.Media(chat),
+			Options: chatsettings.PostOptions(),
+		})
+	}
+
+	return nil
+}
+
+func (s YtSubscription) Text() string {
+	text := s.ChannelTitle + NL + "youtube.com/channel/" + s.ChannelId
+	if s.ChannelHandle != "" {
+		text += SP + s.ChannelHandle
+	}
+	return text
+}
+
+func processTgSubscribeCommand(m tg.Message, mtff []string) error {
+	if isadmin, err := tgCheckChatAdmin(m, "subscriptions"); err != nil || !isadmin {
+		return err
+	}
+
+	if len(mtff) == 1 {
+		ss := YtSubscriptionsGet(m.Chat.Id)
+		if len(ss) == 0 {
+			return tgReply(m, "no subscriptions")
+		}
+		var tt []string
+		for _, s := range ss {
+			tt = append(tt, s.Text())
+		}
+		return tgReply(m, strings.Join(tt, NL+NL))
+	}
+
+	channelid, handle, ok := parseYtChannel(mtff[1])
+	if !ok {
+		return tgReply(m, F("not a youtube channel url or handle [%s]", mtff[1]))
+	}
+
+	if strings.SplitN(mtff[0], "@", 2)[0] == ConfigNow().TgCommandUnsubscribe {
+		for _, s := range YtSubscriptionsGet(m.Chat.Id) {
+			if (channelid != "" && s.ChannelId == channelid) || (handle != "" && s.ChannelHandle == handle) {
+				if err := YtSubscriptionDelete(s.ChatId, s.ChannelId); err != nil {
+					return fmt.Errorf("YtSubscriptionDelete %w", err)
+				}
+				return tgReply(m, "unsubscribed from "+s.Text())
+			}
+		}
+		return tgReply(m, F("no subscription to [%s]", mtff[1]))
+	}
+
+	ctx, cancel := context.WithTimeout(StopCtx, ConfigNow().MetadataTimeout)
+	defer cancel()
+
+	c, err := getYtChannel(ctx, channelid, handle)
+	if err != nil {
+		if err2 := tgReply(m, F("youtube channel [%s] not found", mtff[1])); err2 != nil {
+			LogSubscriptions.Error("tgReply", "chat_id", m.Chat.Id, "err", err2)
+		}
+		return fmt.Errorf("getYtChannel %w", err)
+	}
+
+	s := YtSubscription{
+		ChatId:        m.Chat.Id,
+		ChatTitle:     m.Chat.Title,
+		ChannelId:     c.Id,
+		ChannelHandle: handle,
+		ChannelTitle:  c.Snippet.Title,
+		UploadsListId: c.ContentDetails.RelatedPlaylists.Uploads,
+	}
+	if s.ChannelHandle == "" {
+		s.ChannelHandle = strings.ToLower(c.Snippet.CustomUrl)
+	}
+
+	// only uploads after subscribing are posted
+	uploads, err := getYtUploads(ctx, s.UploadsListId, time.Time{})
+	if err != nil {
+		return fmt.Errorf("getYtUploads %w", err)
+	}
+	if len(uploads) > 0 {
+		last := uploads[len(uploads)-1]
+		s.LastPublishedAt, _ = time.Parse(time.RFC3339, last.PublishedAt)
+		s.LastVideoId = last.ResourceId.VideoId
+	} else {
+		s.LastPublishedAt = time.Now().UTC()
+	}
+
+	if err := YtSubscriptionPut(s); err != nil {
+		return fmt.Errorf("YtSubscriptionPut %w", err)
+	}
+
+	return tgReply(m, "subscribed to "+s.Text())
+}
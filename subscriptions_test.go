@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseYtChannel(t *testing.T) {
+	for _, tc := range []struct {
+		s         string
+		channelid string
+		handle    string
+		ok        bool
+	}{
+		{"@Handle", "", "@handle", true},
+		{"https://www.youtube.com/@handle.name/videos", "", "@handle.name", true},
+		{"youtube.com/channel/UC0123456789abcdefghijkl", "UC0123456789abcdefghijkl", "", true},
+		{"UC0123456789abcdefghijkl", "UC0123456789abcdefghijkl", "", true},
+		{"https://youtu.be/abc", "", "", false},
+		{"handle", "", "", false},
+	} {
+		channelid, handle, ok := parseYtChannel(tc.s)
+		if channelid != tc.channelid || handle != tc.handle || ok != tc.ok {
+			t.Errorf("parseYtChannel [%s] got [%s] [%s] <%v>", tc.s, channelid, handle, ok)
+		}
+	}
+}
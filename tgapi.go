@@ -0,0 +1,428 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/shoce/tg"
+)
+
+// Bot API methods not provided by the tg package
+
+type TgBoolResponse struct {
+	Ok          bool   `json:"ok"`
+	Description string `json:"description"`
+	Result      bool   `json:"result"`
+}
+
+func tgPostJson(method string, req interface{}, result interface{}) (err error) {
+	reqjson, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("json.Marshal %w", err)
+	}
+
+	LogTg.Debug("tgPostJson", "method", method, "request", string(reqjson))
+
+	resp, err := tg.HttpClient.Post(
+		F("%s/bot%s/%s", tg.ApiUrl, tg.ApiToken, method),
+		"application/json",
+		bytes.NewBuffer(reqjson),
+	)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("io.ReadAll %w", err)
+	}
+
+	if err := json.Unmarshal(respBody, result); err != nil {
+		return fmt.Errorf("json.Unmarshal %w", err)
+	}
+
+	return nil
+}
+
+type TgSetWebhookRequest struct {
+	// https://core.telegram.org/bots/api#setwebhook
+
+	Url         string `json:"url"`
+	SecretToken string `json:"secret_token,omitempty"`
+
+	DropPendingUpdates bool `json:"drop_pending_updates,omitempty"`
+}
+
+func TgSetWebhook(req TgSetWebhookRequest) error {
+	var tgresp TgBoolResponse
+	if err := tgPostJson("setWebhook", req, &tgresp); err != nil {
+		return err
+	}
+	if !tgresp.Ok {
+		return fmt.Errorf("setWebhook %s", tgresp.Description)
+	}
+	return nil
+}
+
+func TgDeleteWebhook() error {
+	// https://core.telegram.org/bots/api#deletewebhook
+
+	var tgresp TgBoolResponse
+	if err := tgPostJson("deleteWebhook", struct{}{}, &tgresp); err != nil {
+		return err
+	}
+	if !tgresp.Ok {
+		return fmt.Errorf("deleteWebhook %s", tgresp.Description)
+	}
+	return nil
+}
+
+type TgInlineKeyboardButton struct {
+	// https://core.telegram.org/bots/api#inlinekeyboardbutton
+
+	Text         string `json:"text"`
+	CallbackData string `json:"callback_data"`
+}
+
+type TgInlineKeyboardMarkup struct {
+	InlineKeyboard [][]TgInlineKeyboardButton `json:"inline_keyboard"`
+}
+
+type TgSendMessageMarkupRequest struct {
+	// https://core.telegram.org/bots/api#sendmessage
+
+	ChatId           string `json:"chat_id"`
+	ReplyToMessageId int64  `json:"reply_to_message_id,omitempty"`
+	Text             string `json:"text"`
+	ParseMode        string `json:"parse_mode,omitempty"`
+
+	ReplyMarkup TgInlineKeyboardMarkup `json:"reply_markup"`
+}
+
+func TgSendMessageMarkup(req TgSendMessageMarkupRequest) (msg *tg.Message, err error) {
+	if req.ParseMode == "" {
+		req.ParseMode = tg.ParseMode
+	}
+	var tgresp tg.MessageResponse
+	if err := tgPostJson("sendMessage", req, &tgresp); err != nil {
+		return nil, err
+	}
+	if !tgresp.Ok {
+		return nil, fmt.Errorf("sendMessage %s", tgresp.Description)
+	}
+	return tgresp.Result, nil
+}
+
+type TgEditMessageTextMarkupRequest struct {
+	// https://core.telegram.org/bots/api#editmessagetext
+
+	ChatId    string `json:"chat_id"`
+	MessageId int64  `json:"message_id"`
+	Text      string `json:"text"`
+	ParseMode string `json:"parse_mode,omitempty"`
+
+	ReplyMarkup TgInlineKeyboardMarkup `json:"reply_markup"`
+}
+
+func TgEditMessageTextMarkup(req TgEditMessageTextMarkupRequest) error {
+	if req.ParseMode == "" {
+		req.ParseMode = tg.ParseMode
+	}
+	var tgresp tg.MessageResponse
+	if err := tgPostJson("editMessageText", req, &tgresp); err != nil {
+		return err
+	}
+	if !tgresp.Ok {
+		return fmt.Errorf("editMessageText %s", tgresp.Description)
+	}
+	return nil
+}
+
+type TgGetChatRequest struct {
+	// https://core.telegram.org/bots/api#getchat
+
+	ChatId int64 `json:"chat_id"`
+}
+
+// TgGetChat is tg.GetChat also returning the supergroup id of a group upgraded to a supergroup
+func TgGetChat(chatid int64) (chat tg.ChatFullInfo, migrateto int64, err error) {
+	var tgresp struct {
+		TgErrorResponse
+		Result tg.ChatFullInfo `json:"result"`
+	}
+	if err := tgPostJson("getChat", TgGetChatRequest{ChatId: chatid}, &tgresp); err != nil {
+		return chat, 0, err
+	}
+	if !tgresp.Ok {
+		return chat, tgresp.Parameters.MigrateToChatId, fmt.Errorf("getChat %s", tgresp.Description)
+	}
+	return tgresp.Result, 0, nil
+}
+
+// tgUpdateMigrate returns the group id and the supergroup id of the migration service message of the update json,
+// the tg package does not decode them
+func tgUpdateMigrate(tgupdatejson string) (from, to int64, ok bool) {
+	var u struct {
+		Message struct {
+			Chat              tg.Chat `json:"chat"`
+			MigrateToChatId   int64   `json:"migrate_to_chat_id"`
+			MigrateFromChatId int64   `json:"migrate_from_chat_id"`
+		} `json:"message"`
+	}
+	if err := json.Unmarshal([]byte(tgupdatejson), &u); err != nil {
+		return 0, 0, false
+	}
+	switch {
+	case u.Message.MigrateToChatId != 0:
+		return u.Message.Chat.Id, u.Message.MigrateToChatId, true
+	case u.Message.MigrateFromChatId != 0:
+		return u.Message.MigrateFromChatId, u.Message.Chat.Id, true
+	}
+	return 0, 0, false
+}
+
+type TgCallbackQuery struct {
+	// https://core.telegram.org/bots/api#callbackquery
+
+	Id      string     `json:"id"`
+	From    tg.User    `json:"from"`
+	Message tg.Message `json:"message"`
+	Data    string     `json:"data"`
+}
+
+// tgUpdateCallbackQuery returns the callback query of the update json, the tg package does not decode it
+func tgUpdateCallbackQuery(tgupdatejson string) (cq TgCallbackQuery, ok bool) {
+	var u struct {
+		CallbackQuery *TgCallbackQuery `json:"callback_query"`
+	}
+	if err := json.Unmarshal([]byte(tgupdatejson), &u); err != nil || u.CallbackQuery == nil {
+		return cq, false
+	}
+	return *u.CallbackQuery, true
+}
+
+type TgAnswerCallbackQueryRequest struct {
+	// https://core.telegram.org/bots/api#answercallbackquery
+
+	CallbackQueryId string `json:"callback_query_id"`
+	Text            string `json:"text,omitempty"`
+}
+
+func TgAnswerCallbackQuery(req TgAnswerCallbackQueryRequest) error {
+	var tgresp TgBoolResponse
+	if err := tgPostJson("answerCallbackQuery", req, &tgresp); err != nil {
+		return err
+	}
+	if !tgresp.Ok {
+		return fmt.Errorf("answerCallbackQuery %s", tgresp.Description)
+	}
+	return nil
+}
+
+type TgSendAudioRequest struct {
+	// https://core.telegram.org/bots/api#sendaudio
+
+	ChatId   string `json:"chat_id"`
+	Audio    string `json:"audio"`
+	Caption  string `json:"caption,omitempty"`
+	Duration int64  `json:"duration,omitempty"`
+}
+
+// TgSendAudio sends the audio by file_id with a plain text caption
+func TgSendAudio(req TgSendAudioRequest) (msg *tg.Message, err error) {
+	var tgresp tg.MessageResponse
+	if err := tgPostJson("sendAudio", req, &tgresp); err != nil {
+		return nil, err
+	}
+	if !tgresp.Ok {
+		return nil, fmt.Errorf("sendAudio %s", tgresp.Description)
+	}
+	return tgresp.Result, nil
+}
+
+type TgSendVideoRequest struct {
+	// https://core.telegram.org/bots/api#sendvideo
+
+	ChatId   string `json:"chat_id"`
+	Video    string `json:"video"`
+	Caption  string `json:"caption,omitempty"`
+	Width    int    `json:"width,omitempty"`
+	Height   int    `json:"height,omitempty"`
+	Duration int64  `json:"duration,omitempty"`
+}
+
+// TgSendVideo sends the video by file_id with a plain text caption
+func TgSendVideo(req TgSendVideoRequest) (msg *tg.Message, err error) {
+	var tgresp tg.MessageResponse
+	if err := tgPostJson("sendVideo", req, &tgresp); err != nil {
+		return nil, err
+	}
+	if !tgresp.Ok {
+		return nil, fmt.Errorf("sendVideo %s", tgresp.Description)
+	}
+	return tgresp.Result, nil
+}
+
+// tgUploadFile is a file field of the multipart request
+type tgUploadFile struct {
+	Field  string
+	Name   string
+	Reader io.Reader
+}
+
+var (
+	// ErrTgTooManyRequests is the upload rejected with 429, tgUploadRetry repeats it
+	ErrTgTooManyRequests = errors.New("too many requests")
+)
+
+type tgChatIdKey struct{}
+
+// tgPostMultipart streams the fields and the files to the Bot API method,
+// the request is bound to the context and limited by UploadTimeout instead of the timeout of the other requests.
+// The streamed body can not be rewound so TgRetryTransport does not repeat it and only holds back the chat after 429.
+func tgPostMultipart(ctx context.Context, method string, fields [][2]string, files []tgUploadFile) (msg *tg.Message, err error) {
+	ctx, cancel := context.WithTimeout(ctx, ConfigFrom(ctx).UploadTimeout)
+	defer cancel()
+	for _, f := range fields {
+		if f[0] == "chat_id" {
+			ctx = context.WithValue(ctx, tgChatIdKey{}, f[1])
+		}
+	}
+
+	piper, pipew := io.Pipe()
+	defer piper.Close()
+	mpart := multipart.NewWriter(pipew)
+	go func() {
+		pipew.CloseWithError(tgMultipartWrite(mpart, fields, files))
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, F("%s/bot%s/%s", tg.ApiUrl, tg.ApiToken, method), piper)
+	if err != nil {
+		return nil, fmt.Errorf("http.NewRequest %w", err)
+	}
+	req.Header.Set("Content-Type", mpart.FormDataContentType())
+
+	client := http.Client{Transport: tg.HttpClient.Transport}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var tgresp tg.MessageResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tgresp); err != nil {
+		return nil, fmt.Errorf("Decode %w", err)
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return nil, fmt.Errorf("%s %s %w", method, tgresp.Description, ErrTgTooManyRequests)
+	}
+	if !tgresp.Ok || tgresp.Result == nil {
+		return nil, fmt.Errorf("%s %s", method, tgresp.Description)
+	}
+
+	msg = tgresp.Result
+	msg.Id = F("%d", msg.MessageId)
+
+	return msg, nil
+}
+
+func tgMultipartWrite(mpart *multipart.Writer, fields [][2]string, files []tgUploadFile) error {
+	for _, f := range fields {
+		if err := mpart.WriteField(f[0], f[1]); err != nil {
+			return fmt.Errorf("WriteField %s %w", f[0], err)
+		}
+	}
+	for _, f := range files {
+		if f.Reader == nil {
+			continue
+		}
+		w, err := mpart.CreateFormFile(f.Field, f.Name)
+		if err != nil {
+			return fmt.Errorf("CreateFormFile %s %w", f.Field, err)
+		}
+		if _, err := io.Copy(w, f.Reader); err != nil {
+			return fmt.Errorf("Copy %s %w", f.Field, err)
+		}
+	}
+	return mpart.Close()
+}
+
+// tgUploadName returns the file name of the upload made of the letters and the digits of the title like the tg package does
+func tgUploadName(title, ext string) string {
+	name := strings.Map(func(r rune) rune {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			return '.'
+		}
+		return r
+	}, title)
+	if rr := []rune(name); len(rr) > tg.SafestringMaxLen {
+		name = string(rr[:tg.SafestringMaxLen])
+	}
+	return name + ".." + ext
+}
+
+// TgSendAudioFile uploads the audio like tg.SendAudioFile but is cancelled with the context
+func TgSendAudioFile(ctx context.Context, req tg.SendAudioFileRequest) (msg *tg.Message, err error) {
+	// https://core.telegram.org/bots/api#sendaudio
+
+	if req.Audio == nil {
+		return nil, fmt.Errorf("Audio is <nil>")
+	}
+
+	name := tgUploadName(req.Performer+"."+req.Title, "audio")
+	msg, err = tgPostMultipart(ctx, "sendAudio", [][2]string{
+		{"chat_id", req.ChatId},
+		{"caption", req.Caption},
+		{"performer", req.Performer},
+		{"title", req.Title},
+		{"duration", strconv.Itoa(int(req.Duration.Seconds()))},
+	}, []tgUploadFile{
+		{Field: "audio", Name: name, Reader: req.Audio},
+		{Field: "thumb", Name: name, Reader: req.Thumb},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if msg.Audio.FileId == "" {
+		return nil, fmt.Errorf("sendAudio Audio.FileId empty")
+	}
+
+	return msg, nil
+}
+
+// TgSendVideoFile uploads the video like tg.SendVideoFile but is cancelled with the context
+func TgSendVideoFile(ctx context.Context, req tg.SendVideoFileRequest) (msg *tg.Message, err error) {
+	// https://core.telegram.org/bots/api#sendvideo
+
+	if req.Video == nil {
+		return nil, fmt.Errorf("Video is <nil>")
+	}
+
+	msg, err = tgPostMultipart(ctx, "sendVideo", [][2]string{
+		{"chat_id", req.ChatId},
+		{"caption", req.Caption},
+		{"width", strconv.Itoa(req.Width)},
+		{"height", strconv.Itoa(req.Height)},
+		{"duration", strconv.Itoa(int(req.Duration.Seconds()))},
+	}, []tgUploadFile{
+		{Field: "video", Name: tgUploadName(req.Caption, "video"), Reader: req.Video},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if msg.Video.FileId == "" {
+		return nil, fmt.Errorf("sendVideo Video.FileId empty")
+	}
+
+	return msg, nil
+}
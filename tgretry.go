@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shoce/tg"
+)
+
+const (
+	TgRetryMaxDefault     = 5
+	TgRetryBackoffDefault = time.Second
+)
+
+var (
+	// TgIdempotentMethods are the Bot API methods repeated after server and network errors,
+	// the other methods are repeated only after 429 as they could have been done already
+	TgIdempotentMethods = []string{
+		"getUpdates", "getFile", "getChat", "getChatAdministrators",
+		"setMessageReaction", "editMessageText", "deleteMessage", "promoteChatMember",
+		"setWebhook", "deleteWebhook",
+	}
+)
+
+func init() {
+	tg.HttpClient.Transport = &TgRetryTransport{
+		Transport: &TgMetricsTransport{http.DefaultTransport},
+		until:     make(map[string]time.Time),
+	}
+}
+
+// TgRetryTransport repeats Bot API requests rejected with 429 after retry_after holding back the other requests to the chat
+// meanwhile, and repeats requests of TgIdempotentMethods after server and network errors with exponential backoff.
+// The request bodies are rewound with GetBody, the streamed uploads have none and are repeated by tgUploadRetry.
+// Every attempt of a request without its own deadline is limited by TgApiTimeout, the waits between the attempts are not.
+type TgRetryTransport struct {
+	Transport http.RoundTripper
+
+	mu sync.Mutex
+	// until holds the time before which no requests are sent to the chat id
+	until map[string]time.Time
+}
+
+// TgErrorResponse is the Bot API response to a failed request
+type TgErrorResponse struct {
+	Ok          bool   `json:"ok"`
+	ErrorCode   int    `json:"error_code"`
+	Description string `json:"description"`
+	Parameters  struct {
+		RetryAfter      int64 `json:"retry_after"`
+		MigrateToChatId int64 `json:"migrate_to_chat_id"`
+	} `json:"parameters"`
+}
+
+func (t *TgRetryTransport) RoundTrip(req *http.Request) (resp *http.Response, err error) {
+	method := req.URL.Path[strings.LastIndex(req.URL.Path, "/")+1:]
+	chatid := tgRequestChatId(req)
+
+	for attempt := 0; ; attempt++ {
+		if err := t.wait(req, chatid); err != nil {
+			return nil, err
+		}
+
+		ctx, cancel := req.Context(), context.CancelFunc(func() {})
+		if _, ok := ctx.Deadline(); !ok {
+			ctx, cancel = context.WithTimeout(ctx, ConfigNow().TgApiTimeout)
+		}
+		r := req.WithContext(ctx)
+		if attempt > 0 {
+			r = req.Clone(ctx)
+			if req.GetBody != nil {
+				if r.Body, err = req.GetBody(); err != nil {
+					cancel()
+					return nil, err
+				}
+			}
+		}
+
+		resp, err = t.Transport.RoundTrip(r)
+		if err != nil {
+			cancel()
+		} else {
+			resp.Body = &cancelBody{resp.Body, cancel}
+		}
+
+		var delay time.Duration
+		switch {
+		case err != nil || resp.StatusCode >= http.StatusInternalServerError:
+			if !slices.Contains(TgIdempotentMethods, method) {
+				return resp, err
+			}
+			delay = ConfigNow().TgRetryBackoff << attempt
+
+		case resp.StatusCode == http.StatusTooManyRequests:
+			respBody, rerr := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			resp.Body = io.NopCloser(bytes.NewReader(respBody))
+			if rerr != nil {
+				return resp, nil
+			}
+			var tgresp TgErrorResponse
+			if err := json.Unmarshal(respBody, &tgresp); err != nil {
+				LogTg.Warn("json.Unmarshal 429 response", "method", method, "chat_id", chatid, "err", err)
+			}
+			delay = time.Duration(tgresp.Parameters.RetryAfter) * time.Second
+			if delay <= 0 {
+				delay = ConfigNow().TgRetryBackoff << attempt
+			}
+			if chatid != "" {
+				t.hold(chatid, delay)
+			}
+
+		default:
+			return resp, err
+		}
+
+		if attempt >= ConfigNow().TgRetryMax || (req.Body != nil && req.Body != http.NoBody && req.GetBody == nil) {
+			return resp, err
+		}
+
+		LogTg.Warn("retrying", "method", method, "chat_id", chatid, "attempt", attempt+1, "delay", delay, "status", tgResponseStatus(resp), "err", err)
+		MetricsTgApiRetries.WithLabelValues(method).Inc()
+		if resp != nil {
+			resp.Body.Close()
+		}
+		if err := tgSleep(req, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// cancelBody cancels the attempt context when the response body is closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// hold makes the requests to the chat wait for the delay
+func (t *TgRetryTransport) hold(chatid string, delay time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if until := time.Now().Add(delay); until.After(t.until[chatid]) {
+		t.until[chatid] = until
+	}
+}
+
+// wait waits until the requests to the chat are not held back
+func (t *TgRetryTransport) wait(req *http.Request, chatid string) error {
+	t.mu.Lock()
+	until, ok := t.until[chatid]
+	if ok && !time.Now().Before(until) {
+		delete(t.until, chatid)
+	}
+	t.mu.Unlock()
+
+	if d := time.Until(until); d > 0 {
+		LogTg.Debug("chat held back", "chat_id", chatid, "delay", d)
+		return tgSleep(req, d)
+	}
+	return nil
+}
+
+func tgSleep(req *http.Request, d time.Duration) error {
+	select {
+	case <-time.After(d):
+		return nil
+	case <-req.Context().Done():
+		return req.Context().Err()
+	}
+}
+
+func tgResponseStatus(resp *http.Response) int {
+	if resp == nil {
+		return 0
+	}
+	return resp.StatusCode
+}
+
+// tgRequestChatId returns the chat_id of the streamed upload or of the json or multipart request body
+func tgRequestChatId(req *http.Request) string {
+	if chatid, ok := req.Context().Value(tgChatIdKey{}).(string); ok {
+		return chatid
+	}
+	if req.GetBody == nil {
+		return ""
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return ""
+	}
+	defer body.Close()
+
+	mediatype, params, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	switch mediatype {
+	case "application/json":
+		var v struct {
+			ChatId json.RawMessage `json:"chat_id"`
+		}
+		if err := json.NewDecoder(body).Decode(&v); err == nil {
+			return strings.Trim(string(v.ChatId), `"`)
+		}
+	case "multipart/form-data":
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			p, err := mr.NextPart()
+			if err != nil {
+				break
+			}
+			if p.FormName() == "chat_id" {
+				chatid, _ := io.ReadAll(p)
+				return string(chatid)
+			}
+		}
+	}
+	return ""
+}
+
+// tgUploadRetry makes the upload of the file and repeats it up to TgRetryMax times after 429 opening the file again,
+// the wait for retry_after is done by TgRetryTransport holding back the chat
+func tgUploadRetry(ctx context.Context, method string, filename string, upload func(f io.Reader) (*tg.Message, error)) (msg *tg.Message, err error) {
+	for attempt := 0; ; attempt++ {
+		f, err := os.Open(filename)
+		if err != nil {
+			return nil, fmt.Errorf("os.Open %w", err)
+		}
+		msg, err = upload(f)
+		f.Close()
+		if err == nil || !errors.Is(err, ErrTgTooManyRequests) || attempt >= ConfigFrom(ctx).TgRetryMax || ctx.Err() != nil {
+			return msg, err
+		}
+		LogWith(ctx, LogTg).Warn("retrying upload", "method", method, "file", filename, "attempt", attempt+1, "err", err)
+		MetricsTgApiRetries.WithLabelValues(method).Inc()
+	}
+}
@@ -5,22 +5,24 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"math"
 	"net/http"
 	"os"
 	"os/exec"
-	"os/signal"
 	"regexp"
 	"slices"
 	"strconv"
 	"strings"
-	"syscall"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"image"
@@ -48,17 +50,39 @@ const (
 	TgCommandChannelsPromoteAdminDefault = ""
 	TgCommandAudioCompressDefault        = "audio compress"
 
+	TgOversizeModeTranscode           = "transcode"
+	TgOversizeModeSplit               = "split"
+	TgSplitAudioBitrateMinKbpsDefault = 64
+	TgSplitVideoBitrateMinKbpsDefault = 300
+
+	TgUpdatesModePolling   = "polling"
+	TgUpdatesModeWebhook   = "webhook"
+	TgWebhookListenDefault = ":8080"
+
+	YtApiUrlDefault          = "https://www.googleapis.com/youtube/v3"
 	YtMaxResultsDefault      = 50
 	YtThrottleDefault        = 12
 	YtVisitorIdMaxAgeDefault = 59 * time.Minute
+	YtListSleepDefault       = 7 * time.Second
+
+	JobWorkersDefault = 3
+
+	MetadataTimeoutDefault  = time.Minute
+	DownloadTimeoutDefault  = 30 * time.Minute
+	TranscodeTimeoutDefault = 30 * time.Minute
+	UploadTimeoutDefault    = 30 * time.Minute
+	TgApiTimeoutDefault     = time.Minute
+
+	YtListJobMaxFailuresDefault = 3
 
 	FfmpegAudioCompressFilterDefault = "highpass=f=80,acompressor=threshold=-18dB:ratio=4:attack=5:release=100:makeup=6,alimiter=limit=0.95"
 )
 
 type TgZeConfig struct {
-	YssUrl string `yaml:"-"`
-
 	DEBUG bool `yaml:"DEBUG"`
+	// DebugSubsystems enables debug logging of the subsystems: main jobs tg yt dss ffmpeg webhook subscriptions cache
+	DebugSubsystems []string `yaml:"DebugSubsystems,flow"`
+	LogFormat       string   `yaml:"LogFormat"` // LogFormatText or LogFormatJson
 
 	Interval time.Duration `yaml:"Interval"`
 
@@ -69,7 +93,35 @@ type TgZeConfig struct {
 	TgUpdateLog        []int64 `yaml:"TgUpdateLog,flow"`
 	TgUpdateLogMaxSize int     `yaml:"TgUpdateLogMaxSize"` // 333
 
-	TgCommandChannels             string `yaml:"TgCommandChannels"`
+	// TgRetryMax is how many times a failed Bot API request is repeated, TgRetryBackoff is the first delay after a server error
+	TgRetryMax     int           `yaml:"TgRetryMax"`     // TgRetryMaxDefault
+	TgRetryBackoff time.Duration `yaml:"TgRetryBackoff"` // TgRetryBackoffDefault
+
+	// TgProgressInterval is how long a job runs before its status message is sent and how often the message is edited
+	TgProgressInterval time.Duration `yaml:"TgProgressInterval"` // TgProgressIntervalDefault
+
+	TgUpdatesMode        string `yaml:"TgUpdatesMode"`        // TgUpdatesModePolling or TgUpdatesModeWebhook
+	TgWebhookUrl         string `yaml:"TgWebhookUrl"`         // "https://tgze.example.org/webhook"
+	TgWebhookListen      string `yaml:"TgWebhookListen"`      // TgWebhookListenDefault
+	TgWebhookSecretToken string `yaml:"TgWebhookSecretToken"` // https://core.telegram.org/bots/api#setwebhook secret_token
+
+	// MetricsListen serves /metrics and the /healthz and /readyz probes
+	MetricsListen    string        `yaml:"MetricsListen"`    // MetricsListenDefault
+	HealthLoopMaxAge time.Duration `yaml:"HealthLoopMaxAge"` // HealthLoopMaxAgeDefault
+
+	TgCommandChannels             string `yaml:"TgCommandChannels"`    // TgCommandChannelsDefault
+	TgCommandSettings             string `yaml:"TgCommandSettings"`    // TgCommandSettingsDefault
+	TgCommandStats                string `yaml:"TgCommandStats"`       // TgCommandStatsDefault
+	TgCommandSubscribe            string `yaml:"TgCommandSubscribe"`   // TgCommandSubscribeDefault
+	TgCommandUnsubscribe          string `yaml:"TgCommandUnsubscribe"` // TgCommandUnsubscribeDefault
+	TgCommandWatch                string `yaml:"TgCommandWatch"`       // TgCommandWatchDefault
+	TgCommandUnwatch              string `yaml:"TgCommandUnwatch"`     // TgCommandUnwatchDefault
+	TgCommandQueue                string `yaml:"TgCommandQueue"`       // TgCommandQueueDefault
+	TgCommandCancel               string `yaml:"TgCommandCancel"`      // TgCommandCancelDefault
+	TgCommandAllow                string `yaml:"TgCommandAllow"`       // TgCommandAllowDefault
+	TgCommandDeny                 string `yaml:"TgCommandDeny"`        // TgCommandDenyDefault
+	TgCommandQuota                string `yaml:"TgCommandQuota"`       // TgCommandQuotaDefault
+	TgCommandAccess               string `yaml:"TgCommandAccess"`      // TgCommandAccessDefault
 	TgCommandChannelsPromoteAdmin string `yaml:"TgCommandChannelsPromoteAdmin"`
 	TgCommandAudioCompress        string `yaml:"TgCommandAudioCompress"`
 
@@ -81,26 +133,79 @@ type TgZeConfig struct {
 	TgQuest3Key string `yaml:"TgQuest3Key"`
 
 	TgAllChannelsChatIds []int64 `yaml:"TgAllChannelsChatIds,flow"`
+	// ChannelsPruneInterval is how often the chats the bot can not post to anymore are removed from TgAllChannelsChatIds
+	ChannelsPruneInterval time.Duration `yaml:"ChannelsPruneInterval"` // ChannelsPruneIntervalDefault
+
+	ChatSettings []ChatSettings `yaml:"ChatSettings"`
+
+	// AccessOpen lets everyone use the bot within the quotas, otherwise only the users and the chats in AccessList can
+	AccessOpen bool          `yaml:"AccessOpen"`
+	AccessList []AccessEntry `yaml:"AccessList"`
+	// AccessSeeded is set once AccessSeed added the known chats to AccessList
+	AccessSeeded bool `yaml:"AccessSeeded"`
+	// AccessQuotaJobs and AccessQuotaBytes are the daily quotas of every user and chat without their own
+	AccessQuotaJobs  int64  `yaml:"AccessQuotaJobs"`  // AccessQuotaJobsDefault
+	AccessQuotaBytes int64  `yaml:"AccessQuotaBytes"` // AccessQuotaBytesDefault
+	AccessRefusal    string `yaml:"AccessRefusal"`    // AccessRefusalDefault
+	// AccessUsage is kept in the access store by AccessUsageInit, the usage here is moved there at the start
+	AccessUsage []AccessUsage `yaml:"AccessUsage,omitempty"`
+
+	// TgFileCache is kept in the filecache store by TgFileCacheInit, the entries here are moved there at the start
+	TgFileCache        []TgFileCacheEntry `yaml:"TgFileCache,omitempty"`
+	TgFileCacheMaxSize int                `yaml:"TgFileCacheMaxSize"` // TgFileCacheMaxSizeDefault
+
+	// TgPosted is kept in the posted store by TgPostedInit, the entries here are moved there at the start
+	TgPosted        []TgPostedEntry `yaml:"TgPosted,omitempty"`
+	TgPostedMaxSize int             `yaml:"TgPostedMaxSize"` // TgPostedMaxSizeDefault
 
 	TgMaxFileSizeBytes      int64 `yaml:"TgMaxFileSizeBytes"`      // 47 << 20
 	TgVideoAudioBitrateKbps int64 `yaml:"TgVideoAudioBitrateKbps"` // 60
 
+	TgOversizeMode             string `yaml:"TgOversizeMode"`             // TgOversizeModeTranscode or TgOversizeModeSplit
+	TgSplitAudioBitrateMinKbps int64  `yaml:"TgSplitAudioBitrateMinKbps"` // TgSplitAudioBitrateMinKbpsDefault
+	TgSplitVideoBitrateMinKbps int64  `yaml:"TgSplitVideoBitrateMinKbps"` // TgSplitVideoBitrateMinKbpsDefault
+
 	FfmpegPath                string   `yaml:"FfmpegPath"`          // "/bin/ffmpeg"
 	FfmpegGlobalOptions       []string `yaml:"FfmpegGlobalOptions"` // []string{"-v", "error"}
 	FfmpegAudioCompressFilter string   `yaml:"FfmpegAudioCompressFilter"`
 
 	DssUrl string `yaml:"DssUrl"` // "http://dss:80"
 
+	JobWorkers int `yaml:"JobWorkers"` // JobWorkersDefault
+
+	// MetadataTimeout DownloadTimeout TranscodeTimeout and UploadTimeout limit the stages of posting a video
+	MetadataTimeout  time.Duration `yaml:"MetadataTimeout"`  // MetadataTimeoutDefault
+	DownloadTimeout  time.Duration `yaml:"DownloadTimeout"`  // DownloadTimeoutDefault
+	TranscodeTimeout time.Duration `yaml:"TranscodeTimeout"` // TranscodeTimeoutDefault
+	// UploadTimeout limits every upload of a file, the other Bot API requests are limited by TgApiTimeout
+	UploadTimeout time.Duration `yaml:"UploadTimeout"` // UploadTimeoutDefault
+	TgApiTimeout  time.Duration `yaml:"TgApiTimeout"`  // TgApiTimeoutDefault
+
+	// ShutdownGrace is how long the running jobs have to finish after SIGTERM before they are cancelled
+	ShutdownGrace  time.Duration   `yaml:"ShutdownGrace"` // ShutdownGraceDefault
+	JobsUnfinished []JobUnfinished `yaml:"JobsUnfinished"`
+
+	YtListJobs           []YtListJob `yaml:"YtListJobs"`
+	YtListJobMaxFailures int         `yaml:"YtListJobMaxFailures"` // YtListJobMaxFailuresDefault
+
+	YtApiUrl     string `yaml:"YtApiUrl"` // YtApiUrlDefault
 	YtKey        string `yaml:"YtKey"`
 	YtMaxResults int64  `yaml:"YtMaxResults"` // YtMaxResultsDefault
 	YtThrottle   int64  `yaml:"YtThrottle"`   // YtThrottleDefault
 
+	YtListSleep time.Duration `yaml:"YtListSleep"` // YtListSleepDefault
+
+	YtSubscriptions         []YtSubscription `yaml:"YtSubscriptions"`
+	YtSubscriptionsInterval time.Duration    `yaml:"YtSubscriptionsInterval"` // YtSubscriptionsIntervalDefault
+
+	YtListWatches         []YtListWatch `yaml:"YtListWatches"`
+	YtListWatchesInterval time.Duration `yaml:"YtListWatchesInterval"` // YtListWatchesIntervalDefault
+
 	YtVisitorIdMaxAge time.Duration `yaml:"YtVisitorIdMaxAge"` // YtVisitorIdMaxAgeDefault 59*time.Minute
 	YtUserAgent       string        `yaml:"YtUserAgent"`       // "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/26.0.1 Safari/605.1.15"
 
 	// https://golang.org/s/re2syntax
 	// (?:re)	non-capturing group
-	// TODO add support for https://www.youtube.com/watch?&list=PL5Qevr-CpW_yZZjYspehnFc-QRKQMCKHB&v=1nzx7O7ndfI&index=34
 	YtRe     string `yaml:"YtRe"`     // `(?:youtube.com/watch\?v=|youtu.be/|youtube.com/watch/|youtube.com/shorts/|youtube.com/live/)([0-9A-Za-z_-]+)`
 	YtListRe string `yaml:"YtListRe"` // `youtube.com/playlist\?list=([0-9A-Za-z_-]+)`
 
@@ -108,11 +213,15 @@ type TgZeConfig struct {
 }
 
 var (
-	Ctx context.Context
+	HttpClient = &http.Client{Transport: &UserAgentTransport{http.DefaultTransport, ConfigNow().YtUserAgent}}
 
-	HttpClient = &http.Client{Transport: &UserAgentTransport{http.DefaultTransport, Config.YtUserAgent}}
+	// Config with the state is read and changed only under ConfigMu,
+	// other goroutines read the settings from the snapshot of ConfigNow
+	Config    TgZeConfig
+	ConfigMu  sync.Mutex
+	configNow atomic.Pointer[TgZeConfig]
 
-	Config TgZeConfig
+	TgUpdateMu sync.Mutex
 
 	YtdlCl         ytdl.Client
 	YtRe, YtListRe *regexp.Regexp
@@ -121,95 +230,114 @@ var (
 	pout = fmt.Print
 )
 
-func init() {
-	Ctx = context.TODO()
-
-	if v := os.Getenv("YssUrl"); v != "" {
-		Config.YssUrl = v
+// ConfigNow returns the snapshot of the settings swapped in by the last ConfigGet or ConfigSet,
+// the state fields are empty in it and it must not be changed
+func ConfigNow() *TgZeConfig {
+	if c := configNow.Load(); c != nil {
+		return c
 	}
-	if Config.YssUrl == "" {
-		perr("ERROR YssUrl empty")
-		os.Exit(1)
-	}
-	perr(F("YssUrl [%s]", Config.YssUrl))
+	return &TgZeConfig{}
+}
 
-	if err := ConfigGet(); err != nil {
-		perr(F("ERROR ConfigGet %v", err))
-		os.Exit(1)
-	}
+type configKey struct{}
 
+// ConfigContext returns the context carrying the settings snapshot for the job
+func ConfigContext(ctx context.Context, c *TgZeConfig) context.Context {
+	return context.WithValue(ctx, configKey{}, c)
 }
 
-func ConfigGet() (err error) {
-	if err := Config.Get(); err != nil {
-		return err
-	}
-
-	if Config.DEBUG {
-		perr("DEBUG <true>")
-		tg.DEBUG = true
+// ConfigFrom returns the settings snapshot of the context or the current one
+func ConfigFrom(ctx context.Context) *TgZeConfig {
+	if c, ok := ctx.Value(configKey{}).(*TgZeConfig); ok {
+		return c
 	}
+	return ConfigNow()
+}
 
-	perr(F("Interval <%v>", Config.Interval))
-	if Config.Interval == 0 {
-		return fmt.Errorf("Interval empty")
-	}
+// Settings returns a copy of the config without the state changed by the bot
+func (c TgZeConfig) Settings() *TgZeConfig {
+	c.TgUpdateLog = nil
+	c.TgAllChannelsChatIds = nil
+	c.ChatSettings = nil
+	c.AccessList = nil
+	c.AccessSeeded = false
+	c.AccessUsage = nil
+	c.TgFileCache = nil
+	c.TgPosted = nil
+	c.JobsUnfinished = nil
+	c.YtListJobs = nil
+	c.YtSubscriptions = nil
+	c.YtListWatches = nil
+	return &c
+}
 
-	YtRe, err = regexp.Compile(Config.YtRe)
-	if err != nil {
-		return fmt.Errorf("Compile YtRe [%s] %w", Config.YtRe, err)
-	}
-	YtListRe, err = regexp.Compile(Config.YtListRe)
-	if err != nil {
-		return fmt.Errorf("Compile YtListRe [%s] %w", Config.YtListRe, err)
-	}
+// ConfigSet changes the settings of Config with set and swaps in the new snapshot
+func ConfigSet(set func(c *TgZeConfig)) {
+	ConfigMu.Lock()
+	defer ConfigMu.Unlock()
 
-	if Config.TgToken == "" {
-		return fmt.Errorf("ERROR TgToken empty")
-	}
+	set(&Config)
+	configNow.Store(Config.Settings())
+}
 
-	tg.ApiToken = Config.TgToken
+// ConfigGet reads the config from the store into a new TgZeConfig and swaps it in under ConfigMu,
+// the package globals depending on the config are set once by ConfigInit
+func ConfigGet() error {
+	ConfigMu.Lock()
+	defer ConfigMu.Unlock()
 
-	perr(F("TgApiUrl [%s]", Config.TgApiUrl))
-	if Config.TgApiUrl == "" {
-		return fmt.Errorf("TgApiUrl empty")
+	var c TgZeConfig
+	if err := c.Get(); err != nil {
+		return err
+	}
+	if err := c.Defaults(); err != nil {
+		return err
+	}
+	if err := LogConfigure(c.LogFormat, c.DEBUG, c.DebugSubsystems); err != nil {
+		return err
 	}
 
-	tg.ApiUrl = Config.TgApiUrl
+	Config = c
+	configNow.Store(c.Settings())
 
-	//perr(F("TgUpdateLog %+v", Config.TgUpdateLog))
+	return nil
+}
 
-	if Config.TgCommandChannels == "" {
-		Config.TgCommandChannels = TgCommandChannelsDefault
+// ConfigInit reads the config and sets the package globals depending on it,
+// changes of these settings need a restart
+func ConfigInit() (err error) {
+	if err := ConfigGet(); err != nil {
+		return err
 	}
+	c := ConfigNow()
 
-	if Config.TgCommandAudioCompress == "" {
-		Config.TgCommandAudioCompress = TgCommandAudioCompressDefault
+	if err := TgFileCacheInit(); err != nil {
+		return fmt.Errorf("TgFileCacheInit %w", err)
 	}
-
-	if Config.TgCommandChannelsPromoteAdmin == "" {
-		return fmt.Errorf("TgCommandChannelsPromoteAdmin empty")
+	if err := TgPostedInit(); err != nil {
+		return fmt.Errorf("TgPostedInit %w", err)
 	}
-
-	perr(F("DssUrl [%s]", Config.DssUrl))
-
-	if Config.YtKey == "" {
-		return fmt.Errorf("YtKey empty")
+	if err := AccessUsageInit(); err != nil {
+		return fmt.Errorf("AccessUsageInit %w", err)
 	}
-
-	if Config.YtMaxResults == 0 {
-		Config.YtMaxResults = YtMaxResultsDefault
+	if err := AccessSeed(); err != nil {
+		return fmt.Errorf("AccessSeed %w", err)
 	}
 
-	if Config.YtThrottle == 0 {
-		Config.YtThrottle = YtThrottleDefault
-	}
-	perr(F("DEBUG YtThrottle <%d>", Config.YtThrottle))
+	tg.DEBUG = LogDebug("tg")
+	tg.ApiToken = c.TgToken
+	tg.ApiUrl = c.TgApiUrl
 
-	if Config.YtVisitorIdMaxAge == 0 {
-		Config.YtVisitorIdMaxAge = YtVisitorIdMaxAgeDefault
+	YtRe, err = regexp.Compile(c.YtRe)
+	if err != nil {
+		return fmt.Errorf("Compile YtRe [%s] %w", c.YtRe, err)
+	}
+	YtListRe, err = regexp.Compile(c.YtListRe)
+	if err != nil {
+		return fmt.Errorf("Compile YtListRe [%s] %w", c.YtListRe, err)
 	}
-	ytdl.VisitorIdMaxAge = Config.YtVisitorIdMaxAge
+
+	ytdl.VisitorIdMaxAge = c.YtVisitorIdMaxAge
 
 	// https://pkg.go.dev/github.com/kkdai/youtube/v2/#pkg-variables
 	/*
@@ -228,58 +356,303 @@ func ConfigGet() (err error) {
 		HTTPClient: &http.Client{
 			Transport: &UserAgentTransport{
 				http.DefaultTransport,
-				Config.YtUserAgent,
+				c.YtUserAgent,
 			},
 		},
 	}
 
-	perr(F("FfmpegPath [%s]", Config.FfmpegPath))
-	perr(F("DEBUG FfmpegGlobalOptions %s", AtonListStrings(Config.FfmpegGlobalOptions)))
-	if Config.FfmpegAudioCompressFilter == "" {
-		Config.FfmpegAudioCompressFilter = FfmpegAudioCompressFilterDefault
+	return nil
+}
+
+// Defaults sets the defaults of the empty settings and checks the settings
+func (c *TgZeConfig) Defaults() error {
+	if c.DEBUG {
+		Log.Info("config", "DEBUG", c.DEBUG)
+	}
+
+	Log.Info("config", "Interval", c.Interval)
+	if c.Interval == 0 {
+		return fmt.Errorf("Interval empty")
+	}
+
+	if c.TgToken == "" {
+		return fmt.Errorf("ERROR TgToken empty")
+	}
+
+	Log.Info("config", "TgApiUrl", c.TgApiUrl)
+	if c.TgApiUrl == "" {
+		return fmt.Errorf("TgApiUrl empty")
+	}
+
+	if c.TgUpdatesMode == "" {
+		c.TgUpdatesMode = TgUpdatesModePolling
+	}
+	Log.Info("config", "TgUpdatesMode", c.TgUpdatesMode)
+	switch c.TgUpdatesMode {
+	case TgUpdatesModePolling:
+	case TgUpdatesModeWebhook:
+		if c.TgWebhookUrl == "" {
+			return fmt.Errorf("TgWebhookUrl empty")
+		}
+		if c.TgWebhookSecretToken == "" {
+			return fmt.Errorf("TgWebhookSecretToken empty")
+		}
+		if c.TgWebhookListen == "" {
+			c.TgWebhookListen = TgWebhookListenDefault
+		}
+	default:
+		return fmt.Errorf("TgUpdatesMode [%s] unsupported", c.TgUpdatesMode)
+	}
+
+	if c.MetricsListen == "" {
+		c.MetricsListen = MetricsListenDefault
+	}
+	if c.HealthLoopMaxAge == 0 {
+		c.HealthLoopMaxAge = HealthLoopMaxAgeDefault
+	}
+
+	if c.TgCommandChannels == "" {
+		c.TgCommandChannels = TgCommandChannelsDefault
+	}
+	if c.TgCommandSettings == "" {
+		c.TgCommandSettings = TgCommandSettingsDefault
+	}
+	if c.TgCommandStats == "" {
+		c.TgCommandStats = TgCommandStatsDefault
+	}
+	if c.TgCommandSubscribe == "" {
+		c.TgCommandSubscribe = TgCommandSubscribeDefault
+	}
+	if c.TgCommandUnsubscribe == "" {
+		c.TgCommandUnsubscribe = TgCommandUnsubscribeDefault
+	}
+	if c.TgCommandWatch == "" {
+		c.TgCommandWatch = TgCommandWatchDefault
+	}
+	if c.TgCommandUnwatch == "" {
+		c.TgCommandUnwatch = TgCommandUnwatchDefault
+	}
+	if c.TgCommandQueue == "" {
+		c.TgCommandQueue = TgCommandQueueDefault
+	}
+	if c.TgCommandCancel == "" {
+		c.TgCommandCancel = TgCommandCancelDefault
+	}
+	if c.TgCommandAllow == "" {
+		c.TgCommandAllow = TgCommandAllowDefault
+	}
+	if c.TgCommandDeny == "" {
+		c.TgCommandDeny = TgCommandDenyDefault
+	}
+	if c.TgCommandQuota == "" {
+		c.TgCommandQuota = TgCommandQuotaDefault
+	}
+	if c.TgCommandAccess == "" {
+		c.TgCommandAccess = TgCommandAccessDefault
+	}
+
+	if c.AccessQuotaJobs == 0 {
+		c.AccessQuotaJobs = AccessQuotaJobsDefault
+	}
+	if c.AccessQuotaBytes == 0 {
+		c.AccessQuotaBytes = AccessQuotaBytesDefault
+	}
+	if c.AccessRefusal == "" {
+		c.AccessRefusal = AccessRefusalDefault
+	}
+
+	if c.ChannelsPruneInterval == 0 {
+		c.ChannelsPruneInterval = ChannelsPruneIntervalDefault
+	}
+
+	if c.TgFileCacheMaxSize == 0 {
+		c.TgFileCacheMaxSize = TgFileCacheMaxSizeDefault
+	}
+	if c.TgPostedMaxSize == 0 {
+		c.TgPostedMaxSize = TgPostedMaxSizeDefault
+	}
+
+	if c.TgCommandAudioCompress == "" {
+		c.TgCommandAudioCompress = TgCommandAudioCompressDefault
+	}
+
+	if c.TgCommandChannelsPromoteAdmin == "" {
+		return fmt.Errorf("TgCommandChannelsPromoteAdmin empty")
+	}
+
+	if c.TgOversizeMode == "" {
+		c.TgOversizeMode = TgOversizeModeTranscode
+	}
+	if c.TgOversizeMode != TgOversizeModeTranscode && c.TgOversizeMode != TgOversizeModeSplit {
+		return fmt.Errorf("TgOversizeMode [%s] unsupported", c.TgOversizeMode)
+	}
+	if c.TgOversizeMode == TgOversizeModeSplit && c.FfmpegPath == "" {
+		Log.Warn("config TgOversizeMode split needs FfmpegPath, using transcode")
+		c.TgOversizeMode = TgOversizeModeTranscode
+	}
+	if c.TgSplitAudioBitrateMinKbps == 0 {
+		c.TgSplitAudioBitrateMinKbps = TgSplitAudioBitrateMinKbpsDefault
+	}
+	if c.TgSplitVideoBitrateMinKbps == 0 {
+		c.TgSplitVideoBitrateMinKbps = TgSplitVideoBitrateMinKbpsDefault
+	}
+
+	Log.Info("config", "DssUrl", c.DssUrl)
+
+	if c.YtKey == "" {
+		return fmt.Errorf("YtKey empty")
+	}
+
+	if c.YtApiUrl == "" {
+		c.YtApiUrl = YtApiUrlDefault
+	}
+
+	if c.YtMaxResults == 0 {
+		c.YtMaxResults = YtMaxResultsDefault
+	}
+
+	if c.YtThrottle == 0 {
+		c.YtThrottle = YtThrottleDefault
+	}
+	Log.Debug("config", "YtThrottle", c.YtThrottle)
+
+	if c.YtListSleep == 0 {
+		c.YtListSleep = YtListSleepDefault
+	}
+
+	if c.YtSubscriptionsInterval == 0 {
+		c.YtSubscriptionsInterval = YtSubscriptionsIntervalDefault
+	}
+	if c.YtListWatchesInterval == 0 {
+		c.YtListWatchesInterval = YtListWatchesIntervalDefault
+	}
+
+	if c.JobWorkers == 0 {
+		c.JobWorkers = JobWorkersDefault
+	}
+
+	if c.TgRetryMax == 0 {
+		c.TgRetryMax = TgRetryMaxDefault
+	}
+	if c.TgRetryBackoff == 0 {
+		c.TgRetryBackoff = TgRetryBackoffDefault
+	}
+
+	if c.TgProgressInterval == 0 {
+		c.TgProgressInterval = TgProgressIntervalDefault
+	}
+
+	if c.MetadataTimeout == 0 {
+		c.MetadataTimeout = MetadataTimeoutDefault
+	}
+	if c.DownloadTimeout == 0 {
+		c.DownloadTimeout = DownloadTimeoutDefault
+	}
+	if c.TranscodeTimeout == 0 {
+		c.TranscodeTimeout = TranscodeTimeoutDefault
+	}
+	if c.UploadTimeout == 0 {
+		c.UploadTimeout = UploadTimeoutDefault
+	}
+	if c.TgApiTimeout == 0 {
+		c.TgApiTimeout = TgApiTimeoutDefault
+	}
+
+	if c.ShutdownGrace == 0 {
+		c.ShutdownGrace = ShutdownGraceDefault
+	}
+
+	if c.YtListJobMaxFailures == 0 {
+		c.YtListJobMaxFailures = YtListJobMaxFailuresDefault
+	}
+
+	if c.YtVisitorIdMaxAge == 0 {
+		c.YtVisitorIdMaxAge = YtVisitorIdMaxAgeDefault
+	}
+
+	Log.Info("config", "FfmpegPath", c.FfmpegPath)
+	Log.Debug("config", "FfmpegGlobalOptions", AtonListStrings(c.FfmpegGlobalOptions))
+	if c.FfmpegAudioCompressFilter == "" {
+		c.FfmpegAudioCompressFilter = FfmpegAudioCompressFilterDefault
 	}
 
 	return nil
 }
 
 func main() {
-	sigterm := make(chan os.Signal, 1)
-	signal.Notify(sigterm, syscall.SIGTERM)
-	go func(sigterm chan os.Signal) {
-		<-sigterm
-		tg.SendMessage(tg.SendMessageRequest{
-			ChatId: fmt.Sprintf("%d", Config.TgZeChatId),
-			Text: tg.Esc(F(
-				"%s sigterm", os.Args[0],
-			)),
-		})
-		perr("sigterm")
+	if err := ConfigStoreInit(); err != nil {
+		Log.Error("ConfigStoreInit", "err", err)
+		os.Exit(1)
+	}
+
+	if err := ConfigInit(); err != nil {
+		Log.Error("ConfigInit", "err", err)
 		os.Exit(1)
-	}(sigterm)
+	}
+
+	ShutdownNotify()
+
+	MetricsStart()
+
+	Jobs.Start(ConfigNow().JobWorkers)
+
+	if err := YtListJobsResume(); err != nil {
+		Log.Error("YtListJobsResume", "err", err)
+	}
+	if err := JobsUnfinishedResume(); err != nil {
+		Log.Error("JobsUnfinishedResume", "err", err)
+	}
+
+	go YtSubscriptionsPoller()
+	go YtListWatchesPoller()
+	go ChannelsPruner()
+
+	switch ConfigNow().TgUpdatesMode {
+	case TgUpdatesModeWebhook:
+		if err := TgWebhookStart(); err != nil {
+			Log.Error("TgWebhookStart", "err", err)
+			os.Exit(1)
+		}
+	case TgUpdatesModePolling:
+		if err := TgDeleteWebhook(); err != nil {
+			Log.Error("TgDeleteWebhook", "err", err)
+		}
+	}
 
-	for {
+	for StopCtx.Err() == nil {
 		err := ConfigGet()
 		if err != nil {
-			perr(F("ERROR ConfigGet %v", err))
+			Log.Error("ConfigGet", "err", err)
 			os.Exit(1)
 		}
 
-		ticker := time.NewTicker(Config.Interval)
+		HealthLoopTick()
 
-		err = TgGetUpdates()
-		if err != nil {
-			perr(F("ERROR TgGetUpdates %v", err))
+		ticker := time.NewTicker(ConfigNow().Interval)
+
+		if ConfigNow().TgUpdatesMode == TgUpdatesModePolling {
+			err = TgGetUpdates()
+			if err != nil {
+				Log.Error("TgGetUpdates", "err", err)
+			}
 		}
 
-		//perr("DEBUG sleeping")
-		<-ticker.C
+		select {
+		case <-ticker.C:
+		case <-StopCtx.Done():
+		}
+		ticker.Stop()
 	}
 
-	return
+	Shutdown()
 }
 
 type YtChannel struct {
-	Id             string `json:"id"`
+	Id      string `json:"id"`
+	Snippet struct {
+		Title     string `json:"title"`
+		CustomUrl string `json:"customUrl"`
+	} `json:"snippet"`
 	ContentDetails struct {
 		RelatedPlaylists struct {
 			Uploads string `json:"uploads"`
@@ -362,6 +735,10 @@ type YtPlaylistItemSnippet struct {
 
 type YtPlaylistItem struct {
 	Snippet YtPlaylistItemSnippet `json:"snippet"`
+	Status  struct {
+		// PrivacyStatus is private for private videos and privacyStatusUnspecified for deleted ones
+		PrivacyStatus string `json:"privacyStatus"`
+	} `json:"status"`
 }
 
 type YtPlaylistItems struct {
@@ -384,6 +761,9 @@ type YtList struct {
 type YtVideo struct {
 	Id            string
 	PlaylistIndex int64
+	Title         string
+	// Unavailable is set for the private and deleted videos of a list
+	Unavailable bool
 }
 
 type UserAgentTransport struct {
@@ -400,9 +780,11 @@ func TgGetUpdates() (err error) {
 
 	var updatesoffset int64
 
+	ConfigMu.Lock()
 	if len(Config.TgUpdateLog) > 0 {
 		updatesoffset = Config.TgUpdateLog[len(Config.TgUpdateLog)-1] + 1
 	}
+	ConfigMu.Unlock()
 
 	var uu []tg.Update
 	var tgupdatesjson string
@@ -411,44 +793,73 @@ func TgGetUpdates() (err error) {
 		return fmt.Errorf("tg.GetUpdates %v", err)
 	}
 
-	for _, u := range uu {
-		perr("Update" + SP + strings.ReplaceAll(F("%+v", u), NL, "<NL>"))
-		/*
-			if len(TgUpdateLog) > 0 && u.UpdateId < TgUpdateLog[len(TgUpdateLog)-1] {
-				log("WARNING this telegram update id <%d> is older than last id <%d>, skipping", u.UpdateId, TgUpdateLog[len(TgUpdateLog)-1])
-				continue
-			}
-		*/
-		if slices.Contains(Config.TgUpdateLog, u.UpdateId) {
-			perr(F("WARNING this telegram update id <%d> was already processed, skipping", u.UpdateId))
-			continue
+	var tgupdatesraw struct {
+		Result []json.RawMessage `json:"result"`
+	}
+	if err := json.Unmarshal([]byte(tgupdatesjson), &tgupdatesraw); err != nil {
+		LogTg.Warn("json.Unmarshal updates", "err", err)
+	}
+
+	for i, u := range uu {
+		tgupdatejson := tgupdatesjson
+		if len(tgupdatesraw.Result) == len(uu) {
+			tgupdatejson = string(tgupdatesraw.Result[i])
 		}
-		Config.TgUpdateLog = append(Config.TgUpdateLog, u.UpdateId)
-		if len(Config.TgUpdateLog) > Config.TgUpdateLogMaxSize {
-			Config.TgUpdateLog = Config.TgUpdateLog[len(Config.TgUpdateLog)-Config.TgUpdateLogMaxSize:]
+		if err := TgHandleUpdate(u, tgupdatejson); err != nil {
+			return err
 		}
-		if err := Config.Put(); err != nil {
-			return fmt.Errorf("Config.Put %v", err)
+	}
+
+	return nil
+
+}
+
+// TgHandleUpdate processes the update once, both for polling and webhook modes
+func TgHandleUpdate(u tg.Update, tgupdatejson string) (err error) {
+	TgUpdateMu.Lock()
+	defer TgUpdateMu.Unlock()
+
+	LogTg.Debug("update", "update_id", u.UpdateId, "update", strings.ReplaceAll(F("%+v", u), NL, "<NL>"))
+	/*
+		if len(TgUpdateLog) > 0 && u.UpdateId < TgUpdateLog[len(TgUpdateLog)-1] {
+			log("WARNING this telegram update id <%d> is older than last id <%d>, skipping", u.UpdateId, TgUpdateLog[len(TgUpdateLog)-1])
+			continue
 		}
+	*/
+	ConfigMu.Lock()
+	if slices.Contains(Config.TgUpdateLog, u.UpdateId) {
+		ConfigMu.Unlock()
+		LogTg.Warn("update already processed, skipping", "update_id", u.UpdateId)
+		return nil
+	}
+	Config.TgUpdateLog = append(Config.TgUpdateLog, u.UpdateId)
+	if len(Config.TgUpdateLog) > ConfigNow().TgUpdateLogMaxSize {
+		Config.TgUpdateLog = Config.TgUpdateLog[len(Config.TgUpdateLog)-ConfigNow().TgUpdateLogMaxSize:]
+	}
+	err = Config.Put()
+	ConfigMu.Unlock()
+	if err != nil {
+		return fmt.Errorf("Config.Put %v", err)
+	}
 
-		if m, err := processTgUpdate(u, tgupdatesjson); err != nil {
-			perr(F("ERROR processTgUpdate %v", err))
-			if tgerr := tg.SetMessageReaction(tg.SetMessageReactionRequest{
-				ChatId:    fmt.Sprintf("%d", m.Chat.Id),
-				MessageId: m.MessageId,
-				Reaction:  []tg.ReactionTypeEmoji{tg.ReactionTypeEmoji{Emoji: "🤷‍♂"}},
-			}); tgerr != nil {
-				perr(F("ERROR tg.SetMessageReaction [🤷‍♂] %v", tgerr))
-			}
-			return err
+	if m, err := processTgUpdate(u, tgupdatejson); err != nil {
+		MetricsUpdates.WithLabelValues("error").Inc()
+		LogTg.Error("processTgUpdate", "update_id", u.UpdateId, "chat_id", m.Chat.Id, "err", err)
+		if tgerr := tg.SetMessageReaction(tg.SetMessageReactionRequest{
+			ChatId:    fmt.Sprintf("%d", m.Chat.Id),
+			MessageId: m.MessageId,
+			Reaction:  []tg.ReactionTypeEmoji{tg.ReactionTypeEmoji{Emoji: "🤷‍♂"}},
+		}); tgerr != nil {
+			LogTg.Error("tg.SetMessageReaction", "update_id", u.UpdateId, "chat_id", m.Chat.Id, "reaction", "🤷‍♂", "err", tgerr)
 		}
+		return err
 	}
+	MetricsUpdates.WithLabelValues("ok").Inc()
 
 	return nil
-
 }
 
-func processTgUpdate(u tg.Update, tgupdatesjson string) (m tg.Message, err error) {
+func processTgUpdate(u tg.Update, tgupdatejson string) (m tg.Message, err error) {
 
 	var ischannelpost bool
 
@@ -464,31 +875,25 @@ func processTgUpdate(u tg.Update, tgupdatesjson string) (m tg.Message, err error
 		ischannelpost = true
 	} else if u.MyChatMember.Date != 0 {
 
-		cmu := u.MyChatMember
-		reporttext := tg.Bold("MyChatMember") + NL +
-			tg.Bold("from") + " " + tg.Italic(tg.F("%s %s", cmu.From.FirstName, cmu.From.LastName)) + " " + tg.Esc(tg.F("username @%s", cmu.From.Username)) + " " + tg.Esc("id ") + tg.Code(tg.F("%d", cmu.From.Id)) + " " + tg.Link("profile", fmt.Sprintf("tg://user?id=%d", cmu.From.Id)) + NL +
-			tg.Bold("chat") + " " + tg.Esc("id ") + tg.Code(tg.F("%d", cmu.Chat.Id)) + " " + tg.Esc(tg.F("username @%s", cmu.Chat.Username)) + " " + tg.Esc(tg.F("type %s", cmu.Chat.Type)) + " " + tg.Esc(tg.F("title %s", cmu.Chat.Title)) + NL +
-			tg.Bold("old member") + " " + tg.Esc(tg.F("username @%s", cmu.OldChatMember.User.Username)) + tg.Esc(" id ") + tg.Code(tg.F("%d", cmu.OldChatMember.User.Id)) + " " + tg.Esc(tg.F("status %s", cmu.OldChatMember.Status)) + NL +
-			tg.Bold("new member") + " " + tg.Esc(tg.F("username @%s", cmu.NewChatMember.User.Username)) + " " + tg.Esc(" id ") + tg.Code(tg.F("%d", cmu.NewChatMember.User.Id)) + " " + tg.Esc(tg.F("status %s", cmu.NewChatMember.Status))
-		if _, err := tg.SendMessage(tg.SendMessageRequest{
-			ChatId: fmt.Sprintf("%d", Config.TgZeChatId),
-			Text:   reporttext,
-		}); err != nil {
-			perr(F("WARNING tg.SendMessage %v", err))
-			return m, err
+		if err := processTgMyChatMember(u.MyChatMember); err != nil {
+			return m, fmt.Errorf("processTgMyChatMember %w", err)
 		}
 		return m, nil
 
+	} else if cq, ok := tgUpdateCallbackQuery(tgupdatejson); ok {
+
+		return cq.Message, processTgCallbackQuery(cq)
+
 	} else {
 
-		perr(F("WARNING unsupported type of update id <%d> received", u.UpdateId) + NL + tgupdatesjson)
+		LogTg.Warn("unsupported type of update", "update_id", u.UpdateId, "update", tgupdatejson)
 		if _, err := tg.SendMessage(tg.SendMessageRequest{
-			ChatId: fmt.Sprintf("%d", Config.TgZeChatId),
+			ChatId: fmt.Sprintf("%d", ConfigNow().TgZeChatId),
 			Text: tg.Esc(tg.F(
 				"unsupported type of update id <%d> received", u.UpdateId,
-			)) + NL + tg.Pre(tgupdatesjson),
+			)) + NL + tg.Pre(tgupdatejson),
 		}); err != nil {
-			perr(F("WARNING tg.SendMessage %v", err))
+			LogTg.Warn("tg.SendMessage", "chat_id", m.Chat.Id, "err", err)
 			return m, err
 		}
 		return m, nil
@@ -500,53 +905,45 @@ func processTgUpdate(u tg.Update, tgupdatesjson string) (m tg.Message, err error
 	}
 
 	if ischannelpost {
-		savechannel := true
-		for _, i := range Config.TgAllChannelsChatIds {
-			if m.Chat.Id == i {
-				savechannel = false
-			}
+		if err := ChannelsRegister(m.Chat.Id); err != nil {
+			return m, fmt.Errorf("ChannelsRegister %w", err)
 		}
-		if savechannel {
-			Config.TgAllChannelsChatIds = append(Config.TgAllChannelsChatIds, m.Chat.Id)
-			slices.Sort(Config.TgAllChannelsChatIds)
-			if err := Config.Put(); err != nil {
-				perr(F("ERROR Config.Put %v", err))
-				return m, err
-			}
+	}
+
+	if from, to, ok := tgUpdateMigrate(tgupdatejson); ok {
+		if err := ChannelsMigrate(from, to); err != nil {
+			return m, fmt.Errorf("ChannelsMigrate %w", err)
 		}
+		return m, nil
 	}
 
-	perr(F("Update @Message" + SP + strings.ReplaceAll(F("%+v", m), NL, "<NL>")))
+	LogTg.Debug("update message", "chat_id", m.Chat.Id, "message", strings.ReplaceAll(F("%+v", m), NL, "<NL>"))
 
-	if !strings.Contains(m.Text, NL) {
-		perr(F("Message from [%s] chat [%s] title [%s] text [%s]", m.From.Username, m.Chat.Username, m.Chat.Title, m.Text))
-	} else {
-		perr(F("Message from [%s] chat [%s] title [%s] text [>"+NL+"%s"+NL+"]", m.From.Username, m.Chat.Username, m.Chat.Title, m.Text))
-	}
+	LogTg.Info("message", "chat_id", m.Chat.Id, "message_id", m.MessageId, "from", m.From.Username, "chat", m.Chat.Username, "title", m.Chat.Title, "text", m.Text)
 
 	if m.Text == "" {
 		return m, nil
 	}
 
 	shouldreport := true
-	if m.From.Id == Config.TgZeChatId {
+	if m.From.Id == ConfigNow().TgZeChatId {
 		shouldreport = false
 	}
 	var chatadmins string
 	if aa, err := tg.GetChatAdministrators(m.Chat.Id); err != nil {
-		perr(F("ERROR tg.GetChatAdministrators %v", err))
+		LogTg.Error("tg.GetChatAdministrators", "chat_id", m.Chat.Id, "err", err)
 		return m, err
 	} else {
 		for _, a := range aa {
 			chatadmins += fmt.Sprintf("username=@%s id=%d status=%s  ", a.User.Username, a.User.Id, a.Status)
-			if a.User.Id == Config.TgZeChatId {
+			if a.User.Id == ConfigNow().TgZeChatId {
 				shouldreport = false
 			}
 		}
 	}
 	if shouldreport && m.MessageId != 0 {
 		if _, err := tg.SendMessage(tg.SendMessageRequest{
-			ChatId: fmt.Sprintf("%d", Config.TgZeChatId),
+			ChatId: fmt.Sprintf("%d", ConfigNow().TgZeChatId),
 			Text: tg.Bold("Message") + NL +
 				tg.Bold("from:") + " " + tg.Italic(tg.F("%s %s", m.From.FirstName, m.From.LastName)) + " " + tg.Esc(tg.F("username==@%s", m.From.Username)) + " " + tg.Esc("id==") + tg.Code(tg.F("%d", m.From.Id)) + " " + tg.Link("profile", fmt.Sprintf("tg://user?id=%d", m.From.Id)) + NL +
 				tg.Bold("chat:") + " " + tg.Esc(tg.F("username=@%s id=%d type=%s title=%s", m.Chat.Username, m.Chat.Id, m.Chat.Type, m.Chat.Title)) + NL +
@@ -554,7 +951,7 @@ func processTgUpdate(u tg.Update, tgupdatesjson string) (m tg.Message, err error
 				tg.Bold("text:") + NL +
 				tg.Code(m.Text),
 		}); err != nil {
-			perr(F("WARNING tg.SendMessage %v", err))
+			LogTg.Warn("tg.SendMessage", "chat_id", m.Chat.Id, "err", err)
 			return m, err
 		}
 	}
@@ -570,7 +967,7 @@ func processTgUpdate(u tg.Update, tgupdatesjson string) (m tg.Message, err error
 				tg.Bold("user id: ") + tg.Code(tg.F("%d", m.From.Id)) + NL +
 				tg.Bold("chat id: ") + tg.Code(tg.F("%d", m.Chat.Id)),
 		}); tgerr != nil {
-			perr(F("ERROR tg.SendMessage %v", tgerr))
+			LogTg.Error("tg.SendMessage", "chat_id", m.Chat.Id, "err", tgerr)
 			return m, tgerr
 		}
 		return m, nil
@@ -585,7 +982,7 @@ func processTgUpdate(u tg.Update, tgupdatesjson string) (m tg.Message, err error
 				ReplyToMessageId: m.MessageId,
 				Text:             tg.Esc(tg.F("ERROR %v", err)),
 			}); tgerr != nil {
-				perr(F("ERROR tg.SendMessage %v", tgerr))
+				LogTg.Error("tg.SendMessage", "chat_id", m.Chat.Id, "err", tgerr)
 				return m, tgerr
 			}
 		} else {
@@ -594,83 +991,107 @@ func processTgUpdate(u tg.Update, tgupdatesjson string) (m tg.Message, err error
 				ReplyToMessageId: m.MessageId,
 				Text:             tg.Link("user profile", fmt.Sprintf("tg://user?id=%d", userid)),
 			}); tgerr != nil {
-				perr(F("ERROR tg.SendMessage %v", tgerr))
+				LogTg.Error("tg.SendMessage", "chat_id", m.Chat.Id, "err", tgerr)
 				return m, tgerr
 			}
 		}
 
 	}
 
-	if strings.TrimSpace(m.Text) == Config.TgCommandChannels {
+	if len(mtff) == 1 && strings.SplitN(mtff[0], "@", 2)[0] == ConfigNow().TgCommandChannels {
 
-		var channelstotal, channelsremoved int
-		channelstotal = len(Config.TgAllChannelsChatIds)
-		for _, chatid := range Config.TgAllChannelsChatIds {
-			if c, tgerr := tg.GetChat(chatid); tgerr != nil {
-				if strings.Contains(tgerr.Error(), "Bad Request: chat not found") {
-					// TODO remove the channel
-					channelsremoved += 1
-					continue
-				}
-				if _, tgerr2 := tg.SendMessage(tg.SendMessageRequest{
-					ChatId: fmt.Sprintf("%d", m.Chat.Id),
-					Text: tg.Esc(tg.F(
-						"id <%d> err [%v]", chatid, tgerr,
-					)),
-				}); tgerr2 != nil {
-					perr(F("ERROR tg.SendMessage %v", tgerr2))
-					return m, tgerr2
-				}
-				return m, tgerr
-			} else {
-				chatinfo := tg.Esc(c.Title) + NL
-				if c.Username != "" {
-					chatinfo += tg.Esc(tg.F(
-						"https://t.me/%s", c.Username,
-					))
-				} else if c.InviteLink != "" {
-					chatinfo += tg.Esc(c.InviteLink)
-				}
-				if _, tgerr := tg.SendMessage(tg.SendMessageRequest{
-					ChatId: fmt.Sprintf("%d", m.Chat.Id),
-					Text:   chatinfo,
-				}); tgerr != nil {
-					perr(F("ERROR tg.SendMessage %v", tgerr))
-					return m, tgerr
-				}
-			}
-		}
-		tgtext := tg.Esc(tg.F(
-			"channels count <%d>", channelstotal,
-		)) + NL
-		if channelsremoved > 0 {
-			tgtext += tg.Esc(tg.F(
-				"channels removed count <%d>", channelsremoved,
-			))
+		if err := processTgChannelsCommand(m); err != nil {
+			return m, fmt.Errorf("processTgChannelsCommand %w", err)
 		}
+		return m, nil
+
+	}
+
+	if len(mtff) == 1 && mtff[0] == ConfigNow().TgCommandStats && m.Chat.Id == ConfigNow().TgZeChatId {
+
 		if _, tgerr := tg.SendMessage(tg.SendMessageRequest{
 			ChatId:           fmt.Sprintf("%d", m.Chat.Id),
 			ReplyToMessageId: m.MessageId,
-			Text:             tgtext,
-		}); err != nil {
-			perr(F("ERROR tg.SendMessage %v", tgerr))
+			Text:             tg.Esc(TgFileCacheStats()),
+		}); tgerr != nil {
+			LogTg.Error("tg.SendMessage", "chat_id", m.Chat.Id, "err", tgerr)
 			return m, tgerr
 		}
 		return m, nil
 
 	}
 
-	if strings.TrimSpace(m.Text) == Config.TgCommandChannelsPromoteAdmin {
+	if len(mtff) == 1 && strings.SplitN(mtff[0], "@", 2)[0] == ConfigNow().TgCommandSettings {
+
+		if err := processTgSettingsCommand(m); err != nil {
+			return m, fmt.Errorf("processTgSettingsCommand %w", err)
+		}
+		return m, nil
+
+	}
+
+	if len(mtff) >= 1 && len(mtff) <= 4 && slices.Contains([]string{ConfigNow().TgCommandAllow, ConfigNow().TgCommandDeny, ConfigNow().TgCommandQuota, ConfigNow().TgCommandAccess}, strings.SplitN(mtff[0], "@", 2)[0]) {
+
+		if err := processTgAccessCommand(m, mtff); err != nil {
+			return m, fmt.Errorf("processTgAccessCommand %w", err)
+		}
+		return m, nil
+
+	}
+
+	if len(mtff) >= 1 && len(mtff) <= 2 && slices.Contains([]string{ConfigNow().TgCommandSubscribe, ConfigNow().TgCommandUnsubscribe}, strings.SplitN(mtff[0], "@", 2)[0]) {
+
+		if refused, err := tgAccessRefuse(m, 0); err != nil || refused {
+			return m, err
+		}
+		if err := processTgSubscribeCommand(m, mtff); err != nil {
+			return m, fmt.Errorf("processTgSubscribeCommand %w", err)
+		}
+		return m, nil
+
+	}
+
+	if len(mtff) >= 1 && len(mtff) <= 3 && slices.Contains([]string{ConfigNow().TgCommandWatch, ConfigNow().TgCommandUnwatch}, strings.SplitN(mtff[0], "@", 2)[0]) {
+
+		if refused, err := tgAccessRefuse(m, 0); err != nil || refused {
+			return m, err
+		}
+		if err := processTgWatchCommand(m, mtff); err != nil {
+			return m, fmt.Errorf("processTgWatchCommand %w", err)
+		}
+		return m, nil
+
+	}
+
+	if len(mtff) == 1 && strings.SplitN(mtff[0], "@", 2)[0] == ConfigNow().TgCommandQueue {
+
+		if err := processTgQueueCommand(m); err != nil {
+			return m, fmt.Errorf("processTgQueueCommand %w", err)
+		}
+		return m, nil
+
+	}
+
+	if len(mtff) >= 1 && len(mtff) <= 2 && strings.SplitN(mtff[0], "@", 2)[0] == ConfigNow().TgCommandCancel {
+
+		if err := processTgCancelCommand(m, mtff); err != nil {
+			return m, fmt.Errorf("processTgCancelCommand %w", err)
+		}
+		return m, nil
+
+	}
+
+	if strings.TrimSpace(m.Text) == ConfigNow().TgCommandChannelsPromoteAdmin {
 
 		var total, totalok int
-		for _, i := range Config.TgAllChannelsChatIds {
+		for _, i := range ChannelsGet() {
 			success, err := tg.PromoteChatMember(fmt.Sprintf("%d", i), fmt.Sprintf("%d", m.From.Id))
 			total++
 			if success != true || err != nil {
-				perr(F("ERROR tgpromoteChatMember chat id <%d> user id <%d> %v", i, m.From.Id, err))
+				LogTg.Error("tg.PromoteChatMember", "chat_id", i, "user_id", m.From.Id, "err", err)
 			} else {
 				totalok++
-				perr(F("tgpromoteChatMember chat id <%d> user id <%d> ok", i, m.From.Id))
+				LogTg.Info("tg.PromoteChatMember ok", "chat_id", i, "user_id", m.From.Id)
 			}
 		}
 		if _, tgerr := tg.SendMessage(tg.SendMessageRequest{
@@ -680,47 +1101,47 @@ func processTgUpdate(u tg.Update, tgupdatesjson string) (m tg.Message, err error
 				"ok for <%d> of total <%d> channels.", totalok, total,
 			)),
 		}); tgerr != nil {
-			perr(F("ERROR tg.SendMessage %v", tgerr))
+			LogTg.Error("tg.SendMessage", "chat_id", m.Chat.Id, "err", tgerr)
 			return m, tgerr
 		}
 		return m, nil
 
 	}
 
-	if strings.TrimSpace(m.Text) == Config.TgQuest1 {
+	if strings.TrimSpace(m.Text) == ConfigNow().TgQuest1 {
 		if _, tgerr := tg.SendMessage(tg.SendMessageRequest{
 			ChatId: fmt.Sprintf("%d", m.Chat.Id),
-			Text:   tg.Code(Config.TgQuest1Key),
+			Text:   tg.Code(ConfigNow().TgQuest1Key),
 		}); tgerr != nil {
-			perr(F("ERROR tg.SendMessage %v", tgerr))
+			LogTg.Error("tg.SendMessage", "chat_id", m.Chat.Id, "err", tgerr)
 			return m, tgerr
 		}
 		return m, nil
 	}
-	if strings.TrimSpace(m.Text) == Config.TgQuest2 {
+	if strings.TrimSpace(m.Text) == ConfigNow().TgQuest2 {
 		if _, tgerr := tg.SendMessage(tg.SendMessageRequest{
 			ChatId: fmt.Sprintf("%d", m.Chat.Id),
-			Text:   tg.Code(Config.TgQuest2Key),
+			Text:   tg.Code(ConfigNow().TgQuest2Key),
 		}); tgerr != nil {
-			perr(F("ERROR tg.SendMessage %v", tgerr))
+			LogTg.Error("tg.SendMessage", "chat_id", m.Chat.Id, "err", tgerr)
 			return m, tgerr
 		}
 		return m, nil
 	}
-	if strings.TrimSpace(m.Text) == Config.TgQuest3 {
+	if strings.TrimSpace(m.Text) == ConfigNow().TgQuest3 {
 		if _, tgerr := tg.SendMessage(tg.SendMessageRequest{
 			ChatId: fmt.Sprintf("%d", m.Chat.Id),
-			Text:   tg.Code(Config.TgQuest3Key),
+			Text:   tg.Code(ConfigNow().TgQuest3Key),
 		}); tgerr != nil {
-			perr(F("ERROR tg.SendMessage %v", tgerr))
+			LogTg.Error("tg.SendMessage", "chat_id", m.Chat.Id, "err", tgerr)
 			return m, tgerr
 		}
 		return m, nil
 	}
 
-	if strings.TrimSpace(m.Text) == Config.TgCommandAudioCompress {
+	if strings.TrimSpace(m.Text) == ConfigNow().TgCommandAudioCompress {
 
-		perr(F("TgCommandAudioCompress @ReplyToMessage %#v", m.ReplyToMessage))
+		LogTg.Debug("audio compress reply to message", "chat_id", m.Chat.Id, "reply_to_message", F("%#v", m.ReplyToMessage))
 
 		if m.ReplyToMessage == nil || m.ReplyToMessage.MessageId == 0 {
 			if _, tgerr := tg.SendMessage(tg.SendMessageRequest{
@@ -728,350 +1149,382 @@ func processTgUpdate(u tg.Update, tgupdatesjson string) (m tg.Message, err error
 				ReplyToMessageId: m.MessageId,
 				Text:             tg.Esc("ERROR @ReplyToMessage <nil> or @MessageId <0>"),
 			}); tgerr != nil {
-				perr(F("ERROR tg.SendMessage %v", tgerr))
+				LogTg.Error("tg.SendMessage", "chat_id", m.Chat.Id, "err", tgerr)
 				return m, tgerr
 			}
 			return m, nil
 		}
 
-		perr(F("TgCommandAudioCompress @ReplyToMessage { @Caption [%s] @Audio { @FileId [%s] @FileSize <%d> @MimeType [%s] @Duration <%d> @Performer [%s] @Title [%s] @Thumb { @FileId [%s] } } }",
-			strings.ReplaceAll(m.ReplyToMessage.Caption, NL, "<NL>"),
-			m.ReplyToMessage.Audio.FileId,
-			m.ReplyToMessage.Audio.FileSize,
-			m.ReplyToMessage.Audio.MimeType,
-			m.ReplyToMessage.Audio.Duration,
-			m.ReplyToMessage.Audio.Performer,
-			m.ReplyToMessage.Audio.Title,
-			m.ReplyToMessage.Audio.Thumb.FileId,
-		))
-		/*
-			if _, tgerr := tg.SendMessage(tg.SendMessageRequest{
-				ChatId:           fmt.Sprintf("%d", m.Chat.Id),
-				ReplyToMessageId: m.MessageId,
-				Text: tg.Esc(tg.F(
-					"@ReplyToMessage { @Caption [%s] @Audio { @FileId [%s] @FileSize <%d> @MimeType [%s] @Duration <%d> @Performer [%s] @Title [%s] @Thumb { @FileId [%s] } } }",
-					strings.ReplaceAll(m.ReplyToMessage.Caption, NL, "<NL>"),
-					m.ReplyToMessage.Audio.FileId,
-					m.ReplyToMessage.Audio.FileSize,
-					m.ReplyToMessage.Audio.MimeType,
-					m.ReplyToMessage.Audio.Duration,
-					m.ReplyToMessage.Audio.Performer,
-					m.ReplyToMessage.Audio.Title,
-					m.ReplyToMessage.Audio.Thumb.FileId,
-				)),
-			}); tgerr != nil {
-				perr(F("ERROR tg.SendMessage %v", tgerr))
-				return m, tgerr
-			}
-		*/
+		if refused, err := tgAccessRefuse(m, 1); err != nil || refused {
+			return m, err
+		}
 
-		tgaudiofile, err := tg.GetFile(m.ReplyToMessage.Audio.FileId)
+		Jobs.Put(&Job{
+			UpdateId:      u.UpdateId,
+			Message:       m,
+			AudioCompress: true,
+		})
 
-		if err != nil {
-			if _, tgerr := tg.SendMessage(tg.SendMessageRequest{
-				ChatId:           fmt.Sprintf("%d", m.Chat.Id),
-				ReplyToMessageId: m.MessageId,
-				Text: tg.Esc(tg.F(
-					"ERROR GetFile %v", err,
-				)),
-			}); tgerr != nil {
-				perr(F("ERROR tg.SendMessage %v", tgerr))
-				return m, tgerr
-			}
-			return m, err
+		return m, nil
+
+	}
+
+	ytreq, ok := parseYtRequest(mtff)
+	if !ok {
+		return m, nil
+	}
+
+	if ytreq.Language != "" && ConfigNow().DssUrl != "" {
+		return m, tgReply(m, F("sorry, the language [%s] can not be picked, videos are downloaded by dss with its own audio track", ytreq.Language))
+	}
+
+	if refused, err := tgAccessRefuse(m, 1); err != nil || refused {
+		return m, err
+	}
+
+	if m.Text != ytreq.Text() {
+		if _, tgerr := tg.EditMessageText(tg.EditMessageTextRequest{
+			ChatId:    fmt.Sprintf("%d", m.Chat.Id),
+			MessageId: m.MessageId,
+			Text:      tg.Esc(ytreq.Text()),
+		}); tgerr != nil {
+			LogTg.Error("tg.EditMessageText", "chat_id", m.Chat.Id, "err", tgerr)
 		}
+	}
 
-		/*
-			if _, tgerr := tg.SendMessage(tg.SendMessageRequest{
-				ChatId:           fmt.Sprintf("%d", m.Chat.Id),
-				ReplyToMessageId: m.MessageId,
-				Text: tg.Esc(tg.F(
-					"@File { @FileSize <%d> @FilePath [%s] }",
-					tgaudiofile.FileSize, tgaudiofile.FilePath,
-				)) + SP + tg.Esc(tg.F(
-					"exists <%t>", fileExists(tgaudiofile.FilePath),
-				)),
-			}); tgerr != nil {
-				perr(F("ERROR tg.SendMessage %v", tgerr))
-				return m, tgerr
-			}
-		*/
+	chatsettings := ChatSettingsGet(m.Chat.Id)
+	media := chatsettings.Media(m.Chat)
+	language := chatsettings.Language
+	if ytreq.Language != "" {
+		language = ytreq.Language
+	}
 
-		perr(F("TgCommandAudioCompress tgaudiofile %v", tgaudiofile))
-		if tgaudiofile.FileSize == 0 || tgaudiofile.FilePath == "" || !fileExists(tgaudiofile.FilePath) {
-			if _, tgerr := tg.SendMessage(tg.SendMessageRequest{
-				ChatId:           fmt.Sprintf("%d", m.Chat.Id),
-				ReplyToMessageId: m.MessageId,
-				Text: tg.Esc(tg.F(
-					"path [%s] file not available", tgaudiofile.FilePath,
-				)),
-			}); tgerr != nil {
-				perr(F("ERROR tg.SendMessage %v", tgerr))
-				return m, tgerr
-			}
+	var ytlist *YtList
+	if ytreq.ListId != "" {
+		var err error
+		ytlist, err = getList(Ctx, ytreq.ListId, ytreq.ListFrom, ytreq.ListTo)
+		if err != nil && ytreq.VideoId != "" {
+			// lists like mixes are not served by the data api so just the video of the link is posted
+			LogYt.Warn("getList", "chat_id", m.Chat.Id, "video_id", ytreq.VideoId, "list_id", ytreq.ListId, "err", err)
+			ytreq.ListId = ""
+		} else if err != nil {
+			return m, fmt.Errorf("getList %w", err)
 		}
+	}
 
-		filepath2 := tgaudiofile.FilePath + ".audio.compress..m4a"
-		perr(F("TgCommandAudioCompress filepath2 [%s]", filepath2))
-
-		if fileExists(filepath2) {
-
-			perr(F("TgCommandAudioCompress filepath2 [%s] exists", filepath2))
-			/*
-				filepath2stat, err := os.Stat(filepath2)
-				if err != nil {
-					if _, tgerr := tg.SendMessage(tg.SendMessageRequest{
-						ChatId:           fmt.Sprintf("%d", m.Chat.Id),
-						ReplyToMessageId: m.MessageId,
-						Text:             tg.Esc(tg.F(
-							"ERROR os.Stat [%s] %v", filepath2, err,
-						)),
-					}); tgerr != nil {
-						perr(F("ERROR tg.SendMessage %v", tgerr))
-						return m, tgerr
-					}
-					return m, err
-				}
-					if _, tgerr := tg.SendMessage(tg.SendMessageRequest{
-						ChatId:           fmt.Sprintf("%d", m.Chat.Id),
-						ReplyToMessageId: m.MessageId,
-						Text: tg.Esc(tg.F(
-							"file [%s] size <%d> already exists", filepath2, filepath2stat.Size(),
-						)),
-					}); tgerr != nil {
-						perr(F("ERROR tg.SendMessage %v", tgerr))
-						return m, tgerr
-					}
-			*/
+	if replied, err := replyPosted(m, ytreq, media, language); err != nil {
+		return m, fmt.Errorf("replyPosted %w", err)
+	} else if replied {
+		return m, nil
+	}
 
-		} else {
+	if tgerr := tg.SetMessageReaction(tg.SetMessageReactionRequest{
+		ChatId:    fmt.Sprintf("%d", m.Chat.Id),
+		MessageId: m.MessageId,
+		Reaction:  []tg.ReactionTypeEmoji{tg.ReactionTypeEmoji{Emoji: "👾"}},
+	}); tgerr != nil {
+		LogTg.Error("tg.SetMessageReaction", "chat_id", m.Chat.Id, "reaction", "👾", "err", tgerr)
+	}
 
-			perr(F("TgCommandAudioCompress starting audio compression with filter [%s]", Config.FfmpegAudioCompressFilter))
+	if ytreq.ListId != "" {
+
+		// private and deleted videos can not be downloaded so they are not queued or charged
+		var unavailable int
+		ytlist.Videos = slices.DeleteFunc(ytlist.Videos, func(v YtVideo) bool {
+			if v.Unavailable {
+				unavailable++
+			}
+			return v.Unavailable
+		})
 
+		if len(ytlist.Videos) == 0 {
+			text := tg.F("no videos in list of <%d> videos at requested positions", ytlist.Size)
+			if unavailable > 0 {
+				text += tg.F(", <%d> unavailable", unavailable)
+			}
 			if _, tgerr := tg.SendMessage(tg.SendMessageRequest{
 				ChatId:           fmt.Sprintf("%d", m.Chat.Id),
 				ReplyToMessageId: m.MessageId,
-				Text: tg.Esc(tg.F(
-					"starting audio compression with filter [%s]", Config.FfmpegAudioCompressFilter,
-				)),
+				Text:             tg.Esc(text),
 			}); tgerr != nil {
-				perr(F("ERROR tg.SendMessage %v", tgerr))
+				LogTg.Error("tg.SendMessage", "chat_id", m.Chat.Id, "err", tgerr)
 				return m, tgerr
 			}
+			return m, nil
+		}
 
-			err := FfmpegAudioCompress(tgaudiofile.FilePath, filepath2)
-			if err != nil {
-				if _, tgerr := tg.SendMessage(tg.SendMessageRequest{
-					ChatId:           fmt.Sprintf("%d", m.Chat.Id),
-					ReplyToMessageId: m.MessageId,
-					Text: tg.Esc(tg.F(
-						"ERROR FfmpegAudioCompress %v", err,
-					)),
-				}); tgerr != nil {
-					perr(F("ERROR tg.SendMessage %v", tgerr))
-					return m, tgerr
-				}
-				return m, err
-			}
-
-			filepath2stat, err := os.Stat(filepath2)
-			if err != nil {
-				perr(F("TgCommandAudioCompress ERROR os.Stat [%s] %v", filepath2, err))
-				return m, err
-			}
-
-			/*
-				if err != nil {
-					if _, tgerr := tg.SendMessage(tg.SendMessageRequest{
-						ChatId:           fmt.Sprintf("%d", m.Chat.Id),
-						ReplyToMessageId: m.MessageId,
-						Text:             tg.Esc(tg.F(
-							"ERROR os.Stat [%s] %v", filepath2, err,
-						)),
-					}); tgerr != nil {
-						perr(F("ERROR tg.SendMessage %v", tgerr))
-						return m, tgerr
-					}
-					return m, err
-				}
-			*/
-
-			perr(F("TgCommandAudioCompress finished audio compression into [%s] size <%d>", filepath2, filepath2stat.Size()))
-
-			/*
-				if _, tgerr := tg.SendMessage(tg.SendMessageRequest{
-					ChatId:           fmt.Sprintf("%d", m.Chat.Id),
-					ReplyToMessageId: m.MessageId,
-					Text: tg.Esc(tg.F(
-						"finished audio compression into [%s] size <%d>", filepath2, filepath2stat.Size(),
-					)),
-				}); tgerr != nil {
-					perr(F("ERROR tg.SendMessage %v", tgerr))
-					return m, tgerr
-				}
-			*/
+		if refused, err := tgAccessRefuse(m, int64(len(ytlist.Videos))); err != nil || refused {
+			return m, err
+		}
 
+		ytlistcaption := tg.Bold(tg.Esc(ytlist.Title)) + NL + tg.Italic(tg.Esc(tg.F("<%d> videos", len(ytlist.Videos))))
+		if int64(len(ytlist.Videos)) < ytlist.Size {
+			ytlistcaption += SP + tg.Italic(tg.Esc(tg.F(
+				"from <%d> to <%d> of <%d>",
+				ytlist.Videos[0].PlaylistIndex+1, ytlist.Videos[len(ytlist.Videos)-1].PlaylistIndex+1, ytlist.Size,
+			)))
 		}
+		if unavailable > 0 {
+			ytlistcaption += NL + tg.Italic(tg.Esc(tg.F("<%d> private or deleted videos skipped", unavailable)))
+		}
+		ytlistcaption += NL + tg.Link(ytreq.ListId, "https://"+F("youtube.com/playlist?list=%s", ytreq.ListId))
 
-		tgaudioReader, err := os.Open(filepath2)
-		if err != nil {
-			perr(F("TgCommandAudioCompress ERROR os.Open [%s] %v", filepath2, err))
-			return m, err
+		if _, tgerr := tg.SendPhoto(tg.SendPhotoRequest{
+			ChatId:  fmt.Sprintf("%d", m.Chat.Id),
+			Photo:   ytlist.ThumbUrl,
+			Caption: ytlistcaption,
+		}); tgerr != nil {
+			LogTg.Error("tg.SendPhoto", "chat_id", m.Chat.Id, "err", tgerr)
 		}
-		defer tgaudioReader.Close()
 
-		if _, err := tg.SendAudioFile(tg.SendAudioFileRequest{
-			ChatId:    fmt.Sprintf("%d", m.Chat.Id),
-			Caption:   m.ReplyToMessage.Caption + SP + "audio-compressed",
-			Performer: m.ReplyToMessage.Audio.Performer,
-			Title:     m.ReplyToMessage.Audio.Title,
-			Duration:  time.Duration(m.ReplyToMessage.Audio.Duration) * time.Second, // TODO Audio.Duration time.Duration
-			Audio:     tgaudioReader,
-		}); err != nil {
-			return m, err
+		ytlistopts := chatsettings.PostOptions()
+		ytlistopts.Chapters = ytreq.Chapters
+		if ytreq.Language != "" {
+			ytlistopts.Language = ytreq.Language
 		}
 
-		err = tgaudioReader.Close()
-		if err != nil {
-			perr(F("ERROR os.File.Close %v", err))
+		if err := YtListJobPut(u.UpdateId, m, ytlist, ytreq.ListTo, media, ytlistopts); err != nil {
+			return m, fmt.Errorf("YtListJobPut %w", err)
 		}
-		/*
-			err := os.Remove(filepath2)
-			if err != nil {
-				perr(F("ERROR os.Remove %v", err))
-			}
-		*/
 
-		return m, nil
+		for _, v := range ytlist.Videos {
+			Jobs.Put(&Job{
+				UpdateId: u.UpdateId,
+				Message:  m,
+				Video:    v,
+				List:     ytlist,
+				Media:    media,
+				Options:  ytlistopts,
+			})
+		}
 
 	}
 
-	if len(mtff) != 1 {
-		return m, nil
-	}
+	if ytreq.VideoId != "" && ytreq.ListId == "" {
 
-	var ytid, ytlistid string
-	if ssm := YtListRe.FindStringSubmatch(m.Text); len(ssm) > 1 {
-		ytlistid = ssm[1]
-	} else if ssm := YtRe.FindStringSubmatch(m.Text); len(ssm) > 1 {
-		ytid = ssm[1]
-	}
+		opts := chatsettings.PostOptions()
+		opts.ClipStart, opts.ClipEnd = ytreq.ClipStart, ytreq.ClipEnd
+		opts.Chapters = ytreq.Chapters
+		if ytreq.Language != "" {
+			opts.Language = ytreq.Language
+		}
+
+		Jobs.Put(&Job{
+			UpdateId:      u.UpdateId,
+			Message:       m,
+			Video:         YtVideo{Id: ytreq.VideoId},
+			Media:         media,
+			DeleteMessage: ischannelpost,
+			Options:       opts,
+		})
 
-	if ytid == "" && ytlistid == "" {
-		return m, nil
 	}
 
-	ytlisturl := F("youtube.com/playlist?list=%s", ytlistid)
-	yturl := F("youtu.be/%s", ytid)
+	return m, nil
+}
 
-	if ytlistid != "" && m.Text != ytlisturl {
-		if _, tgerr := tg.EditMessageText(tg.EditMessageTextRequest{
-			ChatId:    fmt.Sprintf("%d", m.Chat.Id),
-			MessageId: m.MessageId,
-			Text:      tg.Esc(ytlisturl),
+// postAudioCompress compresses the audio of the message the job message replies to and sends it to the chat
+func postAudioCompress(ctx context.Context, m tg.Message) error {
+
+	LogTg.Info("audio compress", "chat_id", m.Chat.Id,
+		"caption", strings.ReplaceAll(m.ReplyToMessage.Caption, NL, "<NL>"),
+		"file_id", m.ReplyToMessage.Audio.FileId,
+		"file_size", m.ReplyToMessage.Audio.FileSize,
+		"mime_type", m.ReplyToMessage.Audio.MimeType,
+		"duration", m.ReplyToMessage.Audio.Duration,
+		"performer", m.ReplyToMessage.Audio.Performer,
+		"title", m.ReplyToMessage.Audio.Title,
+		"thumb_file_id", m.ReplyToMessage.Audio.Thumb.FileId,
+	)
+	/*
+		if _, tgerr := tg.SendMessage(tg.SendMessageRequest{
+			ChatId:           fmt.Sprintf("%d", m.Chat.Id),
+			ReplyToMessageId: m.MessageId,
+			Text: tg.Esc(tg.F(
+				"@ReplyToMessage { @Caption [%s] @Audio { @FileId [%s] @FileSize <%d> @MimeType [%s] @Duration <%d> @Performer [%s] @Title [%s] @Thumb { @FileId [%s] } } }",
+				strings.ReplaceAll(m.ReplyToMessage.Caption, NL, "<NL>"),
+				m.ReplyToMessage.Audio.FileId,
+				m.ReplyToMessage.Audio.FileSize,
+				m.ReplyToMessage.Audio.MimeType,
+				m.ReplyToMessage.Audio.Duration,
+				m.ReplyToMessage.Audio.Performer,
+				m.ReplyToMessage.Audio.Title,
+				m.ReplyToMessage.Audio.Thumb.FileId,
+			)),
 		}); tgerr != nil {
-			perr(F("ERROR tg.EditMessageText %v", tgerr))
+			LogTg.Error("tg.SendMessage", "chat_id", m.Chat.Id, "err", tgerr)
+			return tgerr
 		}
-	}
+	*/
 
-	if ytid != "" && m.Text != yturl {
-		if _, tgerr := tg.EditMessageText(tg.EditMessageTextRequest{
-			ChatId:    fmt.Sprintf("%d", m.Chat.Id),
-			MessageId: m.MessageId,
-			Text:      tg.Esc(yturl),
+	tgaudiofile, err := tg.GetFile(m.ReplyToMessage.Audio.FileId)
+
+	if err != nil {
+		if _, tgerr := tg.SendMessage(tg.SendMessageRequest{
+			ChatId:           fmt.Sprintf("%d", m.Chat.Id),
+			ReplyToMessageId: m.MessageId,
+			Text: tg.Esc(tg.F(
+				"ERROR GetFile %v", err,
+			)),
 		}); tgerr != nil {
-			perr(F("ERROR tg.EditMessageText %v", tgerr))
+			LogTg.Error("tg.SendMessage", "chat_id", m.Chat.Id, "err", tgerr)
+			return tgerr
 		}
+		return err
 	}
 
-	if tgerr := tg.SetMessageReaction(tg.SetMessageReactionRequest{
-		ChatId:    fmt.Sprintf("%d", m.Chat.Id),
-		MessageId: m.MessageId,
-		Reaction:  []tg.ReactionTypeEmoji{tg.ReactionTypeEmoji{Emoji: "👾"}},
-	}); tgerr != nil {
-		perr(F("ERROR tg.SetMessageReaction [👾] %v", tgerr))
-	}
+	/*
+		if _, tgerr := tg.SendMessage(tg.SendMessageRequest{
+			ChatId:           fmt.Sprintf("%d", m.Chat.Id),
+			ReplyToMessageId: m.MessageId,
+			Text: tg.Esc(tg.F(
+				"@File { @FileSize <%d> @FilePath [%s] }",
+				tgaudiofile.FileSize, tgaudiofile.FilePath,
+			)) + SP + tg.Esc(tg.F(
+				"exists <%t>", fileExists(tgaudiofile.FilePath),
+			)),
+		}); tgerr != nil {
+			LogTg.Error("tg.SendMessage", "chat_id", m.Chat.Id, "err", tgerr)
+			return tgerr
+		}
+	*/
 
-	var downloadvideo bool
-	if strings.HasPrefix(strings.ToLower(m.Chat.Title), "v") {
-		downloadvideo = true
+	LogTg.Debug("audio compress file", "chat_id", m.Chat.Id, "file", F("%v", tgaudiofile))
+	if tgaudiofile.FileSize == 0 || tgaudiofile.FilePath == "" || !fileExists(tgaudiofile.FilePath) {
+		if _, tgerr := tg.SendMessage(tg.SendMessageRequest{
+			ChatId:           fmt.Sprintf("%d", m.Chat.Id),
+			ReplyToMessageId: m.MessageId,
+			Text: tg.Esc(tg.F(
+				"path [%s] file not available", tgaudiofile.FilePath,
+			)),
+		}); tgerr != nil {
+			LogTg.Error("tg.SendMessage", "chat_id", m.Chat.Id, "err", tgerr)
+			return tgerr
+		}
 	}
 
-	if ytlistid != "" {
+	filepath2 := tgaudiofile.FilePath + ".audio.compress..m4a"
+	LogFfmpeg.Debug("audio compress", "chat_id", m.Chat.Id, "file", filepath2)
+	defer tempRemove(LogWith(ctx, LogFfmpeg), filepath2)
+
+	if fileExists(filepath2) {
+
+		LogFfmpeg.Info("audio compress file exists", "chat_id", m.Chat.Id, "file", filepath2)
+		/*
+			filepath2stat, err := os.Stat(filepath2)
+			if err != nil {
+				if _, tgerr := tg.SendMessage(tg.SendMessageRequest{
+					ChatId:           fmt.Sprintf("%d", m.Chat.Id),
+					ReplyToMessageId: m.MessageId,
+					Text:             tg.Esc(tg.F(
+						"ERROR os.Stat [%s] %v", filepath2, err,
+					)),
+				}); tgerr != nil {
+					LogTg.Error("tg.SendMessage", "chat_id", m.Chat.Id, "err", tgerr)
+					return tgerr
+				}
+				return err
+			}
+				if _, tgerr := tg.SendMessage(tg.SendMessageRequest{
+					ChatId:           fmt.Sprintf("%d", m.Chat.Id),
+					ReplyToMessageId: m.MessageId,
+					Text: tg.Esc(tg.F(
+						"file [%s] size <%d> already exists", filepath2, filepath2stat.Size(),
+					)),
+				}); tgerr != nil {
+					LogTg.Error("tg.SendMessage", "chat_id", m.Chat.Id, "err", tgerr)
+					return tgerr
+				}
+		*/
+
+	} else {
+
+		LogFfmpeg.Info("audio compress started", "chat_id", m.Chat.Id, "file", filepath2, "filter", ConfigNow().FfmpegAudioCompressFilter)
+
+		if _, tgerr := tg.SendMessage(tg.SendMessageRequest{
+			ChatId:           fmt.Sprintf("%d", m.Chat.Id),
+			ReplyToMessageId: m.MessageId,
+			Text: tg.Esc(tg.F(
+				"starting audio compression with filter [%s]", ConfigNow().FfmpegAudioCompressFilter,
+			)),
+		}); tgerr != nil {
+			LogTg.Error("tg.SendMessage", "chat_id", m.Chat.Id, "err", tgerr)
+			return tgerr
+		}
 
-		ytlist, err := getList(ytlistid)
+		err := FfmpegAudioCompress(ctx, tgaudiofile.FilePath, filepath2)
 		if err != nil {
-			return m, fmt.Errorf("getList %w", err)
+			if _, tgerr := tg.SendMessage(tg.SendMessageRequest{
+				ChatId:           fmt.Sprintf("%d", m.Chat.Id),
+				ReplyToMessageId: m.MessageId,
+				Text: tg.Esc(tg.F(
+					"ERROR FfmpegAudioCompress %v", err,
+				)),
+			}); tgerr != nil {
+				LogTg.Error("tg.SendMessage", "chat_id", m.Chat.Id, "err", tgerr)
+				return tgerr
+			}
+			return err
 		}
 
-		if _, tgerr := tg.SendPhoto(tg.SendPhotoRequest{
-			ChatId:  fmt.Sprintf("%d", m.Chat.Id),
-			Photo:   ytlist.ThumbUrl,
-			Caption: tg.Bold(tg.Esc(ytlist.Title)) + NL + tg.Italic(tg.Esc(tg.F("<%d> videos", len(ytlist.Videos)))) + NL + tg.Link(ytlistid, "https://"+ytlisturl),
-		}); tgerr != nil {
-			perr(F("ERROR tg.SendPhoto %v", tgerr))
+		filepath2stat, err := os.Stat(filepath2)
+		if err != nil {
+			LogFfmpeg.Error("audio compress os.Stat", "chat_id", m.Chat.Id, "file", filepath2, "err", err)
+			return err
 		}
 
-		for _, v := range ytlist.Videos {
-			if Config.DssUrl != "" {
-				if downloadvideo {
-					err = postVideoDss(v, ytlist, m)
-				} else {
-					err = postAudioDss(v, ytlist, m)
-				}
-			} else {
-				if downloadvideo {
-					err = postVideo(v, ytlist, m)
-				} else {
-					err = postAudio(v, ytlist, m)
-				}
-			}
+		/*
 			if err != nil {
-				return m, err
-			}
-			if len(ytlist.Videos) > 3 {
-				sleepdur := 7 * time.Second
-				perr(F("DEBUG sleeping <%v>", sleepdur))
-				time.Sleep(sleepdur)
+				if _, tgerr := tg.SendMessage(tg.SendMessageRequest{
+					ChatId:           fmt.Sprintf("%d", m.Chat.Id),
+					ReplyToMessageId: m.MessageId,
+					Text:             tg.Esc(tg.F(
+						"ERROR os.Stat [%s] %v", filepath2, err,
+					)),
+				}); tgerr != nil {
+					LogTg.Error("tg.SendMessage", "chat_id", m.Chat.Id, "err", tgerr)
+					return tgerr
+				}
+				return err
 			}
-		}
-
-	}
+		*/
 
-	if ytid != "" {
+		LogFfmpeg.Info("audio compress finished", "chat_id", m.Chat.Id, "file", filepath2, "size", filepath2stat.Size())
 
-		v := YtVideo{Id: ytid}
-		if Config.DssUrl != "" {
-			if downloadvideo {
-				err = postVideoDss(v, nil, m)
-			} else {
-				err = postAudioDss(v, nil, m)
-			}
-		} else {
-			if downloadvideo {
-				err = postVideo(v, nil, m)
-			} else {
-				err = postAudio(v, nil, m)
+		/*
+			if _, tgerr := tg.SendMessage(tg.SendMessageRequest{
+				ChatId:           fmt.Sprintf("%d", m.Chat.Id),
+				ReplyToMessageId: m.MessageId,
+				Text: tg.Esc(tg.F(
+					"finished audio compression into [%s] size <%d>", filepath2, filepath2stat.Size(),
+				)),
+			}); tgerr != nil {
+				LogTg.Error("tg.SendMessage", "chat_id", m.Chat.Id, "err", tgerr)
+				return tgerr
 			}
-		}
-		if err != nil {
-			return m, err
-		}
+		*/
 
-		if ischannelpost {
-			if err := tg.DeleteMessage(tg.DeleteMessageRequest{
-				ChatId:    fmt.Sprintf("%d", m.Chat.Id),
-				MessageId: m.MessageId,
-			}); err != nil {
-				perr(F("ERROR tg.DeleteMessage %v", err))
-			}
-		}
 	}
 
-	return m, nil
+	if _, err := tgUploadRetry(ctx, "sendAudio", filepath2, func(f io.Reader) (*tg.Message, error) {
+		return TgSendAudioFile(ctx, tg.SendAudioFileRequest{
+			ChatId:    fmt.Sprintf("%d", m.Chat.Id),
+			Caption:   m.ReplyToMessage.Caption + SP + "audio-compressed",
+			Performer: m.ReplyToMessage.Audio.Performer,
+			Title:     m.ReplyToMessage.Audio.Title,
+			Duration:  time.Duration(m.ReplyToMessage.Audio.Duration) * time.Second, // TODO Audio.Duration time.Duration
+			Audio:     f,
+		})
+	}); err != nil {
+		LogTg.Error("audio compress TgSendAudioFile", "chat_id", m.Chat.Id, "file", filepath2, "err", err)
+		return err
+	}
+
+	return nil
 }
 
-func postVideoDss(v YtVideo, ytlist *YtList, m tg.Message) error {
+// postVideoDss downloads the video by dss, which has no audio track choice so opts.Language is not used
+func postVideoDss(ctx context.Context, v YtVideo, ytlist *YtList, m tg.Message, opts PostOptions) error {
 
 	var vinfo struct {
 		Id          string
@@ -1087,16 +1540,19 @@ func postVideoDss(v YtVideo, ytlist *YtList, m tg.Message) error {
 		Height int
 	}
 
-	infourl := fmt.Sprintf("%s/info/youtu.be/%s", Config.DssUrl, v.Id)
-	perr(F("DEBUG http get [%s]", infourl))
-	err := getJson(infourl, &vinfo, nil)
+	infourl := fmt.Sprintf("%s/info/youtu.be/%s", ConfigFrom(ctx).DssUrl, v.Id)
+	LogDss.Debug("http get", "url", infourl, "video_id", v.Id, "backend", MetricsBackendDss)
+	ctxmeta, cancel := context.WithTimeout(ctx, ConfigFrom(ctx).MetadataTimeout)
+	defer cancel()
+	err := getJson(ctxmeta, infourl, &vinfo, nil)
 	if err != nil {
 		return err
 	}
-	perr(F(
-		"DEBUG vinfo { Id [%s] Channel [%s] Title [%s] FullTitle [%s] Timestamp <%s> Duration <%s> Width <%d> Height <%d> Description [%s] }",
-		vinfo.Id, vinfo.Channel, vinfo.Title, vinfo.FullTitle, fmttime(time.Unix(vinfo.Timestamp, 0)), fmtdursec(uint64(vinfo.Duration)), vinfo.Width, vinfo.Height, strings.ReplaceAll(vinfo.Description, NL, "<NL>"),
-	))
+	LogDss.Debug("vinfo", "video_id", vinfo.Id, "backend", MetricsBackendDss,
+		"channel", vinfo.Channel, "title", vinfo.Title, "full_title", vinfo.FullTitle,
+		"timestamp", fmttime(time.Unix(vinfo.Timestamp, 0)), "duration", fmtdursec(uint64(vinfo.Duration)),
+		"width", vinfo.Width, "height", vinfo.Height, "description", strings.ReplaceAll(vinfo.Description, NL, "<NL>"),
+	)
 
 	tgvideoCaption := fmt.Sprintf(
 		"%s %s"+NL+
@@ -1104,39 +1560,63 @@ func postVideoDss(v YtVideo, ytlist *YtList, m tg.Message) error {
 		vinfo.FullTitle, time.Unix(vinfo.Timestamp, 0).Format("2006/01/02"),
 		v.Id, time.Duration(vinfo.Duration)*time.Second, vinfo.Height,
 	)
-	if ytlist != nil && ytlist.Title != "" {
-		tgvideoCaption += NL + fmt.Sprintf(
-			"%d/%d %s",
-			v.PlaylistIndex+1, ytlist.Size, ytlist.Title,
-		)
+	tgvideoCaption += ytListCaption(v, ytlist)
+
+	duration := time.Duration(vinfo.Duration) * time.Second
+	clip, isclip, err := opts.Clip(duration)
+	if err != nil {
+		return err
+	}
+	if isclip {
+		tgvideoCaption += NL + clip.Name
+		duration = clip.Duration
 	}
 
-	videourl := fmt.Sprintf("%s/video/youtu.be/%s", Config.DssUrl, v.Id)
-	perr(F("DEBUG http get [%s]", videourl))
-	tgvideohttp, err := http.Get(videourl)
+	ctxdownload, cancel := context.WithTimeout(ctx, ConfigFrom(ctx).DownloadTimeout)
+	defer cancel()
+	tgvideohttp, err := dssGet(ctxdownload, v, "video")
 	if err != nil {
 		return err
 	}
 	defer tgvideohttp.Body.Close()
-	if tgvideohttp.StatusCode != http.StatusOK {
-		return fmt.Errorf("http get [%s] status code <%d>", videourl, tgvideohttp.StatusCode)
+	tgvideoBody := ProgressFrom(ctx).Reader(AccessReader(ctx, metricsDownload(tgvideohttp.Body, MetricsBackendDss, ChatMediaVideo)), tgvideohttp.ContentLength)
+
+	tgvideoFilename := fmt.Sprintf("%s.%s.mp4", fmtfiletime(time.Now()), v.Id)
+	if isclip {
+		tgvideoFilename, err = downloadClip(ctx, tgvideoBody, tgvideoFilename, clip)
+		if err != nil {
+			return fmt.Errorf("downloadClip %w", err)
+		}
+	} else if err := saveFile(tgvideoBody, tgvideoFilename); err != nil {
+		return fmt.Errorf("saveFile %w", err)
 	}
+	defer tempRemove(LogWith(ctx, LogDss), tgvideoFilename)
 
-	if _, tgerr := tg.SendVideoFile(tg.SendVideoFileRequest{
-		ChatId:   fmt.Sprintf("%d", m.Chat.Id),
-		Caption:  tgvideoCaption,
-		Video:    tgvideohttp.Body,
-		Width:    vinfo.Width,
-		Height:   vinfo.Height,
-		Duration: time.Duration(vinfo.Duration) * time.Second,
-	}); tgerr != nil {
-		return fmt.Errorf("tg.SendVideoFile %w", err)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	ProgressFrom(ctx).Stage(ProgressStageUploading)
+	tgvideoMsg, tgerr := tgUploadRetry(ctx, "sendVideo", tgvideoFilename, func(f io.Reader) (*tg.Message, error) {
+		return TgSendVideoFile(ctx, tg.SendVideoFileRequest{
+			ChatId:   fmt.Sprintf("%d", m.Chat.Id),
+			Caption:  tgvideoCaption,
+			Video:    f,
+			Width:    vinfo.Width,
+			Height:   vinfo.Height,
+			Duration: duration,
+		})
+	})
+	if tgerr != nil {
+		return fmt.Errorf("TgSendVideoFile %w", tgerr)
 	}
+	tgFileCacheSave(v, ytlist, ChatMediaVideo, opts, tgvideoMsg, tgvideoCaption, duration)
+	tgPostedSave(m.Chat.Id, v.Id, ChatMediaVideo, opts, tgvideoMsg)
 
 	return nil
 }
 
-func postAudioDss(v YtVideo, ytlist *YtList, m tg.Message) error {
+// postAudioDss downloads the audio by dss, which has no audio track choice so opts.Language is not used
+func postAudioDss(ctx context.Context, v YtVideo, ytlist *YtList, m tg.Message, opts PostOptions) error {
 
 	var vinfo struct {
 		Id          string
@@ -1151,16 +1631,19 @@ func postAudioDss(v YtVideo, ytlist *YtList, m tg.Message) error {
 		Abr float64
 	}
 
-	infourl := fmt.Sprintf("%s/info/youtu.be/%s", Config.DssUrl, v.Id)
-	perr(F("DEBUG http get [%s]", infourl))
-	err := getJson(infourl, &vinfo, nil)
+	infourl := fmt.Sprintf("%s/info/youtu.be/%s", ConfigFrom(ctx).DssUrl, v.Id)
+	LogDss.Debug("http get", "url", infourl, "video_id", v.Id, "backend", MetricsBackendDss)
+	ctxmeta, cancel := context.WithTimeout(ctx, ConfigFrom(ctx).MetadataTimeout)
+	defer cancel()
+	err := getJson(ctxmeta, infourl, &vinfo, nil)
 	if err != nil {
 		return err
 	}
-	perr(F(
-		"DEBUG vinfo { Id [%s] Channel [%s] Title [%s] FullTitle [%s] Timestamp <%s> Duration <%s> Abr <%d> Description [%s] }",
-		vinfo.Id, vinfo.Channel, vinfo.Title, vinfo.FullTitle, fmttime(time.Unix(vinfo.Timestamp, 0)), fmtdursec(uint64(vinfo.Duration)), int(vinfo.Abr), strings.ReplaceAll(vinfo.Description, NL, "<NL>"),
-	))
+	LogDss.Debug("vinfo", "video_id", vinfo.Id, "backend", MetricsBackendDss,
+		"channel", vinfo.Channel, "title", vinfo.Title, "full_title", vinfo.FullTitle,
+		"timestamp", fmttime(time.Unix(vinfo.Timestamp, 0)), "duration", fmtdursec(uint64(vinfo.Duration)),
+		"abr", int(vinfo.Abr), "description", strings.ReplaceAll(vinfo.Description, NL, "<NL>"),
+	)
 
 	tgaudioCaption := fmt.Sprintf(
 		"%s %s"+NL+
@@ -1168,60 +1651,131 @@ func postAudioDss(v YtVideo, ytlist *YtList, m tg.Message) error {
 		vinfo.FullTitle, time.Unix(vinfo.Timestamp, 0).Format("2006/01/02"),
 		v.Id, time.Duration(vinfo.Duration)*time.Second, int64(vinfo.Abr),
 	)
-	if ytlist != nil && ytlist.Title != "" {
-		tgaudioCaption += NL + fmt.Sprintf(
-			"%d/%d %s",
-			v.PlaylistIndex+1, ytlist.Size, ytlist.Title,
-		)
+	tgaudioCaption += ytListCaption(v, ytlist)
+
+	duration := time.Duration(vinfo.Duration) * time.Second
+	clip, isclip, err := opts.Clip(duration)
+	if err != nil {
+		return err
+	}
+	if isclip {
+		tgaudioCaption += NL + clip.Name
+		duration = clip.Duration
+	}
+
+	var chapters []MediaPart
+	if opts.Chapters {
+		chapters = parseChapters(vinfo.Description, duration)
+		if len(chapters) == 0 {
+			LogYt.Warn("no chapters in description", "video_id", v.Id)
+		}
 	}
 
-	audiourl := fmt.Sprintf("%s/audio/youtu.be/%s", Config.DssUrl, v.Id)
-	perr(F("DEBUG http get [%s]", audiourl))
-	tgaudiohttp, err := http.Get(audiourl)
+	ctxdownload, cancel := context.WithTimeout(ctx, ConfigFrom(ctx).DownloadTimeout)
+	defer cancel()
+	tgaudiohttp, err := dssGet(ctxdownload, v, "audio")
 	if err != nil {
 		return err
 	}
 	defer tgaudiohttp.Body.Close()
-	perr(F("DEBUG http get [%s] StatusCode <%d> ContentLength <%s>", audiourl, tgaudiohttp.StatusCode, seps(uint64(tgaudiohttp.ContentLength), 3)))
-	if tgaudiohttp.StatusCode != http.StatusOK {
-		return fmt.Errorf("http get [%s] status code <%d>", audiourl, tgaudiohttp.StatusCode)
-	}
+	tgaudioBody := ProgressFrom(ctx).Reader(AccessReader(ctx, metricsDownload(tgaudiohttp.Body, MetricsBackendDss, ChatMediaAudio)), tgaudiohttp.ContentLength)
 
-	thumburl := fmt.Sprintf("%s/thumb/youtu.be/%s", Config.DssUrl, v.Id)
-	perr(F("DEBUG http get [%s]", thumburl))
-	tgthumbhttp, err := http.Get(thumburl)
+	tgthumbhttp, err := dssGet(ctxdownload, v, "thumb")
 	if err != nil {
 		return err
 	}
 	defer tgthumbhttp.Body.Close()
-	perr(F("DEBUG http get [%s] StatusCode <%d> ContentLength <%s>", thumburl, tgthumbhttp.StatusCode, seps(uint64(tgthumbhttp.ContentLength), 3)))
-	if tgthumbhttp.StatusCode != http.StatusOK {
-		return fmt.Errorf("http get [%s] status code <%d>", thumburl, tgthumbhttp.StatusCode)
+
+	if len(chapters) > 0 {
+		if ConfigFrom(ctx).FfmpegPath == "" {
+			return fmt.Errorf("chapters need FfmpegPath")
+		}
+		tgaudioFilename := fmt.Sprintf("%s.%s.m4a", fmtfiletime(time.Now()), v.Id)
+		if err := saveFile(tgaudioBody, tgaudioFilename); err != nil {
+			return fmt.Errorf("saveFile %w", err)
+		}
+		defer tempRemove(LogWith(ctx, LogDss), tgaudioFilename)
+		thumbBytes, err := io.ReadAll(tgthumbhttp.Body)
+		if err != nil {
+			return fmt.Errorf("io.ReadAll %w", err)
+		}
+		tgaudioMsg, err := postAudioParts(ctx, tgaudioFilename, chapters, 0, thumbBytes, tg.SendAudioFileRequest{
+			ChatId:    fmt.Sprintf("%d", m.Chat.Id),
+			Caption:   tgaudioCaption,
+			Performer: vinfo.Channel,
+			Title:     vinfo.FullTitle,
+		})
+		if err != nil {
+			return fmt.Errorf("postAudioParts %w", err)
+		}
+		tgPostedSave(m.Chat.Id, v.Id, ChatMediaAudio, opts, tgaudioMsg)
+		return nil
 	}
 
-	if _, tgerr := tg.SendAudioFile(tg.SendAudioFileRequest{
-		ChatId:    fmt.Sprintf("%d", m.Chat.Id),
-		Caption:   tgaudioCaption,
-		Performer: vinfo.Channel,
-		Title:     vinfo.FullTitle,
-		Duration:  time.Duration(vinfo.Duration) * time.Second,
-		Audio:     tgaudiohttp.Body,
-		Thumb:     tgthumbhttp.Body,
-	}); tgerr != nil {
-		return fmt.Errorf("tg.SendAudioFile %w", err)
+	tgaudioFilename := fmt.Sprintf("%s.%s.m4a", fmtfiletime(time.Now()), v.Id)
+	if isclip {
+		tgaudioFilename, err = downloadClip(ctx, tgaudioBody, tgaudioFilename, clip)
+		if err != nil {
+			return fmt.Errorf("downloadClip %w", err)
+		}
+	} else if err := saveFile(tgaudioBody, tgaudioFilename); err != nil {
+		return fmt.Errorf("saveFile %w", err)
+	}
+	defer tempRemove(LogWith(ctx, LogDss), tgaudioFilename)
+	thumbBytes, err := io.ReadAll(tgthumbhttp.Body)
+	if err != nil {
+		return fmt.Errorf("io.ReadAll %w", err)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	ProgressFrom(ctx).Stage(ProgressStageUploading)
+	tgaudioMsg, tgerr := tgUploadRetry(ctx, "sendAudio", tgaudioFilename, func(f io.Reader) (*tg.Message, error) {
+		return TgSendAudioFile(ctx, tg.SendAudioFileRequest{
+			ChatId:    fmt.Sprintf("%d", m.Chat.Id),
+			Caption:   tgaudioCaption,
+			Performer: vinfo.Channel,
+			Title:     vinfo.FullTitle,
+			Duration:  duration,
+			Audio:     f,
+			Thumb:     bytes.NewReader(thumbBytes),
+		})
+	})
+	if tgerr != nil {
+		return fmt.Errorf("TgSendAudioFile %w", tgerr)
 	}
+	tgFileCacheSave(v, ytlist, ChatMediaAudio, opts, tgaudioMsg, tgaudioCaption, duration)
+	tgPostedSave(m.Chat.Id, v.Id, ChatMediaAudio, opts, tgaudioMsg)
 
 	return nil
 }
 
-func postVideo(v YtVideo, ytlist *YtList, m tg.Message) error {
+func postVideo(ctx context.Context, v YtVideo, ytlist *YtList, m tg.Message, opts PostOptions) error {
+	log := LogWith(ctx, LogYt).With("video_id", v.Id, "backend", MetricsBackendYtdl, "media", ChatMediaVideo)
+
+	ctxmeta, cancel := context.WithTimeout(ctx, ConfigFrom(ctx).MetadataTimeout)
+	defer cancel()
+	vinfo, err := YtdlCl.GetVideoContext(ctxmeta, v.Id)
+	if err != nil {
+		return err
+	}
 
-	vinfo, err := YtdlCl.GetVideoContext(Ctx, v.Id)
+	duration := vinfo.Duration
+	clip, isclip, err := opts.Clip(vinfo.Duration)
 	if err != nil {
 		return err
 	}
+	if isclip {
+		duration = clip.Duration
+	}
+
+	audiotrack := ytAudioTrackPick(vinfo.Formats.WithAudioChannels().Select(func(f ytdl.Format) bool {
+		return strings.HasPrefix(f.MimeType, "video/mp4") && f.QualityLabel != "" && f.AudioQuality != ""
+	}), ytLanguages(opts))
 
 	var videoFormat, videoSmallestFormat ytdl.Format
+	var videoSplitFormats []ytdl.Format
 
 	for _, f := range vinfo.Formats.WithAudioChannels() {
 		if !strings.Contains(f.MimeType, "/mp4") {
@@ -1231,45 +1785,60 @@ func postVideo(v YtVideo, ytlist *YtList, m tg.Message) error {
 		if fsize == 0 {
 			fsize = int64(f.Bitrate / 8 * int(vinfo.Duration.Seconds()))
 		}
+		if isclip {
+			fsize = int64(float64(fsize) * duration.Seconds() / vinfo.Duration.Seconds())
+		}
 		if !strings.HasPrefix(f.MimeType, "video/mp4") || f.QualityLabel == "" || f.AudioQuality == "" {
 			continue
 		}
-		perr(F("DEBUG format size <%dmb> AudioTrack %+v", f.ContentLength>>20, f.AudioTrack))
-		if f.AudioTrack != nil && !strings.HasSuffix(f.AudioTrack.DisplayName, " original") {
+		log.Debug("format", "itag", f.ItagNo, "size_mb", f.ContentLength>>20, "audio_track", F("%+v", f.AudioTrack))
+		if f.AudioTrack != nil && f.AudioTrack.ID != audiotrack {
 			continue
 		}
 		if videoSmallestFormat.ItagNo == 0 || f.Bitrate < videoSmallestFormat.Bitrate {
-			perr("DEBUG pick smallest")
+			log.Debug("format picked as smallest", "itag", f.ItagNo)
 			videoSmallestFormat = f
 		}
-		if fsize < Config.TgMaxFileSizeBytes && f.Bitrate > videoFormat.Bitrate {
-			perr("DEBUG pick")
+		if opts.MaxHeight == 0 || f.Height <= opts.MaxHeight {
+			videoSplitFormats = append(videoSplitFormats, f)
+		}
+		if fsize < ConfigFrom(ctx).TgMaxFileSizeBytes && f.Bitrate > videoFormat.Bitrate && (opts.MaxHeight == 0 || f.Height <= opts.MaxHeight) {
+			log.Debug("format picked", "itag", f.ItagNo)
 			videoFormat = f
 		}
 	}
 
 	var targetVideoBitrateKbps int64
+	var split bool
 	if videoFormat.ItagNo == 0 {
 		videoFormat = videoSmallestFormat
-		targetVideoSize := int64(Config.TgMaxFileSizeBytes - (Config.TgVideoAudioBitrateKbps*1024*int64(vinfo.Duration.Seconds()+1))/8)
-		targetVideoBitrateKbps = int64(((targetVideoSize * 8) / int64(vinfo.Duration.Seconds()+1)) / 1024)
+		targetVideoSize := int64(ConfigFrom(ctx).TgMaxFileSizeBytes - (ConfigFrom(ctx).TgVideoAudioBitrateKbps*1024*int64(duration.Seconds()+1))/8)
+		targetVideoBitrateKbps = int64(((targetVideoSize * 8) / int64(duration.Seconds()+1)) / 1024)
+		if ConfigFrom(ctx).TgOversizeMode == TgOversizeModeSplit && targetVideoBitrateKbps < ConfigFrom(ctx).TgSplitVideoBitrateMinKbps {
+			targetVideoBitrateKbps = 0
+			split = true
+			if len(videoSplitFormats) > 0 {
+				videoFormat = splitFormat(videoSplitFormats, ConfigFrom(ctx).TgSplitVideoBitrateMinKbps)
+			}
+			log.Info("target bitrate too low, splitting into parts", "itag", videoFormat.ItagNo, "video_kbps", videoFormat.Bitrate/1024, "min_kbps", ConfigFrom(ctx).TgSplitVideoBitrateMinKbps)
+		}
 	}
 
-	ytstream, ytstreamsize, err := YtdlCl.GetStreamContext(Ctx, vinfo, &videoFormat)
+	ctxdownload, cancel := context.WithTimeout(ctx, ConfigFrom(ctx).DownloadTimeout)
+	defer cancel()
+	ytstream, ytstreamsize, err := YtdlCl.GetStreamContext(ctxdownload, vinfo, &videoFormat)
 	if err != nil {
 		return fmt.Errorf("GetStreamContext %w", err)
 	}
 	defer ytstream.Close()
 
-	perr(F(
-		"downloading url [youtu.be/%s] video size <%dmb> quality [%s] bitrate <%dkbps> duration <%v> language [%s]",
-		v.Id,
-		ytstreamsize>>20,
-		videoFormat.QualityLabel,
-		videoFormat.Bitrate>>10,
-		vinfo.Duration,
-		videoFormat.LanguageDisplayName(),
-	))
+	LogYt.Info("downloading", "video_id", v.Id, "backend", MetricsBackendYtdl, "media", ChatMediaVideo,
+		"size_mb", ytstreamsize>>20,
+		"quality", videoFormat.QualityLabel,
+		"bitrate_kbps", videoFormat.Bitrate>>10,
+		"duration", vinfo.Duration,
+		"language", videoFormat.LanguageDisplayName(),
+	)
 
 	tgvideoCaption := fmt.Sprintf(
 		"%s %s"+NL+
@@ -1277,82 +1846,139 @@ func postVideo(v YtVideo, ytlist *YtList, m tg.Message) error {
 		vinfo.Title, vinfo.PublishDate.Format("2006/01/02"),
 		v.Id, vinfo.Duration, videoFormat.QualityLabel,
 	)
-	if ytlist.Id != "" && ytlist.Title != "" {
-		tgvideoCaption += NL + fmt.Sprintf(
-			"%d/%d %s",
-			v.PlaylistIndex+1, ytlist.Size, ytlist.Title,
-		)
-	}
+	tgvideoCaption += ytLanguageCaption(videoFormat)
+	tgvideoCaption += ytListCaption(v, ytlist)
 
 	tgvideoFilename := fmt.Sprintf("%s.%s.mp4", fmtfiletime(time.Now()), v.Id)
 	tgvideoFile, err := os.OpenFile(tgvideoFilename, os.O_RDWR|os.O_CREATE, 0600)
 	if err != nil {
 		return fmt.Errorf("os.OpenFile %w", err)
 	}
+	defer tempRemove(log, tgvideoFilename)
 
 	t0 := time.Now()
-	_, err = io.Copy(tgvideoFile, ytstream)
+	_, err = io.Copy(tgvideoFile, ProgressFrom(ctx).Reader(AccessReader(ctx, metricsDownload(ytstream, MetricsBackendYtdl, ChatMediaVideo)), ytstreamsize))
 	if err != nil {
+		tgvideoFile.Close()
 		return fmt.Errorf("download youtu.be/%s video %w", v.Id, err)
 	}
 
 	if err := ytstream.Close(); err != nil {
-		perr(F("ERROR ytstream.Close %v", err))
+		log.Error("ytstream.Close", "err", err)
 	}
 	if err := tgvideoFile.Close(); err != nil {
 		return fmt.Errorf("os.File.Close %w", err)
 	}
 
-	perr(F("downloaded url [youtu.be/%s] video in <%v>", v.Id, time.Since(t0).Truncate(time.Second)))
+	log.Info("downloaded", "duration", time.Since(t0).Truncate(time.Second))
+
+	if isclip {
+		tgvideoFilename, err = FfmpegClip(ctx, tgvideoFilename, clip)
+		if err != nil {
+			return fmt.Errorf("FfmpegClip %w", err)
+		}
+		defer tempRemove(log, tgvideoFilename)
+		tgvideoCaption += NL + clip.Name
+	}
 
-	if Config.FfmpegPath != "" && targetVideoBitrateKbps > 0 {
-		filename2 := fmt.Sprintf("%s.%s.v%dk.a%dk.mp4", fmtfiletime(time.Now()), v.Id, targetVideoBitrateKbps, Config.TgVideoAudioBitrateKbps)
-		err := FfmpegTranscode(tgvideoFilename, filename2, targetVideoBitrateKbps, Config.TgVideoAudioBitrateKbps)
+	if ConfigFrom(ctx).FfmpegPath != "" && targetVideoBitrateKbps > 0 {
+		filename2 := fmt.Sprintf("%s.%s.v%dk.a%dk.mp4", fmtfiletime(time.Now()), v.Id, targetVideoBitrateKbps, ConfigFrom(ctx).TgVideoAudioBitrateKbps)
+		defer tempRemove(log, filename2)
+		err := FfmpegTranscode(ctx, tgvideoFilename, filename2, duration, targetVideoBitrateKbps, ConfigFrom(ctx).TgVideoAudioBitrateKbps)
 		if err != nil {
 			return fmt.Errorf("FfmpegTranscode `%s`: %w", tgvideoFilename, err)
 		}
-		tgvideoCaption += NL + fmt.Sprintf("(transcoded to video:%dkbps audio:%dkbps)", targetVideoBitrateKbps, Config.TgVideoAudioBitrateKbps)
+		tgvideoCaption += NL + fmt.Sprintf("(transcoded to video:%dkbps audio:%dkbps)", targetVideoBitrateKbps, ConfigFrom(ctx).TgVideoAudioBitrateKbps)
 		if err := os.Remove(tgvideoFilename); err != nil {
-			perr(F("ERROR os.Remove [%s] %v", tgvideoFilename, err))
+			log.Error("os.Remove", "file", tgvideoFilename, "err", err)
 		}
 		tgvideoFilename = filename2
 	}
 
-	tgvideoReader, err := os.Open(tgvideoFilename)
-	if err != nil {
-		return fmt.Errorf("os.Open %w", err)
+	var parts []MediaPart
+	if split {
+		tgvideoStat, err := os.Stat(tgvideoFilename)
+		if err != nil {
+			return fmt.Errorf("os.Stat %w", err)
+		}
+		parts = splitParts(duration, tgvideoStat.Size(), ConfigFrom(ctx).TgMaxFileSizeBytes*9/10)
 	}
-	defer tgvideoReader.Close()
 
-	if _, tgerr := tg.SendVideoFile(tg.SendVideoFileRequest{
-		ChatId:   fmt.Sprintf("%d", m.Chat.Id),
-		Caption:  tgvideoCaption,
-		Video:    tgvideoReader,
-		Width:    videoFormat.Width,
-		Height:   videoFormat.Height,
-		Duration: vinfo.Duration,
-	}); tgerr != nil {
-		return fmt.Errorf("tg.SendVideoFile %w", tgerr)
+	if len(parts) > 0 {
+		tgvideoMsg, err := postVideoParts(ctx, tgvideoFilename, parts, 0, tg.SendVideoFileRequest{
+			ChatId:  fmt.Sprintf("%d", m.Chat.Id),
+			Caption: tgvideoCaption,
+			Width:   videoFormat.Width,
+			Height:  videoFormat.Height,
+		})
+		if err != nil {
+			return fmt.Errorf("postVideoParts %w", err)
+		}
+		tgPostedSave(m.Chat.Id, v.Id, ChatMediaVideo, opts, tgvideoMsg)
+		return nil
 	}
 
-	if err := tgvideoReader.Close(); err != nil {
-		perr(F("ERROR os.File.Close %v", err))
+	if err := ctx.Err(); err != nil {
+		return err
 	}
-	if err := os.Remove(tgvideoFilename); err != nil {
-		perr(F("ERROR os.Remove %v", err))
+	ProgressFrom(ctx).Stage(ProgressStageUploading)
+	tgvideoMsg, tgerr := tgUploadRetry(ctx, "sendVideo", tgvideoFilename, func(f io.Reader) (*tg.Message, error) {
+		return TgSendVideoFile(ctx, tg.SendVideoFileRequest{
+			ChatId:   fmt.Sprintf("%d", m.Chat.Id),
+			Caption:  tgvideoCaption,
+			Video:    f,
+			Width:    videoFormat.Width,
+			Height:   videoFormat.Height,
+			Duration: duration,
+		})
+	})
+	if tgerr != nil {
+		return fmt.Errorf("TgSendVideoFile %w", tgerr)
 	}
+	tgFileCacheSave(v, ytlist, ChatMediaVideo, opts, tgvideoMsg, tgvideoCaption, duration)
+	tgPostedSave(m.Chat.Id, v.Id, ChatMediaVideo, opts, tgvideoMsg)
 
 	return nil
 }
 
-func postAudio(v YtVideo, ytlist *YtList, m tg.Message) error {
+func postAudio(ctx context.Context, v YtVideo, ytlist *YtList, m tg.Message, opts PostOptions) error {
+	log := LogWith(ctx, LogYt).With("video_id", v.Id, "backend", MetricsBackendYtdl, "media", ChatMediaAudio)
+
+	ctxmeta, cancel := context.WithTimeout(ctx, ConfigFrom(ctx).MetadataTimeout)
+	defer cancel()
+	vinfo, err := YtdlCl.GetVideoContext(ctxmeta, v.Id)
+	if err != nil {
+		return err
+	}
 
-	vinfo, err := YtdlCl.GetVideoContext(Ctx, v.Id)
+	duration := vinfo.Duration
+	clip, isclip, err := opts.Clip(vinfo.Duration)
 	if err != nil {
 		return err
 	}
+	if isclip {
+		duration = clip.Duration
+	}
+
+	var chapters []MediaPart
+	if opts.Chapters {
+		chapters = parseChapters(vinfo.Description, vinfo.Duration)
+		if len(chapters) == 0 {
+			LogYt.Warn("no chapters in description", "video_id", v.Id)
+		} else if ConfigFrom(ctx).FfmpegPath == "" {
+			return fmt.Errorf("chapters need FfmpegPath")
+		} else {
+			log.Debug("chapters", "chapters", F("%+v", chapters))
+			duration = longestPart(chapters)
+		}
+	}
+
+	audiotrack := ytAudioTrackPick(vinfo.Formats.WithAudioChannels().Select(func(f ytdl.Format) bool {
+		return strings.HasPrefix(f.MimeType, "audio/mp4")
+	}), ytLanguages(opts))
 
 	var audioFormat, audioSmallestFormat ytdl.Format
+	var audioSplitFormats []ytdl.Format
 
 	// https://pkg.go.dev/github.com/kkdai/youtube/v2#FormatList
 	for _, f := range vinfo.Formats.WithAudioChannels() {
@@ -1363,30 +1989,47 @@ func postAudio(v YtVideo, ytlist *YtList, m tg.Message) error {
 		if fsize == 0 {
 			fsize = int64(f.Bitrate / 8 * int(vinfo.Duration.Seconds()))
 		}
+		if duration != vinfo.Duration {
+			fsize = int64(float64(fsize) * duration.Seconds() / vinfo.Duration.Seconds())
+		}
 		if !strings.HasPrefix(f.MimeType, "audio/mp4") {
 			continue
 		}
-		perr(F("DEBUG format size <%dmb> AudioTrack %+v", f.ContentLength>>20, f.AudioTrack))
-		if f.AudioTrack != nil && !strings.HasSuffix(f.AudioTrack.DisplayName, " original") {
+		log.Debug("format", "itag", f.ItagNo, "size_mb", f.ContentLength>>20, "audio_track", F("%+v", f.AudioTrack))
+		if f.AudioTrack != nil && f.AudioTrack.ID != audiotrack {
 			continue
 		}
 		if audioSmallestFormat.ItagNo == 0 || f.Bitrate < audioSmallestFormat.Bitrate {
-			perr("DEBUG pick smallest")
+			log.Debug("format picked as smallest", "itag", f.ItagNo)
 			audioSmallestFormat = f
 		}
-		if fsize < Config.TgMaxFileSizeBytes && f.Bitrate > audioFormat.Bitrate {
-			perr("DEBUG pick")
+		if opts.AudioMaxKbps == 0 || int64(f.Bitrate/1024) <= opts.AudioMaxKbps {
+			audioSplitFormats = append(audioSplitFormats, f)
+		}
+		if fsize < ConfigFrom(ctx).TgMaxFileSizeBytes && f.Bitrate > audioFormat.Bitrate && (opts.AudioMaxKbps == 0 || int64(f.Bitrate/1024) <= opts.AudioMaxKbps) {
+			log.Debug("format picked", "itag", f.ItagNo)
 			audioFormat = f
 		}
 	}
 
 	var targetAudioBitrateKbps int64
+	var split bool
 	if audioFormat.ItagNo == 0 {
 		audioFormat = audioSmallestFormat
-		targetAudioBitrateKbps = int64(((Config.TgMaxFileSizeBytes * 8) / int64(vinfo.Duration.Seconds()+1)) / 1024)
+		targetAudioBitrateKbps = int64(((ConfigFrom(ctx).TgMaxFileSizeBytes * 8) / int64(duration.Seconds()+1)) / 1024)
+		if ConfigFrom(ctx).TgOversizeMode == TgOversizeModeSplit && len(chapters) == 0 && targetAudioBitrateKbps < ConfigFrom(ctx).TgSplitAudioBitrateMinKbps {
+			targetAudioBitrateKbps = 0
+			split = true
+			if len(audioSplitFormats) > 0 {
+				audioFormat = splitFormat(audioSplitFormats, ConfigFrom(ctx).TgSplitAudioBitrateMinKbps)
+			}
+			log.Info("target bitrate too low, splitting into parts", "itag", audioFormat.ItagNo, "audio_kbps", audioFormat.Bitrate/1024, "min_kbps", ConfigFrom(ctx).TgSplitAudioBitrateMinKbps)
+		}
 	}
 
-	ytstream, ytstreamsize, err := YtdlCl.GetStreamContext(Ctx, vinfo, &audioFormat)
+	ctxdownload, cancel := context.WithTimeout(ctx, ConfigFrom(ctx).DownloadTimeout)
+	defer cancel()
+	ytstream, ytstreamsize, err := YtdlCl.GetStreamContext(ctxdownload, vinfo, &audioFormat)
 	if err != nil {
 		return fmt.Errorf("GetStreamContext %w", err)
 	}
@@ -1396,7 +2039,7 @@ func postAudio(v YtVideo, ytlist *YtList, m tg.Message) error {
 		return fmt.Errorf("GetStreamContext stream size is zero")
 	}
 
-	ytstreamthrottled := &ThrottledReader{Reader: ytstream, Bps: int64(audioFormat.Bitrate) * Config.YtThrottle}
+	ytstreamthrottled := &ThrottledReader{Reader: ProgressFrom(ctx).Reader(AccessReader(ctx, metricsDownload(ytstream, MetricsBackendYtdl, ChatMediaAudio)), ytstreamsize), Bps: int64(audioFormat.Bitrate) * ConfigFrom(ctx).YtThrottle}
 
 	tgaudioCaption := fmt.Sprintf(
 		"%s %s "+NL+
@@ -1404,51 +2047,57 @@ func postAudio(v YtVideo, ytlist *YtList, m tg.Message) error {
 		vinfo.Title, vinfo.PublishDate.Format("2006/01/02"),
 		v.Id, vinfo.Duration, audioFormat.Bitrate/1024,
 	)
-	if ytlist != nil && ytlist.Title != "" {
-		tgaudioCaption += NL + fmt.Sprintf(
-			"%d/%d %s",
-			v.PlaylistIndex+1, ytlist.Size, ytlist.Title,
-		)
-	}
+	tgaudioCaption += ytLanguageCaption(audioFormat)
+	tgaudioCaption += ytListCaption(v, ytlist)
 
 	tgaudioFilename := fmt.Sprintf("%s.%s.m4a", fmtfiletime(time.Now()), v.Id)
 	tgaudioFile, err := os.OpenFile(tgaudioFilename, os.O_RDWR|os.O_CREATE, 0600)
 	if err != nil {
 		return fmt.Errorf("create file %w", err)
 	}
+	defer tempRemove(log, tgaudioFilename)
 
-	perr(F(
-		"downloading url [youtu.be/%s] audio size <%dmb> bitrate <%dkbps> duration <%v> language [%s]",
-		v.Id,
-		ytstreamsize>>20,
-		audioFormat.Bitrate>>10,
-		vinfo.Duration,
-		audioFormat.LanguageDisplayName(),
-	))
+	LogYt.Info("downloading", "video_id", v.Id, "backend", MetricsBackendYtdl, "media", ChatMediaAudio,
+		"size_mb", ytstreamsize>>20,
+		"bitrate_kbps", audioFormat.Bitrate>>10,
+		"duration", vinfo.Duration,
+		"language", audioFormat.LanguageDisplayName(),
+	)
 
 	t0 := time.Now()
 	if _, err := io.Copy(tgaudioFile, ytstreamthrottled); err != nil {
+		tgaudioFile.Close()
 		return fmt.Errorf("download youtu.be/%s audio %w", v.Id, err)
 	}
 
 	if err := ytstream.Close(); err != nil {
-		perr(F("ERROR ytstream.Close %v", err))
+		log.Error("ytstream.Close", "err", err)
 	}
 	if err := tgaudioFile.Close(); err != nil {
 		return fmt.Errorf("os.File.Close %w", err)
 	}
 
-	perr(F("downloaded url [youtu.be/%s] audio in <%v>", v.Id, time.Since(t0).Truncate(time.Second)))
+	log.Info("downloaded", "duration", time.Since(t0).Truncate(time.Second))
+
+	if isclip {
+		tgaudioFilename, err = FfmpegClip(ctx, tgaudioFilename, clip)
+		if err != nil {
+			return fmt.Errorf("FfmpegClip %w", err)
+		}
+		defer tempRemove(log, tgaudioFilename)
+		tgaudioCaption += NL + clip.Name
+	}
 
-	if Config.FfmpegPath != "" && targetAudioBitrateKbps > 0 {
+	if ConfigFrom(ctx).FfmpegPath != "" && targetAudioBitrateKbps > 0 {
 		filename2 := fmt.Sprintf("%s.%s.a%dk.m4a", fmtfiletime(time.Now()), v.Id, targetAudioBitrateKbps)
-		err := FfmpegTranscode(tgaudioFilename, filename2, 0, targetAudioBitrateKbps)
+		defer tempRemove(log, filename2)
+		err := FfmpegTranscode(ctx, tgaudioFilename, filename2, duration, 0, targetAudioBitrateKbps)
 		if err != nil {
 			return fmt.Errorf("FfmpegTranscode %s %w", tgaudioFilename, err)
 		}
 		tgaudioCaption += NL + fmt.Sprintf("(transcoded to audio:%dkbps)", targetAudioBitrateKbps)
 		if err := os.Remove(tgaudioFilename); err != nil {
-			perr(F("ERROR os.Remove %s %v", tgaudioFilename, err))
+			log.Error("os.Remove", "file", tgaudioFilename, "err", err)
 		}
 		tgaudioFilename = filename2
 	}
@@ -1457,64 +2106,87 @@ func postAudio(v YtVideo, ytlist *YtList, m tg.Message) error {
 	var thumb ytdl.Thumbnail
 	if len(vinfo.Thumbnails) > 0 {
 		for _, t := range vinfo.Thumbnails {
-			perr(F("DEBUG thumb url [%s]", t.URL))
+			log.Debug("thumb", "url", t.URL)
 			if t.Width > thumb.Width {
-				perr("DEBUG pick")
+				log.Debug("thumb picked", "url", t.URL)
 				thumb = t
 			}
 		}
-		thumbBytes, err = downloadFile(thumb.URL)
+		thumbBytes, err = downloadFile(ctxdownload, thumb.URL)
 		if err != nil {
-			perr(F("ERROR download thumb url [%s] %v", thumb.URL, err))
+			log.Error("download thumb", "url", thumb.URL, "err", err)
 		}
 	}
 
 	if thumbImg, thumbImgFmt, err := image.Decode(bytes.NewReader(thumbBytes)); err != nil {
-		perr(F("ERROR thumb url [%s] decode %v", thumb.URL, err))
+		log.Error("decode thumb", "url", thumb.URL, "err", err)
 	} else {
 		dx, dy := thumbImg.Bounds().Dx(), thumbImg.Bounds().Dy()
-		perr(F("DEBUG thumb url [%s] fmt [%s] size <%dkb> res <%dx%d>", thumb.URL, thumbImgFmt, len(thumbBytes)>>10, dx, dy))
+		log.Debug("thumb", "url", thumb.URL, "format", thumbImgFmt, "size_kb", len(thumbBytes)>>10, "width", dx, "height", dy)
 		if thumbImgFmt == "webp" {
 			thumbPngBuf := new(bytes.Buffer)
 			png.Encode(thumbPngBuf, thumbImg)
 			thumbBytes = thumbPngBuf.Bytes()
-			perr(F("DEBUG thumb url [%s] converted to fmt [png] size <%dkb>", thumb.URL, len(thumbBytes)>>10))
+			log.Debug("thumb converted to png", "url", thumb.URL, "size_kb", len(thumbBytes)>>10)
 		}
 	}
 
-	tgaudioReader, err := os.Open(tgaudioFilename)
-	if err != nil {
-		return fmt.Errorf("os.Open %w", err)
+	var parts []MediaPart
+	if split {
+		size := int64(duration.Seconds()+1) * int64(audioFormat.Bitrate) / 8
+		parts = splitParts(duration, size, ConfigFrom(ctx).TgMaxFileSizeBytes*9/10)
+	}
+	if len(chapters) > 0 {
+		parts = chapters
 	}
-	defer tgaudioReader.Close()
 
-	if _, tgerr := tg.SendAudioFile(tg.SendAudioFileRequest{
-		ChatId:    fmt.Sprintf("%d", m.Chat.Id),
-		Caption:   tgaudioCaption,
-		Performer: vinfo.Author,
-		Title:     vinfo.Title,
-		Duration:  vinfo.Duration,
-		Audio:     tgaudioReader,
-		Thumb:     bytes.NewReader(thumbBytes),
-	}); tgerr != nil {
-		return fmt.Errorf("tg.SendAudioFile %w", tgerr)
+	if len(parts) > 0 {
+		tgaudioMsg, err := postAudioParts(ctx, tgaudioFilename, parts, 0, thumbBytes, tg.SendAudioFileRequest{
+			ChatId:    fmt.Sprintf("%d", m.Chat.Id),
+			Caption:   tgaudioCaption,
+			Performer: vinfo.Author,
+			Title:     vinfo.Title,
+		})
+		if err != nil {
+			return fmt.Errorf("postAudioParts %w", err)
+		}
+		tgPostedSave(m.Chat.Id, v.Id, ChatMediaAudio, opts, tgaudioMsg)
+		return nil
 	}
 
-	if err := tgaudioReader.Close(); err != nil {
-		perr(F("ERROR os.File.Close %v", err))
+	if err := ctx.Err(); err != nil {
+		return err
 	}
-	if err := os.Remove(tgaudioFilename); err != nil {
-		perr(F("ERROR os.Remove %v", err))
+	ProgressFrom(ctx).Stage(ProgressStageUploading)
+	tgaudioMsg, tgerr := tgUploadRetry(ctx, "sendAudio", tgaudioFilename, func(f io.Reader) (*tg.Message, error) {
+		return TgSendAudioFile(ctx, tg.SendAudioFileRequest{
+			ChatId:    fmt.Sprintf("%d", m.Chat.Id),
+			Caption:   tgaudioCaption,
+			Performer: vinfo.Author,
+			Title:     vinfo.Title,
+			Duration:  duration,
+			Audio:     f,
+			Thumb:     bytes.NewReader(thumbBytes),
+		})
+	})
+	if tgerr != nil {
+		return fmt.Errorf("TgSendAudioFile %w", tgerr)
 	}
+	tgFileCacheSave(v, ytlist, ChatMediaAudio, opts, tgaudioMsg, tgaudioCaption, duration)
+	tgPostedSave(m.Chat.Id, v.Id, ChatMediaAudio, opts, tgaudioMsg)
 
 	return nil
 }
 
-func getList(ytlistid string) (ytlistinfo *YtList, err error) {
+// getList returns the playlist with videos at positions from from to to, to below zero means the end of the list
+func getList(ctx context.Context, ytlistid string, from, to int64) (ytlistinfo *YtList, err error) {
+	ctx, cancel := context.WithTimeout(ctx, ConfigFrom(ctx).MetadataTimeout)
+	defer cancel()
+
 	// https://developers.google.com/youtube/v3/docs/playlists
-	var PlaylistUrl = fmt.Sprintf("https://www.googleapis.com/youtube/v3/playlists?maxResults=%d&part=snippet&id=%s&key=%s", Config.YtMaxResults, ytlistid, Config.YtKey)
+	var PlaylistUrl = fmt.Sprintf("%s/playlists?maxResults=%d&part=snippet&id=%s&key=%s", ConfigFrom(ctx).YtApiUrl, ConfigFrom(ctx).YtMaxResults, ytlistid, ConfigFrom(ctx).YtKey)
 	var playlists YtPlaylists
-	err = getJson(PlaylistUrl, &playlists, nil)
+	err = getJson(ctx, PlaylistUrl, &playlists, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -1531,7 +2203,7 @@ func getList(ytlistid string) (ytlistinfo *YtList, err error) {
 		Title: playlists.Items[0].Snippet.Title,
 	}
 	ytlistinfo = &list
-	perr(F("DEBUG getList playlist title [%s]", ytlistinfo.Title))
+	LogYt.Debug("getList title", "list_id", ytlistid, "title", ytlistinfo.Title)
 
 	listthumbs := playlists.Items[0].Snippet.Thumbnails
 	if listthumbs.MaxRes.Url != "" {
@@ -1543,19 +2215,20 @@ func getList(ytlistid string) (ytlistinfo *YtList, err error) {
 	} else if listthumbs.Medium.Url != "" {
 		ytlistinfo.ThumbUrl = listthumbs.Medium.Url
 	} else {
-		perr("ERROR no list thumb url")
+		LogYt.Error("getList no thumb url", "list_id", ytlistid)
 	}
-	perr(F("DEBUG getList playlist thumb url [%s]", ytlistinfo.ThumbUrl))
+	LogYt.Debug("getList thumb url", "list_id", ytlistid, "url", ytlistinfo.ThumbUrl)
 
-	var videos []YtPlaylistItemSnippet
+	var videos []YtPlaylistItem
+	var listsize, listitems int64
 	nextPageToken := ""
 
-	for nextPageToken != "" || len(videos) == 0 {
+	for nextPageToken != "" || listitems == 0 {
 		// https://developers.google.com/youtube/v3/docs/playlistItems
-		var PlaylistItemsUrl = fmt.Sprintf("https://www.googleapis.com/youtube/v3/playlistItems?maxResults=%d&part=snippet&playlistId=%s&key=%s&pageToken=%s", Config.YtMaxResults, ytlistid, Config.YtKey, nextPageToken)
+		var PlaylistItemsUrl = fmt.Sprintf("%s/playlistItems?maxResults=%d&part=snippet,status&playlistId=%s&key=%s&pageToken=%s", ConfigFrom(ctx).YtApiUrl, ConfigFrom(ctx).YtMaxResults, ytlistid, ConfigFrom(ctx).YtKey, nextPageToken)
 
 		var playlistItems YtPlaylistItems
-		err = getJson(PlaylistItemsUrl, &playlistItems, nil)
+		err = getJson(ctx, PlaylistItemsUrl, &playlistItems, nil)
 		if err != nil {
 			return nil, err
 		}
@@ -1566,36 +2239,53 @@ func getList(ytlistid string) (ytlistinfo *YtList, err error) {
 			nextPageToken = ""
 		}
 
+		listitems += int64(len(playlistItems.Items))
+		listsize = max(playlistItems.PageInfo.TotalResults, listitems)
+
+		var pastto bool
 		for _, i := range playlistItems.Items {
-			videos = append(videos, i.Snippet)
+			if i.Snippet.Position < from {
+				continue
+			}
+			if to >= 0 && i.Snippet.Position > to {
+				pastto = true
+				continue
+			}
+			videos = append(videos, i)
+		}
+		if pastto || len(playlistItems.Items) == 0 {
+			break
 		}
 	}
 
 	//sort.Slice(videos, func(i, j int) bool { return videos[i].PublishedAt < videos[j].PublishedAt })
 	//slices.Sort(videos)
 
-	ytlistinfo.Size = int64(len(videos))
+	ytlistinfo.Size = listsize
 
 	for _, v := range videos {
 		ytlistinfo.Videos = append(ytlistinfo.Videos, YtVideo{
-			Id:            v.ResourceId.VideoId,
-			PlaylistIndex: v.Position,
+			Id:            v.Snippet.ResourceId.VideoId,
+			PlaylistIndex: v.Snippet.Position,
+			Title:         v.Snippet.Title,
+			Unavailable:   v.Status.PrivacyStatus == "private" || v.Status.PrivacyStatus == "privacyStatusUnspecified",
 		})
 	}
 
 	return ytlistinfo, nil
 }
 
-func FfmpegTranscode(filename, filename2 string, videoBitrateKbps, audioBitrateKbps int64) (err error) {
+func FfmpegTranscode(ctx context.Context, filename, filename2 string, duration time.Duration, videoBitrateKbps, audioBitrateKbps int64) (err error) {
 	if videoBitrateKbps > 0 {
-		perr(F("DEBUG transcoding to video <%dkbps> audio <%dkbps>", videoBitrateKbps, audioBitrateKbps))
+		LogFfmpeg.Debug("transcoding", "video_kbps", videoBitrateKbps, "audio_kbps", audioBitrateKbps)
 	} else if audioBitrateKbps > 0 {
-		perr(F("DEBUG transcoding to audio <%dkbps>", audioBitrateKbps))
+		LogFfmpeg.Debug("transcoding", "audio_kbps", audioBitrateKbps)
 	} else {
 		return fmt.Errorf("empty both videoBitrateKbps and audioBitrateKbps")
 	}
+	defer metricsTranscode("transcode", time.Now())
 
-	ffmpegArgs := append(Config.FfmpegGlobalOptions,
+	ffmpegArgs := append(slices.Clone(ConfigFrom(ctx).FfmpegGlobalOptions),
 		"-i", filename,
 		"-f", "mp4",
 	)
@@ -1615,46 +2305,32 @@ func FfmpegTranscode(filename, filename2 string, videoBitrateKbps, audioBitrateK
 		filename2,
 	)
 
-	ffmpegCmd := exec.Command(Config.FfmpegPath, ffmpegArgs...)
-
-	ffmpegCmdStderrPipe, err := ffmpegCmd.StderrPipe()
-	if err != nil {
-		return fmt.Errorf("ffmpeg StderrPipe %w", err)
-	}
-
-	t0 := time.Now()
-	err = ffmpegCmd.Start()
-	if err != nil {
-		return fmt.Errorf("ffmpeg Start %w", err)
-	}
-
-	perr(F("DEBUG started command [%s]", ffmpegCmd.String()))
-
-	_, err = io.Copy(os.Stderr, ffmpegCmdStderrPipe)
-	if err != nil {
-		perr(F("ERROR copy from ffmpeg stderr %v", err))
-	}
-
-	err = ffmpegCmd.Wait()
-	if err != nil {
-		return fmt.Errorf("ffmpeg Wait %w", err)
-	}
-
-	perr(F("DEBUG ffmpeg finished in <%v>", time.Since(t0).Truncate(time.Second)))
-
-	return nil
+	return ffmpegRun(ctx, ffmpegArgs, duration)
 }
 
-func FfmpegAudioCompress(filename, filename2 string) (err error) {
+func FfmpegAudioCompress(ctx context.Context, filename, filename2 string) (err error) {
 	ffmpegArgs := append(
-		Config.FfmpegGlobalOptions,
+		slices.Clone(ConfigFrom(ctx).FfmpegGlobalOptions),
 		"-i", filename,
-		"-af", Config.FfmpegAudioCompressFilter,
+		"-af", ConfigFrom(ctx).FfmpegAudioCompressFilter,
 		filename2,
 	)
 
-	ffmpegCmd := exec.Command(Config.FfmpegPath, ffmpegArgs...)
+	return ffmpegRun(ctx, ffmpegArgs, 0)
+}
+
+// ffmpegRun runs ffmpeg killing it after TranscodeTimeout or when the context is done,
+// duration is the output duration for the progress, zero means the input duration from the ffmpeg log
+func ffmpegRun(ctx context.Context, ffmpegArgs []string, duration time.Duration) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, ConfigFrom(ctx).TranscodeTimeout)
+	defer cancel()
 
+	ffmpegCmd := exec.CommandContext(ctx, ConfigFrom(ctx).FfmpegPath, append([]string{"-progress", "pipe:1", "-nostats"}, ffmpegArgs...)...)
+
+	ffmpegCmdStdoutPipe, err := ffmpegCmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("ffmpeg StdoutPipe %w", err)
+	}
 	ffmpegCmdStderrPipe, err := ffmpegCmd.StderrPipe()
 	if err != nil {
 		return fmt.Errorf("ffmpeg StderrPipe %w", err)
@@ -1666,19 +2342,38 @@ func FfmpegAudioCompress(filename, filename2 string) (err error) {
 		return fmt.Errorf("ffmpeg Start %w", err)
 	}
 
-	perr(F("DEBUG started command [%s]", ffmpegCmd.String()))
+	LogFfmpeg.Debug("started", "command", ffmpegCmd.String())
 
-	_, err = io.Copy(os.Stderr, ffmpegCmdStderrPipe)
-	if err != nil {
-		return fmt.Errorf("ffmpeg Copy from stderr %w", err)
+	progress := ProgressFrom(ctx)
+	progress.Stage(ProgressStageTranscoding)
+	var progressDuration atomic.Int64
+	progressDuration.Store(int64(duration))
+	progressDone := make(chan struct{})
+	go func() {
+		progress.Ffmpeg(ffmpegCmdStdoutPipe, func() time.Duration { return time.Duration(progressDuration.Load()) })
+		close(progressDone)
+	}()
+
+	stderr := bufio.NewScanner(ffmpegCmdStderrPipe)
+	for stderr.Scan() {
+		LogFfmpeg.Debug("ffmpeg stderr", "line", stderr.Text())
+		if d, ok := ffmpegDuration(stderr.Text()); ok && progressDuration.Load() == 0 {
+			progressDuration.Store(int64(d))
+		}
 	}
+	if err := stderr.Err(); err != nil {
+		LogFfmpeg.Error("read ffmpeg stderr", "err", err)
+	}
+	<-progressDone
 
 	err = ffmpegCmd.Wait()
-	if err != nil {
+	if err != nil && ctx.Err() != nil {
+		return fmt.Errorf("ffmpeg Wait %w %w", err, ctx.Err())
+	} else if err != nil {
 		return fmt.Errorf("ffmpeg Wait %w", err)
 	}
 
-	perr(F("DEBUG ffmpeg finished in <%v>", time.Since(t0).Truncate(time.Second)))
+	LogFfmpeg.Debug("finished", "duration", time.Since(t0).Truncate(time.Second))
 
 	return nil
 }
@@ -1696,8 +2391,13 @@ func (sr *ThrottledReader) Read(p []byte) (int, error) {
 	return n, err
 }
 
-func getJson(url string, target interface{}, respjson *string) (err error) {
-	resp, err := HttpClient.Get(url)
+func getJson(ctx context.Context, url string, target interface{}, respjson *string) (err error) {
+	defer func() { metricsYtApiCall(url, err) }()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := HttpClient.Do(req)
 	if err != nil {
 		return err
 	}
@@ -1717,8 +2417,7 @@ func getJson(url string, target interface{}, respjson *string) (err error) {
 		return fmt.Errorf("json.Decoder.Decode %w", err)
 	}
 
-	//perr(F("DEBUG getJson [%s] ContentLength <%s> @Body [-"+NL+"%s"+NL+"-]", url, seps(resp.ContentLength, 3), respBody))
-	perr(F("DEBUG getJson [%s] ContentLength <%s>", url, seps(uint64(resp.ContentLength), 3)))
+	LogYt.Debug("getJson", "url", url, "content_length", resp.ContentLength)
 	if respjson != nil {
 		*respjson = string(respBody)
 	}
@@ -1726,6 +2425,33 @@ func getJson(url string, target interface{}, respjson *string) (err error) {
 	return nil
 }
 
+// dssGet gets the video media from dss, media is audio video or thumb, the response body has to be closed
+func dssGet(ctx context.Context, v YtVideo, media string) (*http.Response, error) {
+	url := fmt.Sprintf("%s/%s/youtu.be/%s", ConfigFrom(ctx).DssUrl, media, v.Id)
+	LogDss.Debug("http get", "url", url, "video_id", v.Id, "backend", MetricsBackendDss)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := HttpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	LogDss.Debug("http get response", "url", url, "video_id", v.Id, "backend", MetricsBackendDss, "status", resp.StatusCode, "content_length", resp.ContentLength)
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("http get [%s] status code <%d>", url, resp.StatusCode)
+	}
+	return resp, nil
+}
+
+// tempRemove removes the temporary file of a post when it is done, failed or cancelled, files removed before are skipped
+func tempRemove(log *slog.Logger, filename string) {
+	if err := os.Remove(filename); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Error("os.Remove", "file", filename, "err", err)
+	}
+}
+
 func fileExists(path string) bool {
 	_, err := os.Stat(path)
 	if err != nil && errors.Is(err, os.ErrNotExist) {
@@ -1734,8 +2460,12 @@ func fileExists(path string) bool {
 	return true
 }
 
-func downloadFile(url string) ([]byte, error) {
-	resp, err := HttpClient.Get(url)
+func downloadFile(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := HttpClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -1804,35 +2534,8 @@ func seps(i uint64, e uint64) string {
 	}
 }
 
-func perr(msgtext string) {
-	if strings.HasPrefix(msgtext, "DEBUG ") && !Config.DEBUG {
-		return
-	}
-	tnow := time.Now()
-	if Config.TgToken != "" {
-		msgtext = strings.ReplaceAll(msgtext, Config.TgToken, "[Config.TgToken]")
-	}
-	if Config.YtKey != "" {
-		msgtext = strings.ReplaceAll(msgtext, Config.YtKey, "[Config.YtKey]")
-	}
-	fmt.Fprint(os.Stderr, "<"+fmttime(tnow)+">"+SP+msgtext+NL)
-}
-
 func (config *TgZeConfig) Get() error {
-	req, err := http.NewRequest(http.MethodGet, config.YssUrl, nil)
-	if err != nil {
-		return err
-	}
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return err
-	}
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("yss response status %s", resp.Status)
-	}
-
-	rbb, err := io.ReadAll(resp.Body)
+	rbb, err := ConfigStoreCl.Get()
 	if err != nil {
 		return err
 	}
@@ -1841,13 +2544,10 @@ func (config *TgZeConfig) Get() error {
 		return err
 	}
 
-	//perr(F("DEBUG Config.Get %+v", config))
-
 	return nil
 }
 
 func (config *TgZeConfig) Put() error {
-	//perr(F("DEBUG Config.Put url [%s] %+v", config.YssUrl, config))
 
 	// https://pkg.go.dev/github.com/goccy/go-yaml#MarshalWithOptions
 	rbb, err := yaml.MarshalWithOptions(config, yaml.JSON(), yaml.Flow(false))
@@ -1855,18 +2555,5 @@ func (config *TgZeConfig) Put() error {
 		return err
 	}
 
-	req, err := http.NewRequest(http.MethodPut, config.YssUrl, bytes.NewBuffer(rbb))
-	if err != nil {
-		return err
-	}
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return err
-	}
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("yss response status %s", resp.Status)
-	}
-
-	return nil
+	return ConfigStoreCl.Put(rbb)
 }
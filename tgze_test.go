@@ -0,0 +1,1457 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shoce/tg"
+)
+
+// fake services the bot talks to, started once in TestMain
+
+type TgCall struct {
+	Method string
+	Params map[string]string
+}
+
+type FakeTgFailure struct {
+	Method string
+	ChatId string
+	Status int
+	Body   string
+}
+
+type FakeTg struct {
+	mu sync.Mutex
+
+	Calls   []TgCall
+	Updates []string
+	// Files maps file_id to file_path returned by getFile
+	Files map[string]string
+	// Failures are returned once each to the first matching calls instead of the result
+	Failures []FakeTgFailure
+
+	lastmessageid int64
+}
+
+func (ftg *FakeTg) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	method := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+
+	params := make(map[string]string)
+	for k, vv := range r.URL.Query() {
+		params[k] = vv[0]
+	}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := r.ParseMultipartForm(64 << 20); err == nil {
+			for k, vv := range r.MultipartForm.Value {
+				params[k] = vv[0]
+			}
+			for k, ff := range r.MultipartForm.File {
+				params[k] = ff[0].Filename
+			}
+		}
+	} else if r.Method == http.MethodPost {
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err == nil {
+			for k, v := range body {
+				if s, ok := v.(string); ok {
+					params[k] = s
+				} else {
+					vjson, _ := json.Marshal(v)
+					params[k] = string(vjson)
+				}
+			}
+		}
+	}
+
+	ftg.mu.Lock()
+	ftg.Calls = append(ftg.Calls, TgCall{Method: method, Params: params})
+	ftg.lastmessageid++
+	messageid := ftg.lastmessageid
+	updates := strings.Join(ftg.Updates, ",")
+	filepath := ftg.Files[params["file_id"]]
+	var failure *FakeTgFailure
+	if i := slices.IndexFunc(ftg.Failures, func(f FakeTgFailure) bool {
+		return f.Method == method && f.ChatId == params["chat_id"]
+	}); i >= 0 {
+		failure = &ftg.Failures[i]
+		ftg.Failures = slices.Delete(slices.Clone(ftg.Failures), i, i+1)
+	}
+	ftg.mu.Unlock()
+
+	if failure != nil {
+		w.WriteHeader(failure.Status)
+		fmt.Fprint(w, failure.Body)
+		return
+	}
+
+	switch method {
+	case "getUpdates":
+		fmt.Fprintf(w, `{"ok":true,"result":[%s]}`, updates)
+	case "getChatAdministrators":
+		fmt.Fprintf(w, `{"ok":true,"result":[{"user":{"id":%d,"username":"user"},"status":"administrator"}]}`, TestUserId)
+	case "getChat":
+		fmt.Fprintf(w, `{"ok":true,"result":{"id":%s,"type":"channel","title":"chat %s"}}`, params["chat_id"], params["chat_id"])
+	case "getFile":
+		if filepath == "" {
+			fmt.Fprint(w, `{"ok":false,"description":"Bad Request: invalid file_id"}`)
+			return
+		}
+		fi, _ := os.Stat(filepath)
+		fmt.Fprintf(w, `{"ok":true,"result":{"file_id":%q,"file_size":%d,"file_path":%q}}`, params["file_id"], fi.Size(), filepath)
+	case "setMessageReaction", "deleteMessage", "promoteChatMember", "setWebhook", "deleteWebhook", "answerCallbackQuery":
+		fmt.Fprint(w, `{"ok":true,"result":true}`)
+	default:
+		fmt.Fprintf(w, `{"ok":true,"result":{"message_id":%d,"chat":{"id":%s},"audio":{"file_id":"audio%d"},"video":{"file_id":"video%d"}}}`, messageid, params["chat_id"], messageid, messageid)
+	}
+}
+
+// CallsFor returns calls of the method to the chat
+func (ftg *FakeTg) CallsFor(method string, chatid int64) (cc []TgCall) {
+	ftg.mu.Lock()
+	defer ftg.mu.Unlock()
+	for _, c := range ftg.Calls {
+		if c.Method == method && c.Params["chat_id"] == fmt.Sprintf("%d", chatid) {
+			cc = append(cc, c)
+		}
+	}
+	return cc
+}
+
+// WaitFor waits until there are at least n calls of the method to the chat
+func (ftg *FakeTg) WaitFor(t *testing.T, method string, chatid int64, n int) []TgCall {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		cc := ftg.CallsFor(method, chatid)
+		if len(cc) >= n {
+			return cc
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s calls to chat id <%d> count <%d> expected <%d>", method, chatid, len(cc), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func FakeDss() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/info/youtu.be/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		if strings.HasPrefix(id, "broken") {
+			http.Error(w, "broken", http.StatusInternalServerError)
+			return
+		}
+		description := ""
+		if strings.HasPrefix(id, "chapters") {
+			description = "0:00 Intro\n0:20 Artist - Song\n0:40 Outro"
+		}
+		fmt.Fprintf(w, `{"Id":%q,"Channel":"Channel","Title":"Title %s","FullTitle":"Title %s","Description":%q,"Timestamp":1700000000,"Duration":60,"Abr":128,"Width":640,"Height":360}`, id, id, id, description)
+	})
+	for _, kind := range []string{"audio", "video", "thumb"} {
+		mux.HandleFunc("/"+kind+"/youtu.be/{id}", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, "%s %s", kind, r.PathValue("id"))
+		})
+	}
+	return mux
+}
+
+func FakeYtApi() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/playlists", func(w http.ResponseWriter, r *http.Request) {
+		id := r.URL.Query().Get("id")
+		if strings.HasPrefix(id, "RD") {
+			fmt.Fprint(w, `{"items":[]}`)
+			return
+		}
+		fmt.Fprintf(w, `{"items":[{"snippet":{"title":"List %s","thumbnails":{"high":{"url":"https://i.ytimg.com/%s.jpg"}}}}]}`, id, id)
+	})
+	mux.HandleFunc("/channels", func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Query().Get("forHandle"), "@")
+		if id := r.URL.Query().Get("id"); id != "" {
+			name = strings.TrimPrefix(id, "UC")
+		}
+		fmt.Fprintf(w, `{"items":[{"id":"UC%s","snippet":{"title":"Channel %s"},"contentDetails":{"relatedPlaylists":{"uploads":"UU%s"}}}]}`, name, name, name)
+	})
+	mux.HandleFunc("/playlistItems", func(w http.ResponseWriter, r *http.Request) {
+		id := r.URL.Query().Get("playlistId")
+		FakeYtListsMu.Lock()
+		fakeitems, ok := FakeYtLists[id]
+		FakeYtListsMu.Unlock()
+		if ok {
+			// the items are paged by maxResults with the offset of the next page as its token
+			offset, _ := strconv.Atoi(r.URL.Query().Get("pageToken"))
+			end := len(fakeitems)
+			if maxresults, _ := strconv.Atoi(r.URL.Query().Get("maxResults")); maxresults > 0 && offset+maxresults < end {
+				end = offset + maxresults
+			}
+			var items []string
+			for i, fi := range fakeitems {
+				if i < offset || i >= end {
+					continue
+				}
+				if fi.Title == "" {
+					fi.Title = "Video " + fi.Id
+				}
+				if fi.PrivacyStatus == "" {
+					fi.PrivacyStatus = "public"
+				}
+				items = append(items, fmt.Sprintf(`{"snippet":{"title":%q,"publishedAt":%q,"position":%d,"resourceId":{"videoId":%q}},"status":{"privacyStatus":%q}}`, fi.Title, fi.PublishedAt, i, fi.Id, fi.PrivacyStatus))
+			}
+			var nextpagetoken string
+			if end < len(fakeitems) {
+				nextpagetoken = strconv.Itoa(end)
+			}
+			fmt.Fprintf(w, `{"nextPageToken":%q,"items":[%s]}`, nextpagetoken, strings.Join(items, ","))
+			return
+		}
+		item := func(i int) string {
+			return fmt.Sprintf(`{"snippet":{"title":"Video %d","position":%d,"resourceId":{"videoId":"%s.v%d"}},"status":{"privacyStatus":"public"}}`, i, i, id, i)
+		}
+		if r.URL.Query().Get("pageToken") == "" {
+			fmt.Fprintf(w, `{"nextPageToken":"page2","items":[%s,%s]}`, item(0), item(1))
+		} else {
+			fmt.Fprintf(w, `{"items":[%s]}`, item(2))
+		}
+	})
+	return mux
+}
+
+var (
+	FakeTgServer = &FakeTg{Files: make(map[string]string)}
+
+	// FakeYtLists maps list ids to the list items served in pages of maxResults instead of the generated ones
+	FakeYtLists   = make(map[string][]FakeYtItem)
+	FakeYtListsMu sync.Mutex
+)
+
+type FakeYtItem struct {
+	Id, PublishedAt, Title, PrivacyStatus string
+}
+
+const (
+	TestTgZeChatId = 1
+	TestUserId     = 2
+)
+
+func TestMain(m *testing.M) {
+	tmpdir, err := os.MkdirTemp("", "tgze")
+	if err != nil {
+		panic(err)
+	}
+
+	// ffmpeg stand-in copying the input file to the output file
+	ffmpegpath := filepath.Join(tmpdir, "ffmpeg")
+	ffmpegscript := `#!/bin/sh
+in="" ; out=""
+while [ $# -gt 0 ] ; do
+	case "$1" in -i) in="$2" ; shift ;; esac
+	out="$1" ; shift
+done
+cp "$in" "$out"
+`
+	if err := os.WriteFile(ffmpegpath, []byte(ffmpegscript), 0700); err != nil {
+		panic(err)
+	}
+
+	tgserver := httptest.NewServer(FakeTgServer)
+	dssserver := httptest.NewServer(FakeDss())
+	ytserver := httptest.NewServer(FakeYtApi())
+
+	ConfigStoreCl = &MemStore{Data: []byte(fmt.Sprintf(`
+DEBUG: false
+Interval: 1s
+TgApiUrl: %q
+TgToken: "tgtoken"
+TgZeChatId: %d
+TgUpdateLogMaxSize: 333
+TgRetryBackoff: 10ms
+TgWebhookSecretToken: "webhooksecret"
+TgCommandChannelsPromoteAdmin: "/promote"
+TgMaxFileSizeBytes: 1000000
+FfmpegPath: %q
+DssUrl: %q
+JobWorkers: 2
+YtApiUrl: %q
+YtKey: "ytkey"
+YtRe: '(?:youtube.com/watch\?v=|youtu.be/|youtube.com/watch/|youtube.com/shorts/|youtube.com/live/)([0-9A-Za-z_-]+)'
+YtListRe: 'youtube.com/playlist\?list=([0-9A-Za-z_-]+)'
+YtListSleep: 1ms
+AccessList:
+  - {Id: %d, Role: user, QuotaJobs: -1, QuotaBytes: -1}
+`, tgserver.URL, TestTgZeChatId, ffmpegpath, dssserver.URL, ytserver.URL, TestUserId))}
+
+	if err := ConfigInit(); err != nil {
+		panic(err)
+	}
+
+	Jobs.Start(Config.JobWorkers)
+
+	code := m.Run()
+
+	tgserver.Close()
+	dssserver.Close()
+	ytserver.Close()
+	os.RemoveAll(tmpdir)
+
+	os.Exit(code)
+}
+
+var (
+	testUpdateId int64
+	testUpdateMu sync.Mutex
+)
+
+// testMessageUpdate returns a message update with a new update id and its json
+func testMessageUpdate(t *testing.T, chatid int64, chattitle string, text string) (tg.Update, string) {
+	t.Helper()
+	testUpdateMu.Lock()
+	testUpdateId++
+	updateid := testUpdateId
+	testUpdateMu.Unlock()
+
+	tgupdatejson := fmt.Sprintf(
+		`{"update_id":%d,"message":{"message_id":%d,"from":{"id":%d,"username":"user"},"chat":{"id":%d,"type":"supergroup","title":%q},"text":%q}}`,
+		updateid, 1000+updateid, TestUserId, chatid, chattitle, text,
+	)
+	var u tg.Update
+	if err := json.Unmarshal([]byte(tgupdatejson), &u); err != nil {
+		t.Fatalf("json.Unmarshal %v", err)
+	}
+	return u, tgupdatejson
+}
+
+func TestTgYtLinkAudio(t *testing.T) {
+	const chatid = 101
+	u, ujson := testMessageUpdate(t, chatid, "audio", "https://www.youtube.com/watch?v=id101")
+	if err := TgHandleUpdate(u, ujson); err != nil {
+		t.Fatalf("TgHandleUpdate %v", err)
+	}
+
+	cc := FakeTgServer.WaitFor(t, "sendAudio", chatid, 1)
+	if !strings.Contains(cc[0].Params["caption"], "youtu.be/id101") {
+		t.Errorf("sendAudio caption [%s]", cc[0].Params["caption"])
+	}
+	if cc[0].Params["title"] != "Title id101" {
+		t.Errorf("sendAudio title [%s]", cc[0].Params["title"])
+	}
+
+	ee := FakeTgServer.CallsFor("editMessageText", chatid)
+	if len(ee) != 1 || ee[0].Params["text"] != tg.Esc("youtu.be/id101") {
+		t.Errorf("editMessageText calls %+v", ee)
+	}
+
+	rr := FakeTgServer.CallsFor("setMessageReaction", chatid)
+	if len(rr) != 1 || !strings.Contains(rr[0].Params["reaction"], "👾") {
+		t.Errorf("setMessageReaction calls %+v", rr)
+	}
+}
+
+func TestTgYtLinkVideo(t *testing.T) {
+	const chatid = 102
+	u, ujson := testMessageUpdate(t, chatid, "video", "youtu.be/id102")
+	if err := TgHandleUpdate(u, ujson); err != nil {
+		t.Fatalf("TgHandleUpdate %v", err)
+	}
+
+	cc := FakeTgServer.WaitFor(t, "sendVideo", chatid, 1)
+	if !strings.Contains(cc[0].Params["caption"], "youtu.be/id102") || cc[0].Params["height"] != "360" {
+		t.Errorf("sendVideo params %+v", cc[0].Params)
+	}
+	if ee := FakeTgServer.CallsFor("editMessageText", chatid); len(ee) != 0 {
+		t.Errorf("editMessageText calls %+v", ee)
+	}
+}
+
+func TestTgYtList(t *testing.T) {
+	const chatid = 103
+	u, ujson := testMessageUpdate(t, chatid, "audio", "https://www.youtube.com/playlist?list=PL103")
+	if err := TgHandleUpdate(u, ujson); err != nil {
+		t.Fatalf("TgHandleUpdate %v", err)
+	}
+
+	pp := FakeTgServer.CallsFor("sendPhoto", chatid)
+	if len(pp) != 1 || pp[0].Params["photo"] != "https://i.ytimg.com/PL103.jpg" {
+		t.Errorf("sendPhoto calls %+v", pp)
+	}
+
+	cc := FakeTgServer.WaitFor(t, "sendAudio", chatid, 3)
+	for i, c := range cc {
+		if !strings.Contains(c.Params["caption"], fmt.Sprintf("youtu.be/PL103.v%d", i)) || !strings.Contains(c.Params["caption"], fmt.Sprintf("%d/3 List PL103", i+1)) {
+			t.Errorf("sendAudio <%d> caption [%s]", i, c.Params["caption"])
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		ConfigMu.Lock()
+		n := len(Config.YtListJobs)
+		ConfigMu.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("YtListJobs %+v", Config.YtListJobs)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTgErrorReaction(t *testing.T) {
+	const chatid = 104
+	u, ujson := testMessageUpdate(t, chatid, "audio", "youtu.be/broken104")
+	if err := TgHandleUpdate(u, ujson); err != nil {
+		t.Fatalf("TgHandleUpdate %v", err)
+	}
+
+	rr := FakeTgServer.WaitFor(t, "setMessageReaction", chatid, 2)
+	if !strings.Contains(rr[1].Params["reaction"], "🤷") {
+		t.Errorf("setMessageReaction calls %+v", rr)
+	}
+	if cc := FakeTgServer.CallsFor("sendAudio", chatid); len(cc) != 0 {
+		t.Errorf("sendAudio calls %+v", cc)
+	}
+}
+
+func TestTgAudioCompress(t *testing.T) {
+	const chatid = 105
+
+	audiopath := filepath.Join(t.TempDir(), "audio.m4a")
+	if err := os.WriteFile(audiopath, []byte("audio"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	FakeTgServer.mu.Lock()
+	FakeTgServer.Files["file105"] = audiopath
+	FakeTgServer.mu.Unlock()
+
+	u, _ := testMessageUpdate(t, chatid, "audio", Config.TgCommandAudioCompress)
+	u.Message.ReplyToMessage = &tg.Message{
+		MessageId: 5,
+		Chat:      u.Message.Chat,
+		Caption:   "caption105",
+		Audio: tg.Audio{
+			FileId:    "file105",
+			Performer: "Performer",
+			Title:     "Title",
+			Duration:  60,
+		},
+	}
+	if err := TgHandleUpdate(u, ""); err != nil {
+		t.Fatalf("TgHandleUpdate %v", err)
+	}
+
+	cc := FakeTgServer.WaitFor(t, "sendAudio", chatid, 1)
+	if len(cc) != 1 || cc[0].Params["caption"] != "caption105 audio-compressed" || cc[0].Params["title"] != "Title" {
+		t.Errorf("sendAudio calls %+v", cc)
+	}
+	for i := 0; i < 50 && fileExists(audiopath+".audio.compress..m4a"); i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if fileExists(audiopath + ".audio.compress..m4a") {
+		t.Errorf("compressed file not removed")
+	}
+}
+
+func TestTgWebhook(t *testing.T) {
+	const chatid = 106
+	_, ujson := testMessageUpdate(t, chatid, "audio", "/id")
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(ujson))
+	req.Header.Set(TgWebhookSecretTokenHeader, "wrong")
+	w := httptest.NewRecorder()
+	TgWebhookHandler(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("wrong secret token response status <%d>", w.Code)
+	}
+	if cc := FakeTgServer.CallsFor("sendMessage", chatid); len(cc) != 0 {
+		t.Errorf("sendMessage calls %+v", cc)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(ujson))
+	req.Header.Set(TgWebhookSecretTokenHeader, Config.TgWebhookSecretToken)
+	w = httptest.NewRecorder()
+	TgWebhookHandler(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("response status <%d>", w.Code)
+	}
+	cc := FakeTgServer.CallsFor("sendMessage", chatid)
+	if len(cc) != 1 || !strings.Contains(cc[0].Params["text"], fmt.Sprintf("%d", chatid)) {
+		t.Errorf("sendMessage calls %+v", cc)
+	}
+}
+
+func TestTgGetUpdates(t *testing.T) {
+	const chatid = 107
+	u, ujson := testMessageUpdate(t, chatid, "audio", "/id")
+	FakeTgServer.mu.Lock()
+	FakeTgServer.Updates = []string{ujson}
+	FakeTgServer.mu.Unlock()
+	defer func() {
+		FakeTgServer.mu.Lock()
+		FakeTgServer.Updates = nil
+		FakeTgServer.mu.Unlock()
+	}()
+
+	// the second call receives the same update which should be skipped
+	for i := 0; i < 2; i++ {
+		if err := TgGetUpdates(); err != nil {
+			t.Fatalf("TgGetUpdates %v", err)
+		}
+	}
+
+	if cc := FakeTgServer.CallsFor("sendMessage", chatid); len(cc) != 1 {
+		t.Errorf("sendMessage calls %+v", cc)
+	}
+	if Config.TgUpdateLog[len(Config.TgUpdateLog)-1] != u.UpdateId {
+		t.Errorf("TgUpdateLog %v", Config.TgUpdateLog)
+	}
+}
+
+func TestGetList(t *testing.T) {
+	ytlist, err := getList(Ctx, "PLget", 0, -1)
+	if err != nil {
+		t.Fatalf("getList %v", err)
+	}
+	if ytlist.Title != "List PLget" || ytlist.Size != 3 || ytlist.ThumbUrl != "https://i.ytimg.com/PLget.jpg" {
+		t.Errorf("getList %+v", ytlist)
+	}
+	if len(ytlist.Videos) != 3 {
+		t.Errorf("getList videos %+v", ytlist.Videos)
+	}
+	for i, v := range ytlist.Videos {
+		if v.Id != fmt.Sprintf("PLget.v%d", i) || v.PlaylistIndex != int64(i) {
+			t.Errorf("getList video <%d> %+v", i, v)
+		}
+	}
+
+	ytlist, err = getList(Ctx, "PLget", 1, 1)
+	if err != nil {
+		t.Fatalf("getList %v", err)
+	}
+	if ytlist.Size != 3 || len(ytlist.Videos) != 1 || ytlist.Videos[0].Id != "PLget.v1" {
+		t.Errorf("getList range %+v", ytlist)
+	}
+}
+
+func TestTgYtClip(t *testing.T) {
+	const chatid = 109
+	u, uj := testMessageUpdate(t, chatid, "", "https://youtu.be/clip1?t=10 0:10-0:40")
+	if _, err := processTgUpdate(u, uj); err != nil {
+		t.Fatalf("processTgUpdate %v", err)
+	}
+
+	cc := FakeTgServer.WaitFor(t, "sendAudio", chatid, 1)
+	if !strings.Contains(cc[0].Params["caption"], "clip 0:10-0:40") || cc[0].Params["duration"] != "30" {
+		t.Errorf("sendAudio %+v", cc[0].Params)
+	}
+	if ee := FakeTgServer.CallsFor("editMessageText", chatid); len(ee) != 1 || ee[0].Params["text"] != tg.Esc("youtu.be/clip1 0:10-0:40") {
+		t.Errorf("editMessageText calls %+v", ee)
+	}
+}
+
+func TestTgYtChapters(t *testing.T) {
+	const chatid = 110
+	u, uj := testMessageUpdate(t, chatid, "", "https://youtu.be/chapters1 chapters")
+	if _, err := processTgUpdate(u, uj); err != nil {
+		t.Fatalf("processTgUpdate %v", err)
+	}
+
+	cc := FakeTgServer.WaitFor(t, "sendAudio", chatid, 3)
+	for i, want := range []struct{ caption, performer, title string }{
+		{"chapter 1/3 Intro", "Channel", "Intro"},
+		{"chapter 2/3 Artist - Song", "Artist", "Song"},
+		{"chapter 3/3 Outro", "Channel", "Outro"},
+	} {
+		if !strings.Contains(cc[i].Params["caption"], want.caption) || cc[i].Params["performer"] != want.performer || cc[i].Params["title"] != want.title || cc[i].Params["duration"] != "20" {
+			t.Errorf("sendAudio <%d> %+v", i, cc[i].Params)
+		}
+	}
+}
+
+func testCallbackQueryUpdate(t *testing.T, chatid int64, userid int64, data string) (tg.Update, string) {
+	t.Helper()
+	testUpdateMu.Lock()
+	testUpdateId++
+	updateid := testUpdateId
+	testUpdateMu.Unlock()
+
+	tgupdatejson := fmt.Sprintf(
+		`{"update_id":%d,"callback_query":{"id":"cq%d","from":{"id":%d,"username":"user"},"message":{"message_id":%d,"chat":{"id":%d,"type":"supergroup","title":"chat"}},"data":%q}}`,
+		updateid, updateid, userid, 1000+updateid, chatid, data,
+	)
+	var u tg.Update
+	if err := json.Unmarshal([]byte(tgupdatejson), &u); err != nil {
+		t.Fatalf("json.Unmarshal %v", err)
+	}
+	return u, tgupdatejson
+}
+
+func TestTgSettings(t *testing.T) {
+	const chatid = 111
+	u, uj := testMessageUpdate(t, chatid, "audio", "/settings")
+	if err := TgHandleUpdate(u, uj); err != nil {
+		t.Fatalf("TgHandleUpdate %v", err)
+	}
+	if cc := FakeTgServer.CallsFor("sendMessage", chatid); len(cc) != 1 || !strings.Contains(cc[0].Params["reply_markup"], `"callback_data":"settings media both"`) {
+		t.Fatalf("sendMessage calls %+v", cc)
+	}
+
+	// not an admin
+	u, uj = testCallbackQueryUpdate(t, chatid, TestUserId+1, "settings media both")
+	if err := TgHandleUpdate(u, uj); err != nil {
+		t.Fatalf("TgHandleUpdate %v", err)
+	}
+	if cs := ChatSettingsGet(chatid); cs.MediaMode != "" {
+		t.Errorf("ChatSettings %+v", cs)
+	}
+
+	u, uj = testCallbackQueryUpdate(t, chatid, TestUserId, "settings media both")
+	if err := TgHandleUpdate(u, uj); err != nil {
+		t.Fatalf("TgHandleUpdate %v", err)
+	}
+	if cs := ChatSettingsGet(chatid); cs.MediaMode != ChatMediaBoth {
+		t.Errorf("ChatSettings %+v", cs)
+	}
+	if cc := FakeTgServer.CallsFor("editMessageText", chatid); len(cc) != 1 || !strings.Contains(cc[0].Params["reply_markup"], `"✓ both"`) {
+		t.Errorf("editMessageText calls %+v", cc)
+	}
+	FakeTgServer.mu.Lock()
+	var answers int
+	for _, c := range FakeTgServer.Calls {
+		if c.Method == "answerCallbackQuery" && (c.Params["callback_query_id"] == fmt.Sprintf("cq%d", u.UpdateId) || c.Params["callback_query_id"] == fmt.Sprintf("cq%d", u.UpdateId-1)) {
+			answers++
+		}
+	}
+	FakeTgServer.mu.Unlock()
+	if answers != 2 {
+		t.Errorf("answerCallbackQuery calls <%d>", answers)
+	}
+
+	u, uj = testMessageUpdate(t, chatid, "audio", "https://youtu.be/id111")
+	if err := TgHandleUpdate(u, uj); err != nil {
+		t.Fatalf("TgHandleUpdate %v", err)
+	}
+	FakeTgServer.WaitFor(t, "sendAudio", chatid, 1)
+	FakeTgServer.WaitFor(t, "sendVideo", chatid, 1)
+
+	cs := ChatSettingsGet(chatid)
+	cs.MediaMode = ""
+	if err := ChatSettingsPut(cs); err != nil {
+		t.Fatal(err)
+	}
+	if len(Config.ChatSettings) != 0 {
+		t.Errorf("ChatSettings %+v", Config.ChatSettings)
+	}
+}
+
+func TestTgLanguageDss(t *testing.T) {
+	const chatid = 124
+	u, uj := testMessageUpdate(t, chatid, "audio", "https://youtu.be/lang1 de")
+	if err := TgHandleUpdate(u, uj); err != nil {
+		t.Fatalf("TgHandleUpdate %v", err)
+	}
+	if cc := FakeTgServer.CallsFor("sendMessage", chatid); len(cc) != 1 || !strings.Contains(cc[0].Params["text"], "dss") {
+		t.Errorf("sendMessage calls %+v", cc)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if cc := FakeTgServer.CallsFor("sendAudio", chatid); len(cc) != 0 {
+		t.Errorf("sendAudio calls %+v", cc)
+	}
+}
+
+func TestTgFileCache(t *testing.T) {
+	u, uj := testMessageUpdate(t, 112, "audio", "https://youtu.be/cache1")
+	if err := TgHandleUpdate(u, uj); err != nil {
+		t.Fatalf("TgHandleUpdate %v", err)
+	}
+	cc := FakeTgServer.WaitFor(t, "sendAudio", 112, 1)
+	if strings.HasPrefix(cc[0].Params["audio"], "audio") {
+		t.Fatalf("sendAudio not an upload %+v", cc[0].Params)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	hits := TgFileCacheHits.Load()
+	u, uj = testMessageUpdate(t, 113, "audio", "https://youtu.be/cache1")
+	if err := TgHandleUpdate(u, uj); err != nil {
+		t.Fatalf("TgHandleUpdate %v", err)
+	}
+	cc = FakeTgServer.WaitFor(t, "sendAudio", 113, 1)
+	if !strings.HasPrefix(cc[0].Params["audio"], "audio") || !strings.Contains(cc[0].Params["caption"], "youtu.be/cache1") || cc[0].Params["duration"] != "60" {
+		t.Errorf("sendAudio by file id %+v", cc[0].Params)
+	}
+	if TgFileCacheHits.Load() != hits+1 {
+		t.Errorf("TgFileCacheHits <%d>", TgFileCacheHits.Load())
+	}
+
+	u, uj = testMessageUpdate(t, TestTgZeChatId, "", "/stats")
+	if err := TgHandleUpdate(u, uj); err != nil {
+		t.Fatalf("TgHandleUpdate %v", err)
+	}
+	var stats bool
+	for _, c := range FakeTgServer.CallsFor("sendMessage", TestTgZeChatId) {
+		if strings.Contains(c.Params["text"], "file cache entries") {
+			stats = true
+		}
+	}
+	if !stats {
+		t.Errorf("no stats message")
+	}
+}
+
+func TestTgFileCacheMove(t *testing.T) {
+	ConfigMu.Lock()
+	Config.TgFileCache = []TgFileCacheEntry{{Key: "move1 audio a0", FileId: "audiomove1"}}
+	ConfigMu.Unlock()
+
+	if err := TgFileCacheInit(); err != nil {
+		t.Fatalf("TgFileCacheInit %v", err)
+	}
+	if e, ok := TgFileCacheGet("move1 audio a0"); !ok || e.FileId != "audiomove1" {
+		t.Errorf("TgFileCacheGet %v %+v", ok, e)
+	}
+	if _, ok := TgFileCacheGet("cache1 audio a0"); !ok {
+		t.Errorf("TgFileCacheGet of the entry cached before lost")
+	}
+	ConfigMu.Lock()
+	defer ConfigMu.Unlock()
+	if len(Config.TgFileCache) != 0 {
+		t.Errorf("Config.TgFileCache %+v", Config.TgFileCache)
+	}
+}
+
+func TestTgYtListIndex(t *testing.T) {
+	const chatid = 108
+	u, uj := testMessageUpdate(t, chatid, "", "https://www.youtube.com/watch?v=abc&list=PLidx&index=2")
+	if _, err := processTgUpdate(u, uj); err != nil {
+		t.Fatalf("processTgUpdate %v", err)
+	}
+
+	FakeTgServer.WaitFor(t, "sendAudio", chatid, 2)
+	time.Sleep(100 * time.Millisecond)
+	cc := FakeTgServer.CallsFor("sendAudio", chatid)
+	if len(cc) != 2 {
+		t.Errorf("sendAudio calls %+v", cc)
+	}
+	for i, c := range cc {
+		if !strings.Contains(c.Params["caption"], fmt.Sprintf("youtu.be/PLidx.v%d", i+1)) {
+			t.Errorf("sendAudio <%d> caption [%s]", i, c.Params["caption"])
+		}
+	}
+	if cc := FakeTgServer.CallsFor("editMessageText", chatid); len(cc) != 1 || cc[0].Params["text"] != tg.Esc("youtube.com/watch?v=abc&list=PLidx&index=2") {
+		t.Errorf("editMessageText calls %+v", cc)
+	}
+}
+
+func TestTgYtListMix(t *testing.T) {
+	const chatid = 129
+	u, uj := testMessageUpdate(t, chatid, "", "https://www.youtube.com/watch?v=mix1&list=RDmix1&index=2")
+	if _, err := processTgUpdate(u, uj); err != nil {
+		t.Fatalf("processTgUpdate %v", err)
+	}
+
+	cc := FakeTgServer.WaitFor(t, "sendAudio", chatid, 1)
+	if !strings.Contains(cc[0].Params["caption"], "youtu.be/mix1") {
+		t.Errorf("sendAudio caption [%s]", cc[0].Params["caption"])
+	}
+	if cc := FakeTgServer.CallsFor("sendPhoto", chatid); len(cc) != 0 {
+		t.Errorf("sendPhoto calls %+v", cc)
+	}
+}
+
+func TestTgYtListUnavailable(t *testing.T) {
+	const chatid = 130
+	FakeYtListsMu.Lock()
+	FakeYtLists["PLunavailable"] = []FakeYtItem{{Id: "unavailable1", PrivacyStatus: "private"}, {Id: "available1"}, {Id: "unavailable2", PrivacyStatus: "private"}, {Id: "available2"}}
+	FakeYtListsMu.Unlock()
+
+	u, uj := testMessageUpdate(t, chatid, "", "https://www.youtube.com/playlist?list=PLunavailable")
+	if _, err := processTgUpdate(u, uj); err != nil {
+		t.Fatalf("processTgUpdate %v", err)
+	}
+
+	FakeTgServer.WaitFor(t, "sendAudio", chatid, 2)
+	time.Sleep(100 * time.Millisecond)
+	cc := FakeTgServer.CallsFor("sendAudio", chatid)
+	if len(cc) != 2 || !strings.Contains(cc[0].Params["caption"], "youtu.be/available1") || !strings.Contains(cc[1].Params["caption"], "youtu.be/available2") {
+		t.Errorf("sendAudio calls %+v", cc)
+	}
+	if cc := FakeTgServer.CallsFor("sendPhoto", chatid); len(cc) != 1 || !strings.Contains(cc[0].Params["caption"], tg.Esc("<2> private or deleted videos skipped")) {
+		t.Errorf("sendPhoto calls %+v", cc)
+	}
+}
+
+func TestYtListJobsResume(t *testing.T) {
+	const chatid = 126
+	jobs0 := Jobs
+	Jobs = NewJobScheduler()
+	t.Cleanup(func() { Jobs = jobs0 })
+
+	ConfigMu.Lock()
+	Config.YtListJobs = append(Config.YtListJobs, YtListJob{UpdateId: 1260, ChatId: chatid, MessageId: 1261, FromId: TestUserId, ListId: "PLresume", NextIndex: 1})
+	ConfigMu.Unlock()
+	t.Cleanup(func() {
+		ConfigMu.Lock()
+		Config.YtListJobs = slices.DeleteFunc(Config.YtListJobs, func(lj YtListJob) bool { return lj.UpdateId == 1260 })
+		ConfigMu.Unlock()
+	})
+
+	if err := YtListJobsResume(); err != nil {
+		t.Fatalf("YtListJobsResume %v", err)
+	}
+	_, queued, _ := Jobs.Queue(chatid)
+	if len(queued) != 2 || queued[0].Video.Id != "PLresume.v1" || queued[0].Message.From.Id != TestUserId || queued[0].Message.MessageId != 1261 {
+		t.Errorf("resumed jobs %+v", queued)
+	}
+}
+
+func TestFfmpegAudioCompress(t *testing.T) {
+	dir := t.TempDir()
+	filename, filename2 := filepath.Join(dir, "a.m4a"), filepath.Join(dir, "b.m4a")
+	if err := os.WriteFile(filename, []byte("audio"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := FfmpegAudioCompress(Ctx, filename, filename2); err != nil {
+		t.Fatalf("FfmpegAudioCompress %v", err)
+	}
+	f, err := os.Open(filename2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if bb, _ := io.ReadAll(f); string(bb) != "audio" {
+		t.Errorf("output [%s]", bb)
+	}
+}
+
+func TestStageTimeouts(t *testing.T) {
+	ctx, cancel := context.WithCancel(Ctx)
+	cancel()
+	if _, err := getList(ctx, "PLget", 0, -1); !errors.Is(err, context.Canceled) {
+		t.Errorf("getList with cancelled context %v", err)
+	}
+
+	dir := t.TempDir()
+	ffmpegpath := filepath.Join(dir, "ffmpeg")
+	if err := os.WriteFile(ffmpegpath, []byte("#!/bin/sh"+NL+"exec sleep 10"+NL), 0700); err != nil {
+		t.Fatal(err)
+	}
+	ffmpegpath0, timeout0 := ConfigNow().FfmpegPath, ConfigNow().TranscodeTimeout
+	ConfigSet(func(c *TgZeConfig) { c.FfmpegPath, c.TranscodeTimeout = ffmpegpath, 100*time.Millisecond })
+	t.Cleanup(func() { ConfigSet(func(c *TgZeConfig) { c.FfmpegPath, c.TranscodeTimeout = ffmpegpath0, timeout0 }) })
+
+	t0 := time.Now()
+	if err := FfmpegAudioCompress(Ctx, filepath.Join(dir, "a.m4a"), filepath.Join(dir, "b.m4a")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("FfmpegAudioCompress over TranscodeTimeout %v", err)
+	}
+	if d := time.Since(t0); d > 5*time.Second {
+		t.Errorf("FfmpegAudioCompress killed after <%v>", d)
+	}
+
+	// the upload of a video that never ends is stopped by UploadTimeout
+	c := *ConfigNow()
+	c.UploadTimeout = 100 * time.Millisecond
+	t0 = time.Now()
+	if _, err := TgSendVideoFile(ConfigContext(Ctx, &c), tg.SendVideoFileRequest{ChatId: "1", Video: slowReader{}}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("TgSendVideoFile over UploadTimeout %v", err)
+	}
+	if d := time.Since(t0); d > 5*time.Second {
+		t.Errorf("TgSendVideoFile stopped after <%v>", d)
+	}
+}
+
+// slowReader is an endless video read slowly
+type slowReader struct{}
+
+func (slowReader) Read(p []byte) (int, error) {
+	time.Sleep(10 * time.Millisecond)
+	return min(len(p), 1024), nil
+}
+
+func TestTgSubscribe(t *testing.T) {
+	const chatid = 114
+	FakeYtListsMu.Lock()
+	FakeYtLists["UUsub"] = []FakeYtItem{{Id: "sub1", PublishedAt: "2024-01-01T00:00:00Z"}}
+	FakeYtListsMu.Unlock()
+
+	u, uj := testMessageUpdate(t, chatid, "", "/subscribe https://www.youtube.com/@Sub")
+	if _, err := processTgUpdate(u, uj); err != nil {
+		t.Fatalf("processTgUpdate %v", err)
+	}
+	ss := YtSubscriptionsGet(chatid)
+	if len(ss) != 1 || ss[0].ChannelId != "UCsub" || ss[0].UploadsListId != "UUsub" || ss[0].LastVideoId != "sub1" {
+		t.Fatalf("subscriptions %+v", ss)
+	}
+	if cc := FakeTgServer.CallsFor("sendMessage", chatid); len(cc) != 1 || !strings.Contains(cc[0].Params["text"], "subscribed to Channel sub") {
+		t.Errorf("sendMessage calls %+v", cc)
+	}
+
+	FakeYtListsMu.Lock()
+	FakeYtLists["UUsub"] = append([]FakeYtItem{{Id: "sub3", PublishedAt: "2024-01-03T00:00:00Z"}, {Id: "sub2", PublishedAt: "2024-01-02T00:00:00Z"}}, FakeYtLists["UUsub"]...)
+	FakeYtListsMu.Unlock()
+
+	YtSubscriptionsCheck(Ctx)
+	FakeTgServer.WaitFor(t, "sendAudio", chatid, 2)
+	YtSubscriptionsCheck(Ctx)
+	time.Sleep(100 * time.Millisecond)
+	cc := FakeTgServer.CallsFor("sendAudio", chatid)
+	if len(cc) != 2 {
+		t.Fatalf("sendAudio calls %+v", cc)
+	}
+	for i, c := range cc {
+		if !strings.Contains(c.Params["caption"], fmt.Sprintf("youtu.be/sub%d", i+2)) {
+			t.Errorf("sendAudio <%d> caption [%s]", i, c.Params["caption"])
+		}
+	}
+	if ss := YtSubscriptionsGet(chatid); len(ss) != 1 || ss[0].LastVideoId != "sub3" {
+		t.Errorf("subscriptions %+v", ss)
+	}
+
+	// the uploads list is paged back to the last-seen upload
+	maxresults0 := ConfigNow().YtMaxResults
+	ConfigSet(func(c *TgZeConfig) { c.YtMaxResults = 2 })
+	t.Cleanup(func() { ConfigSet(func(c *TgZeConfig) { c.YtMaxResults = maxresults0 }) })
+	FakeYtListsMu.Lock()
+	FakeYtLists["UUsub"] = append([]FakeYtItem{{Id: "sub6", PublishedAt: "2024-01-06T00:00:00Z"}, {Id: "sub5", PublishedAt: "2024-01-05T00:00:00Z"}, {Id: "sub4", PublishedAt: "2024-01-04T00:00:00Z"}}, FakeYtLists["UUsub"]...)
+	FakeYtListsMu.Unlock()
+
+	YtSubscriptionsCheck(Ctx)
+	cc = FakeTgServer.WaitFor(t, "sendAudio", chatid, 5)
+	for i, c := range cc[2:] {
+		if !strings.Contains(c.Params["caption"], fmt.Sprintf("youtu.be/sub%d", i+4)) {
+			t.Errorf("sendAudio <%d> caption [%s]", i+2, c.Params["caption"])
+		}
+	}
+	if ss := YtSubscriptionsGet(chatid); len(ss) != 1 || ss[0].LastVideoId != "sub6" {
+		t.Errorf("subscriptions %+v", ss)
+	}
+
+	u, uj = testMessageUpdate(t, chatid, "", "/unsubscribe @sub")
+	if _, err := processTgUpdate(u, uj); err != nil {
+		t.Fatalf("processTgUpdate %v", err)
+	}
+	if ss := YtSubscriptionsGet(chatid); len(ss) != 0 {
+		t.Errorf("subscriptions after unsubscribe %+v", ss)
+	}
+}
+
+func TestTgWatch(t *testing.T) {
+	const chatid = 115
+	FakeYtListsMu.Lock()
+	FakeYtLists["PLwatch"] = []FakeYtItem{{Id: "watch1"}, {Id: "watch2"}}
+	FakeYtListsMu.Unlock()
+
+	u, uj := testMessageUpdate(t, chatid, "", "/watch https://www.youtube.com/playlist?list=PLwatch removed")
+	if _, err := processTgUpdate(u, uj); err != nil {
+		t.Fatalf("processTgUpdate %v", err)
+	}
+	ww := YtListWatchesGet(chatid)
+	if len(ww) != 1 || !ww[0].ReportRemoved || len(ww[0].VideoIds) != 2 {
+		t.Fatalf("watches %+v", ww)
+	}
+
+	FakeYtListsMu.Lock()
+	FakeYtLists["PLwatch"] = []FakeYtItem{{Id: "watch1", Title: "Vidéo privée", PrivacyStatus: "private"}, {Id: "watch3"}}
+	FakeYtListsMu.Unlock()
+
+	YtListWatchesCheck(Ctx)
+	FakeTgServer.WaitFor(t, "sendAudio", chatid, 1)
+	YtListWatchesCheck(Ctx)
+	time.Sleep(100 * time.Millisecond)
+	if cc := FakeTgServer.CallsFor("sendAudio", chatid); len(cc) != 1 || !strings.Contains(cc[0].Params["caption"], "youtu.be/watch3") {
+		t.Errorf("sendAudio calls %+v", cc)
+	}
+	var report string
+	for _, c := range FakeTgServer.CallsFor("sendMessage", chatid) {
+		if strings.Contains(c.Params["text"], "gone from") {
+			report = c.Params["text"]
+		}
+	}
+	if !strings.Contains(report, tg.Esc("youtu.be/watch1 private or deleted")) || !strings.Contains(report, tg.Esc("youtu.be/watch2 removed")) {
+		t.Errorf("removed report [%s]", report)
+	}
+
+	u, uj = testMessageUpdate(t, chatid, "", "/unwatch https://www.youtube.com/playlist?list=PLwatch")
+	if _, err := processTgUpdate(u, uj); err != nil {
+		t.Fatalf("processTgUpdate %v", err)
+	}
+	if ww := YtListWatchesGet(chatid); len(ww) != 0 {
+		t.Errorf("watches after unwatch %+v", ww)
+	}
+}
+
+func TestTgPosted(t *testing.T) {
+	const chatid = -100116
+	u, uj := testMessageUpdate(t, chatid, "", "https://youtu.be/posted1")
+	if _, err := processTgUpdate(u, uj); err != nil {
+		t.Fatalf("processTgUpdate %v", err)
+	}
+	FakeTgServer.WaitFor(t, "sendAudio", chatid, 1)
+	time.Sleep(100 * time.Millisecond)
+
+	u, uj = testMessageUpdate(t, chatid, "", "https://youtu.be/posted1")
+	if _, err := processTgUpdate(u, uj); err != nil {
+		t.Fatalf("processTgUpdate %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if cc := FakeTgServer.CallsFor("sendAudio", chatid); len(cc) != 1 {
+		t.Errorf("sendAudio calls after duplicate %+v", cc)
+	}
+	posted, ok := TgPostedGet(TgPostedEntry{ChatId: chatid, VideoId: "posted1", Media: ChatMediaAudio})
+	if !ok {
+		t.Fatalf("no posted entry")
+	}
+	var reply string
+	for _, c := range FakeTgServer.CallsFor("sendMessage", chatid) {
+		reply = c.Params["text"]
+	}
+	if !strings.Contains(reply, fmt.Sprintf("(https://t.me/c/116/%d)", posted.MessageId)) {
+		t.Errorf("duplicate reply [%s]", reply)
+	}
+
+	// posted before without chapters only
+	u, uj = testMessageUpdate(t, chatid, "", "https://youtu.be/posted1 chapters")
+	if _, err := processTgUpdate(u, uj); err != nil {
+		t.Fatalf("processTgUpdate %v", err)
+	}
+	FakeTgServer.WaitFor(t, "sendAudio", chatid, 2)
+
+	u, uj = testMessageUpdate(t, chatid, "", "https://youtu.be/posted1 force")
+	if _, err := processTgUpdate(u, uj); err != nil {
+		t.Fatalf("processTgUpdate %v", err)
+	}
+	FakeTgServer.WaitFor(t, "sendAudio", chatid, 3)
+
+	ConfigMu.Lock()
+	Config.TgPosted = []TgPostedEntry{{ChatId: chatid, VideoId: "posted2", Media: ChatMediaAudio, MessageId: 1161}}
+	ConfigMu.Unlock()
+	if err := TgPostedInit(); err != nil {
+		t.Fatalf("TgPostedInit %v", err)
+	}
+	if e, ok := TgPostedGet(TgPostedEntry{ChatId: chatid, VideoId: "posted2", Media: ChatMediaAudio}); !ok || e.MessageId != 1161 {
+		t.Errorf("TgPostedGet moved entry %v %+v", ok, e)
+	}
+	if _, ok := TgPostedGet(TgPostedEntry{ChatId: chatid, VideoId: "posted1", Media: ChatMediaAudio}); !ok {
+		t.Errorf("TgPostedGet of the entry posted before lost")
+	}
+	ConfigMu.Lock()
+	defer ConfigMu.Unlock()
+	if len(Config.TgPosted) != 0 {
+		t.Errorf("Config.TgPosted %+v", Config.TgPosted)
+	}
+}
+
+func TestJobsMigrate(t *testing.T) {
+	const chatid, tochatid = 128, -100128
+	js := NewJobScheduler()
+	js.Put(&Job{Message: tg.Message{Chat: tg.Chat{Id: chatid}}, Video: YtVideo{Id: "migrate3"}})
+	js.Put(&Job{Message: tg.Message{Chat: tg.Chat{Id: chatid}}, Video: YtVideo{Id: "migrate4"}})
+
+	if migrated := js.Migrate(chatid, tochatid); migrated != 2 {
+		t.Errorf("Migrate <%d> expected <2>", migrated)
+	}
+	if _, queued, _ := js.Queue(chatid); len(queued) != 0 {
+		t.Errorf("jobs of the old chat %+v", queued)
+	}
+	_, queued, _ := js.Queue(tochatid)
+	if len(queued) != 2 || queued[0].Video.Id != "migrate3" || queued[1].Message.Chat.Id != tochatid {
+		t.Errorf("jobs of the new chat %+v", queued)
+	}
+	if j, _ := js.next(); j == nil || j.Message.Chat.Id != tochatid {
+		t.Errorf("next job %+v", j)
+	}
+}
+
+func TestJobsStop(t *testing.T) {
+	const chatid = 118
+	js := NewJobScheduler()
+	js.Start(1)
+	if unfinished := js.Stop(time.Second, func() { t.Errorf("idle workers cancelled") }); len(unfinished) != 0 {
+		t.Errorf("unfinished jobs of idle scheduler %+v", unfinished)
+	}
+
+	// no workers take the jobs of a stopped scheduler
+	for _, id := range []string{"shutdown1", "shutdown2"} {
+		js.Put(&Job{
+			UpdateId: 1180,
+			Message:  tg.Message{MessageId: 1181, Chat: tg.Chat{Id: chatid}},
+			Video:    YtVideo{Id: id},
+			Media:    ChatMediaAudio,
+			Options:  PostOptions{Language: "german"},
+		})
+	}
+	unfinished := js.Stop(time.Second, func() {})
+	if len(unfinished) != 2 || unfinished[0].Video.Id != "shutdown1" || unfinished[1].Video.Id != "shutdown2" {
+		t.Fatalf("unfinished jobs %+v", unfinished)
+	}
+
+	if err := JobsUnfinishedPut(unfinished); err != nil {
+		t.Fatalf("JobsUnfinishedPut %v", err)
+	}
+	if len(Config.JobsUnfinished) != 2 || Config.JobsUnfinished[0].Language != "german" || Config.JobsUnfinished[1].MessageId != 1181 {
+		t.Errorf("Config.JobsUnfinished %+v", Config.JobsUnfinished)
+	}
+
+	if err := JobsUnfinishedResume(); err != nil {
+		t.Fatalf("JobsUnfinishedResume %v", err)
+	}
+	if len(Config.JobsUnfinished) != 0 {
+		t.Errorf("Config.JobsUnfinished after resume %+v", Config.JobsUnfinished)
+	}
+	FakeTgServer.WaitFor(t, "sendAudio", chatid, 2)
+}
+
+func TestTgRetry(t *testing.T) {
+	const chatid = 119
+	FakeTgServer.mu.Lock()
+	FakeTgServer.Failures = append(FakeTgServer.Failures,
+		FakeTgFailure{Method: "sendAudio", ChatId: "119", Status: http.StatusTooManyRequests, Body: `{"ok":false,"error_code":429,"description":"Too Many Requests: retry after 1","parameters":{"retry_after":1}}`},
+		FakeTgFailure{Method: "setMessageReaction", ChatId: "119", Status: http.StatusBadGateway, Body: `bad gateway`},
+		FakeTgFailure{Method: "sendMessage", ChatId: "119", Status: http.StatusBadGateway, Body: `{"ok":false,"error_code":502,"description":"Bad Gateway"}`},
+	)
+	FakeTgServer.mu.Unlock()
+
+	t0 := time.Now()
+	u, uj := testMessageUpdate(t, chatid, "", "https://youtu.be/retry1")
+	if err := TgHandleUpdate(u, uj); err != nil {
+		t.Fatalf("TgHandleUpdate %v", err)
+	}
+	cc := FakeTgServer.WaitFor(t, "sendAudio", chatid, 2)
+	if d := time.Since(t0); d < time.Second {
+		t.Errorf("sendAudio repeated after <%v> before retry_after", d)
+	}
+	if cc[1].Params["audio"] == "" || cc[1].Params["caption"] != cc[0].Params["caption"] {
+		t.Errorf("sendAudio repeated with other request %+v", cc)
+	}
+	if cc := FakeTgServer.CallsFor("setMessageReaction", chatid); len(cc) != 2 {
+		t.Errorf("setMessageReaction calls %+v", cc)
+	}
+
+	// not idempotent methods are not repeated after server errors
+	if _, err := tg.SendMessage(tg.SendMessageRequest{ChatId: "119", Text: "retry"}); err == nil {
+		t.Errorf("tg.SendMessage no error")
+	}
+	if cc := FakeTgServer.CallsFor("sendMessage", chatid); len(cc) != 1 {
+		t.Errorf("sendMessage calls %+v", cc)
+	}
+}
+
+func TestTgRetryTimeout(t *testing.T) {
+	const chatid = 127
+	timeout0 := ConfigNow().TgApiTimeout
+	ConfigSet(func(c *TgZeConfig) { c.TgApiTimeout = 500 * time.Millisecond })
+	t.Cleanup(func() { ConfigSet(func(c *TgZeConfig) { c.TgApiTimeout = timeout0 }) })
+	FakeTgServer.mu.Lock()
+	FakeTgServer.Failures = append(FakeTgServer.Failures,
+		FakeTgFailure{Method: "sendMessage", ChatId: "127", Status: http.StatusTooManyRequests, Body: `{"ok":false,"error_code":429,"description":"Too Many Requests: retry after 1","parameters":{"retry_after":1}}`},
+	)
+	FakeTgServer.mu.Unlock()
+
+	// the wait for retry_after is longer than TgApiTimeout which limits only the attempts
+	if _, err := tg.SendMessage(tg.SendMessageRequest{ChatId: "127", Text: "retry"}); err != nil {
+		t.Errorf("tg.SendMessage %v", err)
+	}
+	if cc := FakeTgServer.CallsFor("sendMessage", chatid); len(cc) != 2 {
+		t.Errorf("sendMessage calls %+v", cc)
+	}
+}
+
+func TestTgRetryUpload(t *testing.T) {
+	const chatid = 125
+	FakeTgServer.mu.Lock()
+	FakeTgServer.Failures = append(FakeTgServer.Failures,
+		FakeTgFailure{Method: "sendVideo", ChatId: "125", Status: http.StatusTooManyRequests, Body: `{"ok":false,"error_code":429,"description":"Too Many Requests: retry after 1","parameters":{"retry_after":1}}`},
+	)
+	FakeTgServer.mu.Unlock()
+
+	u, uj := testMessageUpdate(t, chatid, "video", "https://youtu.be/retry2")
+	if err := TgHandleUpdate(u, uj); err != nil {
+		t.Fatalf("TgHandleUpdate %v", err)
+	}
+	cc := FakeTgServer.WaitFor(t, "sendVideo", chatid, 2)
+	if cc[1].Params["video"] == "" || cc[1].Params["caption"] != cc[0].Params["caption"] {
+		t.Errorf("sendVideo repeated with other request %+v", cc)
+	}
+
+	// the streamed upload holds back its chat and not the requests without a chat id
+	rt := tg.HttpClient.Transport.(*TgRetryTransport)
+	rt.mu.Lock()
+	_, held := rt.until[""]
+	rt.mu.Unlock()
+	if held {
+		t.Errorf("requests without chat id held back")
+	}
+}
+
+func TestTgQueueCancel(t *testing.T) {
+	const chatid = 121
+	jobs0 := Jobs
+	Jobs = NewJobScheduler()
+	t.Cleanup(func() { Jobs = jobs0 })
+
+	user := tg.User{Id: TestUserId}
+	Jobs.Put(&Job{
+		UpdateId: 1210,
+		Message:  tg.Message{MessageId: 1211, From: user, Chat: tg.Chat{Id: chatid}},
+		Video:    YtVideo{Id: "queue0"},
+		Media:    ChatMediaAudio,
+	})
+	for _, id := range []string{"queue1", "queue2"} {
+		Jobs.Put(&Job{
+			UpdateId: 1212,
+			Message:  tg.Message{MessageId: 1213, From: user, Chat: tg.Chat{Id: chatid}},
+			Video:    YtVideo{Id: id},
+			List:     &YtList{Id: "PLqueue"},
+			Media:    ChatMediaAudio,
+		})
+	}
+	ConfigMu.Lock()
+	Config.YtListJobs = append(Config.YtListJobs, YtListJob{UpdateId: 1212, ChatId: chatid})
+	ConfigMu.Unlock()
+
+	running, ctx := Jobs.next()
+	Jobs.duration = time.Minute
+
+	u, uj := testMessageUpdate(t, chatid, "", "/queue")
+	if _, err := processTgUpdate(u, uj); err != nil {
+		t.Fatalf("processTgUpdate %v", err)
+	}
+	cc := FakeTgServer.CallsFor("sendMessage", chatid)
+	if len(cc) != 1 || !strings.Contains(cc[0].Params["text"], tg.Esc("running #1 youtu.be/queue0")) || !strings.Contains(cc[0].Params["text"], tg.Esc("2. #3 youtu.be/queue2 audio starts in ~1m")) {
+		t.Fatalf("sendMessage calls %+v", cc)
+	}
+
+	u, uj = testMessageUpdate(t, chatid, "", "/cancel")
+	u.Message.ReplyToMessage = &tg.Message{MessageId: 1211}
+	if _, err := processTgUpdate(u, uj); err != nil {
+		t.Fatalf("processTgUpdate %v", err)
+	}
+	if ctx.Err() == nil {
+		t.Errorf("running job not cancelled")
+	}
+	Jobs.done(running)
+
+	u, uj = testMessageUpdate(t, chatid, "", "/cancel #2")
+	if _, err := processTgUpdate(u, uj); err != nil {
+		t.Fatalf("processTgUpdate %v", err)
+	}
+	if running, queued, _ := Jobs.Queue(chatid); running != nil || len(queued) != 0 {
+		t.Errorf("jobs after cancel %+v %+v", running, queued)
+	}
+	if slices.ContainsFunc(Config.YtListJobs, func(lj YtListJob) bool { return lj.ChatId == chatid }) {
+		t.Errorf("Config.YtListJobs after cancel %+v", Config.YtListJobs)
+	}
+	cc = FakeTgServer.CallsFor("sendMessage", chatid)
+	if len(cc) != 3 || !strings.Contains(cc[1].Params["text"], tg.Esc("<1> jobs cancelled")) || !strings.Contains(cc[2].Params["text"], tg.Esc("<2> jobs cancelled")) {
+		t.Errorf("sendMessage calls %+v", cc)
+	}
+}
+
+func TestTgAccess(t *testing.T) {
+	const chatid, userid = 122, 3
+	send := func(fromid int64, text string) {
+		t.Helper()
+		u, uj := testMessageUpdate(t, chatid, "", text)
+		u.Message.From.Id = fromid
+		if _, err := processTgUpdate(u, uj); err != nil {
+			t.Fatalf("processTgUpdate %v", err)
+		}
+	}
+	replied := func(n int, text string) {
+		t.Helper()
+		cc := FakeTgServer.CallsFor("sendMessage", chatid)
+		if len(cc) != n || !strings.Contains(cc[n-1].Params["text"], tg.Esc(text)) {
+			t.Fatalf("sendMessage calls %+v expected [%s]", cc, text)
+		}
+	}
+
+	send(userid, "https://youtu.be/access1")
+	replied(1, "sorry, this bot works only for the users and the chats allowed")
+
+	send(userid, "/allow 3 admin")
+	replied(2, "only bot admins can manage the access")
+
+	send(TestTgZeChatId, "/allow 3")
+	replied(3, "allowed id <3> role user")
+	send(TestTgZeChatId, "/quota 3 1 10")
+	replied(4, "id <3> daily quota <1> jobs <10>mb")
+
+	send(userid, "https://youtu.be/access1")
+	FakeTgServer.WaitFor(t, "sendAudio", chatid, 1)
+	send(userid, "https://youtu.be/access2")
+	replied(5, "sorry, your daily quota of <1> jobs and <10>mb is used up")
+
+	send(TestTgZeChatId, "/access")
+	replied(6, "id <3> user quota <1> jobs <10>mb used <1> jobs")
+	// the pollers check the quota of the chat they put the jobs for
+	if AccessQuota(tg.Message{Chat: tg.Chat{Id: userid}}, 1) == "" {
+		t.Errorf("AccessQuota of used up quota")
+	}
+
+	send(TestTgZeChatId, "/deny 3")
+	replied(7, "denied id <3>")
+	if AccessCheck(tg.Message{From: tg.User{Id: userid}, Chat: tg.Chat{Id: userid}}, 1) != Config.AccessRefusal {
+		t.Errorf("AccessCheck of denied user")
+	}
+
+	u, uj := testMessageUpdate(t, chatid, "", Config.TgCommandAudioCompress)
+	u.Message.From.Id = userid
+	u.Message.ReplyToMessage = &tg.Message{MessageId: 5, Chat: u.Message.Chat, Audio: tg.Audio{FileId: "file122"}}
+	if _, err := processTgUpdate(u, uj); err != nil {
+		t.Fatalf("processTgUpdate %v", err)
+	}
+	replied(8, "sorry, this bot works only for the users and the chats allowed")
+
+	send(TestTgZeChatId, "/quota 2 1 9000000000000000")
+	replied(9, "usage: /quota <id> <jobs> <mb>")
+
+	// the chats known before the access list are seeded once
+	ConfigMu.Lock()
+	Config.AccessSeeded = false
+	Config.TgAllChannelsChatIds = append(Config.TgAllChannelsChatIds, -1221)
+	ConfigMu.Unlock()
+	if err := TgPostedPut(TgPostedEntry{ChatId: 1222, VideoId: "seed1", Media: ChatMediaAudio, MessageId: 1, Time: time.Now()}); err != nil {
+		t.Fatalf("TgPostedPut %v", err)
+	}
+	if err := TgPostedPut(TgPostedEntry{ChatId: -1223, VideoId: "seed2", Media: ChatMediaAudio, MessageId: 1, Time: time.Now()}); err != nil {
+		t.Fatalf("TgPostedPut %v", err)
+	}
+	t.Cleanup(func() {
+		ConfigMu.Lock()
+		Config.TgAllChannelsChatIds = slices.DeleteFunc(Config.TgAllChannelsChatIds, func(id int64) bool { return id == -1221 })
+		Config.AccessList = slices.DeleteFunc(Config.AccessList, func(e AccessEntry) bool { return e.Id == -1221 || e.Id == 1222 || e.Id == -1223 })
+		ConfigMu.Unlock()
+	})
+	if err := AccessSeed(); err != nil {
+		t.Fatalf("AccessSeed %v", err)
+	}
+	if refusal := AccessCheck(tg.Message{Chat: tg.Chat{Id: -1221}}, 1); refusal != "" || !Config.AccessSeeded {
+		t.Errorf("AccessCheck of seeded channel [%s]", refusal)
+	}
+	if refusal := AccessCheck(tg.Message{From: tg.User{Id: 1222}, Chat: tg.Chat{Id: 1222}}, 1); refusal != "" {
+		t.Errorf("AccessCheck of seeded private chat [%s]", refusal)
+	}
+	if refusal := AccessCheck(tg.Message{From: tg.User{Id: 1224}, Chat: tg.Chat{Id: -1223, Type: "group"}}, 1); refusal != "" {
+		t.Errorf("AccessCheck of seeded group [%s]", refusal)
+	}
+}
+
+func TestTgChannels(t *testing.T) {
+	const chatid = 123
+	update := func(tgupdatejson string) {
+		t.Helper()
+		var u tg.Update
+		if err := json.Unmarshal([]byte(tgupdatejson), &u); err != nil {
+			t.Fatalf("json.Unmarshal %v", err)
+		}
+		if _, err := processTgUpdate(u, tgupdatejson); err != nil {
+			t.Fatalf("processTgUpdate %v", err)
+		}
+	}
+	member := func(chatid int64, status string) {
+		t.Helper()
+		update(fmt.Sprintf(`{"update_id":1,"my_chat_member":{"chat":{"id":%d,"type":"supergroup","title":"group"},"from":{"id":%d},"date":1,"old_chat_member":{"user":{"id":9},"status":"left"},"new_chat_member":{"user":{"id":9},"status":%q}}}`, chatid, TestUserId, status))
+	}
+
+	member(-1231, "member")
+	member(-1232, "administrator")
+	member(-1231, "kicked")
+	if cc := ChannelsGet(); slices.Contains(cc, -1231) || !slices.Contains(cc, -1232) {
+		t.Fatalf("channels after my chat member %v", cc)
+	}
+
+	member(-1233, "member")
+	if err := ChatSettingsPut(ChatSettings{ChatId: -1233, MediaMode: ChatMediaVideo}); err != nil {
+		t.Fatalf("ChatSettingsPut %v", err)
+	}
+	if err := TgPostedPut(TgPostedEntry{ChatId: -1233, VideoId: "migrate1", Media: ChatMediaVideo, MessageId: 1, Time: time.Now()}); err != nil {
+		t.Fatalf("TgPostedPut %v", err)
+	}
+	ConfigMu.Lock()
+	Config.YtListJobs = append(Config.YtListJobs, YtListJob{UpdateId: 12330, ChatId: -1233, ListId: "PLmigrate"})
+	Config.JobsUnfinished = append(Config.JobsUnfinished, JobUnfinished{UpdateId: 12331, ChatId: -1233, VideoId: "migrate2"})
+	ConfigMu.Unlock()
+	if err := AccessUse(tg.Message{Chat: tg.Chat{Id: -1233}}, 3, 0); err != nil {
+		t.Fatalf("AccessUse %v", err)
+	}
+	t.Cleanup(func() {
+		ConfigMu.Lock()
+		Config.YtListJobs = slices.DeleteFunc(Config.YtListJobs, func(lj YtListJob) bool { return lj.UpdateId == 12330 })
+		Config.JobsUnfinished = slices.DeleteFunc(Config.JobsUnfinished, func(j JobUnfinished) bool { return j.UpdateId == 12331 })
+		ConfigMu.Unlock()
+	})
+	update(`{"update_id":2,"message":{"message_id":1,"chat":{"id":-1233,"type":"group"},"migrate_to_chat_id":-1001233}}`)
+	if cc := ChannelsGet(); slices.Contains(cc, -1233) || !slices.Contains(cc, -1001233) {
+		t.Errorf("channels after migration %v", cc)
+	}
+	if cs := ChatSettingsGet(-1001233); cs.MediaMode != ChatMediaVideo {
+		t.Errorf("chat settings after migration %+v", cs)
+	}
+	if _, ok := TgPostedGet(TgPostedEntry{ChatId: -1001233, VideoId: "migrate1", Media: ChatMediaVideo}); !ok {
+		t.Errorf("posted history not migrated")
+	}
+	ConfigMu.Lock()
+	if !slices.ContainsFunc(Config.YtListJobs, func(lj YtListJob) bool { return lj.UpdateId == 12330 && lj.ChatId == -1001233 }) {
+		t.Errorf("list jobs after migration %+v", Config.YtListJobs)
+	}
+	if !slices.ContainsFunc(Config.JobsUnfinished, func(j JobUnfinished) bool { return j.UpdateId == 12331 && j.ChatId == -1001233 }) {
+		t.Errorf("unfinished jobs after migration %+v", Config.JobsUnfinished)
+	}
+	if u := accessUsage(-1001233); u.Jobs != 3 {
+		t.Errorf("access usage after migration %+v", u)
+	}
+	ConfigMu.Unlock()
+
+	for id := int64(-1001240); id > -1001252; id-- {
+		if err := ChannelsRegister(id); err != nil {
+			t.Fatalf("ChannelsRegister %v", err)
+		}
+	}
+	FakeTgServer.mu.Lock()
+	FakeTgServer.Failures = append(FakeTgServer.Failures,
+		FakeTgFailure{Method: "getChat", ChatId: "-1232", Status: http.StatusBadRequest, Body: `{"ok":false,"error_code":400,"description":"Bad Request: chat not found"}`},
+		FakeTgFailure{Method: "getChat", ChatId: "-1001240", Status: http.StatusBadRequest, Body: `{"ok":false,"error_code":400,"description":"Bad Request: group chat was upgraded to a supergroup chat","parameters":{"migrate_to_chat_id":-1001260}}`},
+	)
+	FakeTgServer.mu.Unlock()
+	if pruned := ChannelsPrune(); pruned != 2 {
+		t.Errorf("ChannelsPrune <%d>", pruned)
+	}
+	if cc := ChannelsGet(); slices.Contains(cc, -1232) || slices.Contains(cc, -1001240) || !slices.Contains(cc, -1001260) {
+		t.Errorf("channels after prune %v", cc)
+	}
+
+	u, uj := testMessageUpdate(t, chatid, "", "/channels")
+	u.Message.From.Id = TestTgZeChatId
+	if _, err := processTgUpdate(u, uj); err != nil {
+		t.Fatalf("processTgUpdate %v", err)
+	}
+	pages := (len(ChannelsGet()) + TgChannelsPageSize - 1) / TgChannelsPageSize
+	cc := FakeTgServer.CallsFor("sendMessage", chatid)
+	if len(cc) != 1 || !strings.Contains(cc[0].Params["text"], tg.Esc(F("page <1> of <%d>", pages))) || !strings.Contains(cc[0].Params["reply_markup"], "channels page 1") {
+		t.Fatalf("sendMessage calls %+v", cc)
+	}
+
+	if err := processTgCallbackQuery(TgCallbackQuery{
+		Id:      "cq123",
+		From:    tg.User{Id: TestTgZeChatId},
+		Message: tg.Message{MessageId: 1230, Chat: tg.Chat{Id: chatid}},
+		Data:    "channels page 1",
+	}); err != nil {
+		t.Fatalf("processTgCallbackQuery %v", err)
+	}
+	if cc := FakeTgServer.CallsFor("editMessageText", chatid); len(cc) != 1 || !strings.Contains(cc[0].Params["text"], tg.Esc(F("page <2> of <%d>", pages))) || !strings.Contains(cc[0].Params["reply_markup"], "channels page 0") {
+		t.Errorf("editMessageText calls %+v", cc)
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/shoce/tg"
+)
+
+const (
+	TgCommandWatchDefault   = "/watch"
+	TgCommandUnwatchDefault = "/unwatch"
+
+	// TgWatchOptionRemoved is the /watch option to report videos removed from the list
+	TgWatchOptionRemoved = "removed"
+
+	YtListWatchesIntervalDefault = time.Hour
+)
+
+// YtListWatch is a playlist which new videos are posted to the chat
+type YtListWatch struct {
+	ChatId    int64  `yaml:"ChatId"`
+	ChatTitle string `yaml:"ChatTitle"`
+
+	ListId    string `yaml:"ListId"`
+	ListTitle string `yaml:"ListTitle"`
+
+	// ReportRemoved sends the chat the videos removed from the list or made private
+	ReportRemoved bool `yaml:"ReportRemoved"`
+
+	// VideoIds are the available videos of the list already seen
+	VideoIds []string `yaml:"VideoIds,flow"`
+	// RemovedVideoIds are the seen videos not available in the list any more, they are not posted again if they come back
+	RemovedVideoIds []string `yaml:"RemovedVideoIds,flow"`
+}
+
+// Diff updates the seen videos from the list and returns the new videos and the ids of the seen videos gone from the list
+func (w *YtListWatch) Diff(ytlist *YtList) (added []YtVideo, removed []string) {
+	var seen []string
+	for _, v := range ytlist.Videos {
+		if v.Unavailable || slices.Contains(seen, v.Id) {
+			continue
+		}
+		seen = append(seen, v.Id)
+		if !slices.Contains(w.VideoIds, v.Id) && !slices.Contains(w.RemovedVideoIds, v.Id) {
+			added = append(added, v)
+		}
+	}
+
+	for _, id := range w.VideoIds {
+		if !slices.Contains(seen, id) {
+			removed = append(removed, id)
+		}
+	}
+	w.RemovedVideoIds = slices.DeleteFunc(w.RemovedVideoIds, func(id string) bool { return slices.Contains(seen, id) })
+	w.RemovedVideoIds = append(w.RemovedVideoIds, removed...)
+	w.VideoIds = seen
+
+	return added, removed
+}
+
+func (w YtListWatch) Text() string {
+	return F("%s"+NL+"youtube.com/playlist?list=%s"+NL+"<%d> videos seen", w.ListTitle, w.ListId, len(w.VideoIds))
+}
+
+func YtListWatchesGet(chatid int64) (ww []YtListWatch) {
+	ConfigMu.Lock()
+	defer ConfigMu.Unlock()
+
+	for _, w := range Config.YtListWatches {
+		if chatid == 0 || w.ChatId == chatid {
+			ww = append(ww, w)
+		}
+	}
+	return ww
+}
+
+// YtListWatchPut saves the watch replacing the one of the same chat and list
+func YtListWatchPut(w YtListWatch) error {
+	ConfigMu.Lock()
+	defer ConfigMu.Unlock()
+
+	if i := slices.IndexFunc(Config.YtListWatches, func(w2 YtListWatch) bool {
+		return w2.ChatId == w.ChatId && w2.ListId == w.ListId
+	}); i >= 0 {
+		Config.YtListWatches[i] = w
+	} else {
+		Config.YtListWatches = append(Config.YtListWatches, w)
+	}
+
+	return Config.Put()
+}
+
+func YtListWatchDelete(chatid int64, listid string) error {
+	ConfigMu.Lock()
+	defer ConfigMu.Unlock()
+
+	Config.YtListWatches = slices.DeleteFunc(Config.YtListWatches, func(w YtListWatch) bool {
+		return w.ChatId == chatid && w.ListId == listid
+	})
+
+	return Config.Put()
+}
+
+// YtListWatchesPoller checks the watched lists every YtListWatchesInterval
+func YtListWatchesPoller() {
+	for {
+		select {
+		case <-time.After(ConfigNow().YtListWatchesInterval):
+		case <-StopCtx.Done():
+			return
+		}
+		YtListWatchesCheck(StopCtx)
+	}
+}
+
+func YtListWatchesCheck(ctx context.Context) {
+	for _, w := range YtListWatchesGet(0) {
+		if err := ytListWatchCheck(ctx, w); err != nil {
+			LogSubscriptions.Error("list watch check", "chat_id", w.ChatId, "list_id", w.ListId, "err", err)
+		}
+	}
+}
+
+// ytListWatchCheck puts jobs for the new videos of the watched list and saves the seen videos before they are posted
+func ytListWatchCheck(ctx context.Context, w YtListWatch) error {
+	ytlist, err := getList(ctx, w.ListId, 0, -1)
+	if err != nil {
+		return fmt.Errorf("getList %w", err)
+	}
+
+	added, removed := w.Diff(ytlist)
+	if len(added) == 0 && len(removed) == 0 && w.ListTitle == ytlist.Title {
+		return nil
+	}
+	LogSubscriptions.Info("list watch changes", "chat_id", w.ChatId, "list_id", w.ListId, "added", len(added), "removed", len(removed))
+	chat := tg.Chat{Id: w.ChatId, Title: w.ChatTitle}
+	if len(added) > 0 {
+		if refusal := AccessQuota(tg.Message{Chat: chat}, int64(len(added))); refusal != "" {
+			// the seen videos stay so the added ones are posted when the quota allows
+			LogSubscriptions.Warn("list watch refused", "chat_id", w.ChatId, "list_id", w.ListId, "added", len(added), "refusal", refusal)
+			return nil
+		}
+	}
+	w.ListTitle = ytlist.Title
+
+	if !slices.ContainsFunc(YtListWatchesGet(w.ChatId), func(w2 YtListWatch) bool { return w2.ListId == w.ListId }) {
+		// unwatched while checking
+		return nil
+	}
+	if err := YtListWatchPut(w); err != nil {
+		return fmt.Errorf("YtListWatchPut %w", err)
+	}
+
+	chatsettings := ChatSettingsGet(w.ChatId)
+	for _, v := range added {
+		Jobs.Put(&Job{
+			Message: tg.Message{Chat: chat},
+			Video:   v,
+			Media:   chatsettings.Media(chat),
+			Options: chatsettings.PostOptions(),
+		})
+	}
+
+	if w.ReportRemoved && len(removed) > 0 {
+		text := F("<%d> videos gone from %s"+NL+"youtube.com/playlist?list=%s", len(removed), w.ListTitle, w.ListId)
+		for _, id := range removed {
+			state := "removed"
+			if slices.ContainsFunc(ytlist.Videos, func(v YtVideo) bool { return v.Id == id }) {
+				state = "private or deleted"
+			}
+			text += NL + F("youtu.be/%s %s", id, state)
+		}
+		if _, tgerr := tg.SendMessage(tg.SendMessageRequest{
+			ChatId: fmt.Sprintf("%d", w.ChatId),
+			Text:   tg.Esc(text),
+		}); tgerr != nil {
+			return fmt.Errorf("tg.SendMessage %w", tgerr)
+		}
+	}
+
+	return nil
+}
+
+func processTgWatchCommand(m tg.Message, mtff []string) error {
+	if isadmin, err := tgCheckChatAdmin(m, "watched lists"); err != nil || !isadmin {
+		return err
+	}
+
+	if len(mtff) == 1 {
+		ww := YtListWatchesGet(m.Chat.Id)
+		if len(ww) == 0 {
+			return tgReply(m, "no watched lists")
+		}
+		var tt []string
+		for _, w := range ww {
+			tt = append(tt, w.Text())
+		}
+		return tgReply(m, strings.Join(tt, NL+NL))
+	}
+
+	ytreq, ok := parseYtRequest(mtff[1:2])
+	if !ok || ytreq.ListId == "" {
+		return tgReply(m, F("not a youtube playlist url [%s]", mtff[1]))
+	}
+
+	if strings.SplitN(mtff[0], "@", 2)[0] == ConfigNow().TgCommandUnwatch {
+		for _, w := range YtListWatchesGet(m.Chat.Id) {
+			if w.ListId == ytreq.ListId {
+				if err := YtListWatchDelete(w.ChatId, w.ListId); err != nil {
+					return fmt.Errorf("YtListWatchDelete %w", err)
+				}
+				return tgReply(m, "stopped watching "+w.Text())
+			}
+		}
+		return tgReply(m, F("no watched list [%s]", ytreq.ListId))
+	}
+
+	w := YtListWatch{
+		ChatId:    m.Chat.Id,
+		ChatTitle: m.Chat.Title,
+		ListId:    ytreq.ListId,
+	}
+	if len(mtff) > 2 {
+		if mtff[2] != TgWatchOptionRemoved {
+			return tgReply(m, F("unknown option [%s], supported: %s", mtff[2], TgWatchOptionRemoved))
+		}
+		w.ReportRemoved = true
+	}
+
+	ctx, cancel := context.WithTimeout(StopCtx, ConfigNow().MetadataTimeout)
+	defer cancel()
+
+	// the videos already in the list are not posted
+	ytlist, err := getList(ctx, w.ListId, 0, -1)
+	if err != nil {
+		if err2 := tgReply(m, F("youtube playlist [%s] not found", w.ListId)); err2 != nil {
+			LogSubscriptions.Error("tgReply", "chat_id", m.Chat.Id, "err", err2)
+		}
+		return fmt.Errorf("getList %w", err)
+	}
+	w.ListTitle = ytlist.Title
+	w.Diff(ytlist)
+
+	if err := YtListWatchPut(w); err != nil {
+		return fmt.Errorf("YtListWatchPut %w", err)
+	}
+
+	return tgReply(m, "watching "+w.Text())
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/shoce/tg"
+)
+
+const (
+	TgWebhookSecretTokenHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+	TgWebhookStopTimeout = 10 * time.Second
+)
+
+var (
+	TgWebhookServer *http.Server
+)
+
+// TgWebhookStart starts the http server receiving updates and registers it with the Bot API
+func TgWebhookStart() error {
+	LogWebhook.Info("TgWebhookListen", "listen", ConfigNow().TgWebhookListen)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", TgWebhookHandler)
+
+	TgWebhookServer = &http.Server{Addr: ConfigNow().TgWebhookListen, Handler: mux}
+	go func() {
+		if err := TgWebhookServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			LogWebhook.Error("http.ListenAndServe", "err", err)
+		}
+	}()
+
+	LogWebhook.Info("TgWebhookUrl", "url", ConfigNow().TgWebhookUrl)
+
+	return TgSetWebhook(TgSetWebhookRequest{
+		Url:         ConfigNow().TgWebhookUrl,
+		SecretToken: ConfigNow().TgWebhookSecretToken,
+	})
+}
+
+// TgWebhookStop stops taking updates waiting for the updates being handled, telegram keeps the updates until the next start
+func TgWebhookStop() error {
+	if TgWebhookServer == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), TgWebhookStopTimeout)
+	defer cancel()
+	return TgWebhookServer.Shutdown(ctx)
+}
+
+func TgWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get(TgWebhookSecretTokenHeader)), []byte(ConfigNow().TgWebhookSecretToken)) != 1 {
+		LogWebhook.Warn("request with invalid secret token", "remote_addr", r.RemoteAddr)
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	tgupdatejson, err := io.ReadAll(r.Body)
+	if err != nil {
+		LogWebhook.Error("io.ReadAll", "err", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	var u tg.Update
+	if err := json.Unmarshal(tgupdatejson, &u); err != nil {
+		LogWebhook.Error("json.Unmarshal", "err", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	// processing errors are reported in the chat, telegram should not redeliver the update
+	if err := TgHandleUpdate(u, string(tgupdatejson)); err != nil {
+		LogWebhook.Error("TgHandleUpdate", "update_id", u.UpdateId, "err", err)
+	}
+
+	w.WriteHeader(http.StatusOK)
+}